@@ -3,18 +3,84 @@ CREATE TABLE `users` (
     `username` varchar(255) DEFAULT NULL,
     `is_admin` tinyint(1) DEFAULT 0,
     `password_hash` varchar(255) DEFAULT NULL,
+    `api_key_id` varchar(32) DEFAULT NULL,
     `api_key_hash` varchar(255) DEFAULT NULL,
+    `roles` varchar(255) NOT NULL DEFAULT '',
+    `email` varchar(255) NOT NULL DEFAULT '',
+    `totp_secret` varchar(64) NOT NULL DEFAULT '',
+    `totp_enabled` tinyint(1) NOT NULL DEFAULT 0,
+    `totp_last_step` bigint NOT NULL DEFAULT 0,
+    `disabled` tinyint(1) NOT NULL DEFAULT 0,
+    `disabled_reason` varchar(255) NOT NULL DEFAULT '',
+    `disabled_until` datetime DEFAULT NULL,
+    `disable_links` tinyint(1) NOT NULL DEFAULT 0,
     INDEX (username),
     INDEX (password_hash),
-    UNIQUE (`username`)
+    UNIQUE (`username`),
+    UNIQUE (`api_key_id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 
 CREATE TABLE `go` (
-    `path` varchar(255) DEFAULT NULL,
-    `target` text DEFAULT NULL,
-    `user_id` int,
-    INDEX (path),
+    `path` varchar(255) NOT NULL,
+    `target` text NOT NULL,
+    `user_id` int NOT NULL,
+    `hits` bigint NOT NULL DEFAULT 0,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX `path` (`path`),
+    INDEX (hits),
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+
+CREATE TABLE `api_keys` (
+    `id` int AUTO_INCREMENT PRIMARY KEY,
+    `user_id` int NOT NULL,
+    `name` varchar(64) NOT NULL,
+    `key_id` varchar(32) DEFAULT NULL,
+    `key_hash` varchar(255) NOT NULL,
+    `scopes` varchar(255) NOT NULL DEFAULT '*',
+    `expires_at` datetime DEFAULT NULL,
+    `last_used_at` datetime DEFAULT NULL,
+    `created_at` datetime NOT NULL,
+    INDEX (key_hash),
+    UNIQUE (`user_id`, `name`),
+    UNIQUE (`key_id`),
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+
+CREATE TABLE `recovery_codes` (
+    `user_id` int NOT NULL,
+    `code_hash` varchar(64) NOT NULL,
+    PRIMARY KEY (`user_id`, `code_hash`),
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+
+CREATE TABLE `password_reset_tokens` (
+    `user_id` int NOT NULL PRIMARY KEY,
+    `token_hash` varchar(64) NOT NULL,
+    `expires_at` datetime NOT NULL,
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+
+CREATE TABLE `password_history` (
+    `id` int AUTO_INCREMENT PRIMARY KEY,
+    `user_id` int NOT NULL,
+    `password_hash` varchar(255) NOT NULL,
+    `created_at` datetime NOT NULL,
+    INDEX (user_id),
     FOREIGN KEY (user_id)
         REFERENCES users (id)
         ON DELETE CASCADE
@@ -1,32 +1,68 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"go-there/auth"
 	"go-there/config"
 	"go-there/data"
 	"go-there/logging"
+	"go-there/notify"
+	"net/http"
 	"regexp"
+	"time"
 )
 
 // DataSourcer represents the database.DataSource methods needed by the api package to access the data.
 type DataSourcer interface {
-	SelectUser(username string) (data.UserInfo, error)
-	SelectAllUsers() ([]data.UserInfo, error)
-	SelectUserLogin(username string) (data.User, error)
-	SelectApiKeyHashByUser(username string) ([]byte, error)
-	SelectUserLoginByApiKeyHash(apiKeyHash string) (data.User, error)
-	InsertUser(user data.User) error
-	DeleteUser(username string) error
-	UpdateUserPassword(user data.User) error
-	UpdateUserApiKey(user data.User) error
-	InsertPath(path data.Path) error
-	DeletePath(path data.Path) error
+	SelectUser(ctx context.Context, username string) (data.UserInfo, error)
+	SelectAllUsers(ctx context.Context) ([]data.UserInfo, error)
+	SelectUserLogin(ctx context.Context, username string) (data.User, error)
+	SelectApiKeyHashByUser(ctx context.Context, username string) ([]byte, error)
+	SelectUserLoginByApiKeyHash(ctx context.Context, apiKeyHash string) (data.User, error)
+	SelectApiKeyLogin(ctx context.Context, keyHash string) (data.User, data.ApiKey, error)
+	SelectUserLoginByApiKeyId(ctx context.Context, keyId string) (data.User, error)
+	SelectApiKeyLoginById(ctx context.Context, keyId string) (data.User, data.ApiKey, error)
+	SelectApiKeys(ctx context.Context, userId int) ([]data.ApiKey, error)
+	InsertApiKey(ctx context.Context, key data.ApiKey) error
+	UpdateApiKeyLastUsed(ctx context.Context, id int) error
+	DeleteApiKey(ctx context.Context, key data.ApiKey) error
+	InsertUser(ctx context.Context, user data.User) error
+	DeleteUser(ctx context.Context, username string) error
+	UpdateUserPassword(ctx context.Context, user data.User) error
+	SelectPasswordHistory(ctx context.Context, userId int, n int) ([][]byte, error)
+	UpdateUserCredentials(ctx context.Context, user data.User, previousHash []byte, keepHistory int) error
+	UpdateUserRoles(ctx context.Context, user data.User) error
+	UpdateUserDisabled(ctx context.Context, user data.User) error
+	UpdateUserEmail(ctx context.Context, user data.User) error
+	UpdateUserTotp(ctx context.Context, user data.User) error
+	UpdateUserTotpLastStep(ctx context.Context, user data.User) error
+	ReplaceRecoveryCodes(ctx context.Context, userId int, codeHashes [][]byte) error
+	ConsumeRecoveryCode(ctx context.Context, userId int, codeHash []byte) error
+	ReplacePasswordResetToken(ctx context.Context, userId int, tokenHash []byte, expiresAt time.Time) error
+	CheckPasswordResetToken(ctx context.Context, userId int, tokenHash []byte) error
+	ConsumePasswordResetToken(ctx context.Context, userId int, tokenHash []byte) error
+	SelectPath(ctx context.Context, path string) (data.Path, error)
+	InsertPath(ctx context.Context, path data.Path) error
+	DeletePath(ctx context.Context, path data.Path) error
 }
 
-// Init initializes the API paths from the provided configuration and add them to the *gin.Engine.
-func Init(conf *config.Configuration, e *gin.Engine, ds DataSourcer) {
+// Init initializes the API paths from the provided configuration and add them to the *gin.Engine. The notifier is used
+// to send the password reset tokens.
+func Init(conf *config.Configuration, e *gin.Engine, ds DataSourcer, n notify.Notifier) {
+	issuer := conf.TwoFactor.Issuer
+
+	if issuer == "" {
+		issuer = defaultTotpIssuer
+	}
+
+	resetTtl := time.Duration(conf.PasswordReset.TokenTtlSec) * time.Second
+
+	if resetTtl <= 0 {
+		resetTtl = defaultPasswordResetTtl
+	}
+
 	ep := conf.Endpoints["manage_users"]
 	if ep.Enabled {
 		// Init /api/users/:user routes
@@ -37,13 +73,33 @@ func Init(conf *config.Configuration, e *gin.Engine, ds DataSourcer) {
 		}
 
 		if ep.Auth {
-			api.Use(auth.GetAuthMiddleware(ds))
-			api.Use(auth.GetPermissionsMiddleware(ep.AdminOnly))
+			api.Use(auth.GetAuthMiddleware(ds, data.ScopeUsersRead, data.ScopeUsersWrite))
 		}
 
-		api.GET("/users/:user", getUserHandler(ds))
-		api.DELETE("/users/:user", getDeleteUserHandler(ds))
-		api.PATCH("/users/:user", getUpdateUserHandler(ds))
+		// The permissions depend on the operation, so they are checked per route. The changes also check that the
+		// requested user is not more privileged than the logged user
+		api.GET("/users/:user", permissions(ep, ep.AdminOnly, data.PermUsersRead), getUserHandler(ds))
+		api.DELETE("/users/:user", permissions(ep, ep.AdminOnly, data.PermUsersDelete), manageable(ep, ds),
+			getDeleteUserHandler(ds))
+		api.PATCH("/users/:user", permissions(ep, ep.AdminOnly, data.PermUsersUpdate), manageable(ep, ds),
+			getUpdateUserHandler(ds))
+		api.GET("/users/:user/keys", permissions(ep, ep.AdminOnly, data.PermUsersRead), getApiKeyListHandler(ds))
+		api.POST("/users/:user/keys", permissions(ep, ep.AdminOnly, data.PermUsersKeys), manageable(ep, ds),
+			getCreateApiKeyHandler(ds))
+		api.DELETE("/users/:user/keys/:name", permissions(ep, ep.AdminOnly, data.PermUsersKeys), manageable(ep, ds),
+			getDeleteApiKeyHandler(ds))
+		// Users enroll their own second factor, and an empty permission is never granted by roles
+		api.POST("/users/:user/2fa", permissions(ep, ep.AdminOnly, ""), getEnrollTotpHandler(ds, issuer))
+		api.POST("/users/:user/2fa/verify", permissions(ep, ep.AdminOnly, ""), getVerifyTotpHandler(ds))
+		api.DELETE("/users/:user/2fa", permissions(ep, ep.AdminOnly, data.PermUsersUpdate), manageable(ep, ds),
+			getDisableTotpHandler(ds))
+		api.DELETE("/users/:user/lock", permissions(ep, true, data.PermUsersUpdate), manageable(ep, ds),
+			getUnlockUserHandler())
+		api.POST("/users/:user/password-reset/token", permissions(ep, true, data.PermUsersUpdate), manageable(ep, ds),
+			getSendPasswordResetHandler(ds, n, resetTtl))
+		// Users can never change their own roles
+		api.PUT("/users/:user/roles", permissions(ep, true, data.PermUsersRoles), manageable(ep, ds),
+			getSetRolesHandler(ds))
 	}
 
 	ep = conf.Endpoints["create_users"]
@@ -56,8 +112,8 @@ func Init(conf *config.Configuration, e *gin.Engine, ds DataSourcer) {
 		}
 
 		if ep.Auth {
-			userRoute.Use(auth.GetAuthMiddleware(ds))
-			userRoute.Use(auth.GetPermissionsMiddleware(ep.AdminOnly))
+			userRoute.Use(auth.GetAuthMiddleware(ds, data.ScopeUsersAdmin, data.ScopeUsersAdmin))
+			userRoute.Use(auth.GetPermissionsMiddleware(ep.AdminOnly, data.PermUsersCreate))
 		}
 
 		userRoute.POST("", getCreateHandler(ds))
@@ -73,13 +129,26 @@ func Init(conf *config.Configuration, e *gin.Engine, ds DataSourcer) {
 		}
 
 		if ep.Auth {
-			userRoute.Use(auth.GetAuthMiddleware(ds))
-			userRoute.Use(auth.GetPermissionsMiddleware(ep.AdminOnly))
+			userRoute.Use(auth.GetAuthMiddleware(ds, data.ScopeUsersAdmin, data.ScopeUsersAdmin))
+			userRoute.Use(auth.GetPermissionsMiddleware(ep.AdminOnly, data.PermUsersList))
 		}
 
 		userRoute.GET("", getUserList(ds))
 	}
 
+	ep = conf.Endpoints["password_reset"]
+	if ep.Enabled {
+		// Init the password reset routes. The reset token is the only credential, so they are never authenticated
+		path := e.Group("/api")
+
+		if ep.Log {
+			path.Use(logging.GetLoggingMiddleware())
+		}
+
+		path.POST("/password-reset", getRequestPasswordResetHandler(ds, n, resetTtl))
+		path.POST("/users/:user/password-reset", getPasswordResetHandler(ds))
+	}
+
 	ep = conf.Endpoints["manage_paths"]
 	if ep.Enabled {
 		// Init /api/path route
@@ -90,12 +159,11 @@ func Init(conf *config.Configuration, e *gin.Engine, ds DataSourcer) {
 		}
 
 		if ep.Auth {
-			path.Use(auth.GetAuthMiddleware(ds))
-			path.Use(auth.GetPermissionsMiddleware(ep.AdminOnly))
+			path.Use(auth.GetAuthMiddleware(ds, data.ScopePathsRead, data.ScopePathsWrite))
 		}
 
-		path.POST("", getPostPathHandler(ds))
-		path.DELETE("", getDeletePathHandler(ds))
+		path.POST("", permissions(ep, ep.AdminOnly, data.PermPathsCreate), getPostPathHandler(ds))
+		path.DELETE("", permissions(ep, ep.AdminOnly, data.PermPathsDelete), getDeletePathHandler(ds))
 	}
 
 	ep = conf.Endpoints["jwt_token"]
@@ -108,11 +176,69 @@ func Init(conf *config.Configuration, e *gin.Engine, ds DataSourcer) {
 		}
 
 		if ep.Auth {
-			path.Use(auth.GetAuthMiddleware(ds))
-			path.Use(auth.GetPermissionsMiddleware(ep.AdminOnly))
+			// The second factor is checked by the handlers
+			path.Use(auth.GetLoginMiddleware(ds, data.ScopeAll, data.ScopeAll))
+			path.Use(auth.GetPermissionsMiddleware(ep.AdminOnly, ""))
 		}
 
-		path.GET("", getGetJwtHandler())
+		path.GET("", getGetJwtHandler(ds, conf.TwoFactor.RequireForAdmins))
+	}
+
+	ep = conf.Endpoints["session"]
+	if ep.Enabled {
+		// Init /api/session route
+		path := e.Group("/api/session")
+
+		if ep.Log {
+			path.Use(logging.GetLoggingMiddleware())
+		}
+
+		if ep.Auth {
+			// The second factor is checked by the handlers
+			path.Use(auth.GetLoginMiddleware(ds, data.ScopeAll, data.ScopeAll))
+			path.Use(auth.GetPermissionsMiddleware(ep.AdminOnly, ""))
+		}
+
+		path.POST("", getCreateSessionHandler(ds, conf.TwoFactor.RequireForAdmins))
+		path.DELETE("", getDeleteSessionHandler())
+	}
+}
+
+// permissions returns the permissions middleware of a route, or an empty handler if the authentication is disabled for
+// the endpoint group.
+func permissions(ep config.Endpoint, adminOnly bool, permission string) gin.HandlerFunc {
+	if !ep.Auth {
+		return func(c *gin.Context) {}
+	}
+
+	return auth.GetPermissionsMiddleware(adminOnly, permission)
+}
+
+// manageable returns a middleware which checks that the logged user can act on the account of the requested user with
+// auth.CanManageUser, or an empty handler if the authentication is disabled for the endpoint group. Aborts the request
+// with http.StatusForbidden otherwise, or http.StatusNotFound if the requested user doesn't exist.
+func manageable(ep config.Endpoint, ds DataSourcer) gin.HandlerFunc {
+	if !ep.Auth {
+		return func(c *gin.Context) {}
+	}
+
+	return func(c *gin.Context) {
+		loggedUser := auth.GetLoggedUser(c)
+
+		// Avoids fetching the requested user when it cannot be more privileged
+		if loggedUser.IsAdmin || loggedUser.Username == c.Param("user") {
+			return
+		}
+
+		target, ok := selectRequestedUser(c, ds)
+
+		if !ok {
+			return
+		}
+
+		if !auth.CanManageUser(loggedUser, target) {
+			c.AbortWithStatus(http.StatusForbidden)
+		}
 	}
 }
 
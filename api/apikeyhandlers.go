@@ -0,0 +1,205 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go-there/auth"
+	"go-there/data"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// API key name validation
+var apiKeyNameRegexp = regexp.MustCompile("[a-zA-Z0-9_.-]+")
+
+const apiKeyNameMaxLen = 64
+
+// getApiKeyListHandler returns a gin handler which lists the named API keys of a user. The keys themselves are never
+// returned. Returns http.StatusNotFound if the user does not exist.
+func getApiKeyListHandler(ds DataSourcer) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		u, ok := selectRequestedUser(c, ds)
+
+		if !ok {
+			return
+		}
+
+		keys, err := ds.SelectApiKeys(c.Request.Context(), u.Id)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		infos := make([]data.ApiKeyInfo, len(keys))
+
+		for i := range keys {
+			infos[i] = apiKeyToInfo(keys[i])
+		}
+
+		c.JSON(http.StatusOK, infos)
+	}
+}
+
+// getCreateApiKeyHandler returns a gin handler which creates a new named API key for a user. If no scope is provided,
+// the key is granted all scopes. A key cannot be granted a scope the current API key does not have. Returns
+// http.StatusBadRequest if the input is invalid or if a key with the same name already exists.
+func getCreateApiKeyHandler(ds DataSourcer) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		ck := data.CreateApiKey{}
+
+		err := c.ShouldBindBodyWith(&ck, binding.JSON)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if !validateInput(ck.Name, apiKeyNameRegexp, 1, apiKeyNameMaxLen) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid api key name"})
+			return
+		}
+
+		if len(ck.Scopes) == 0 {
+			ck.Scopes = []string{data.ScopeAll}
+		}
+
+		for _, s := range ck.Scopes {
+			if !isValidScope(s) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid scope " + s})
+				return
+			}
+
+			// Do not allow a scoped key to create a key with more privileges
+			if !auth.HasScope(c, s) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+
+		if ck.ExpiresAt != nil && ck.ExpiresAt.Before(time.Now()) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid expiration date"})
+			return
+		}
+
+		u, ok := selectRequestedUser(c, ds)
+
+		if !ok {
+			return
+		}
+
+		apiKey, keyId, keyHash, err := auth.GenerateApiKey()
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		k := data.ApiKey{
+			UserId:    u.Id,
+			Name:      ck.Name,
+			KeyId:     keyId,
+			KeyHash:   keyHash,
+			Scopes:    strings.Join(ck.Scopes, ","),
+			ExpiresAt: ck.ExpiresAt,
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		}
+
+		err = ds.InsertApiKey(c.Request.Context(), k)
+
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrSqlDuplicateRow):
+				c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "api key already exists"})
+				return
+			default:
+				c.AbortWithStatus(http.StatusInternalServerError)
+				_ = c.Error(err)
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, data.CreateApiKeyResponse{ApiKeyInfo: apiKeyToInfo(k), ApiKey: apiKey})
+	}
+}
+
+// getDeleteApiKeyHandler returns a gin handler which revokes a named API key of a user. Returns http.StatusNotFound if
+// the user or the key does not exist.
+func getDeleteApiKeyHandler(ds DataSourcer) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		u, ok := selectRequestedUser(c, ds)
+
+		if !ok {
+			return
+		}
+
+		err := ds.DeleteApiKey(c.Request.Context(), data.ApiKey{UserId: u.Id, Name: c.Param("name")})
+
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrSqlNoRow):
+				c.AbortWithStatus(http.StatusNotFound)
+				return
+			default:
+				c.AbortWithStatus(http.StatusInternalServerError)
+				_ = c.Error(err)
+				return
+			}
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// selectRequestedUser fetches the user in the request path. If it fails, the request is aborted with the matching
+// status and false is returned.
+func selectRequestedUser(c *gin.Context, ds DataSourcer) (data.User, bool) {
+	u, err := ds.SelectUserLogin(c.Request.Context(), c.Param("user"))
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSqlNoRow):
+			c.AbortWithStatus(http.StatusNotFound)
+		default:
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+		}
+
+		return data.User{}, false
+	}
+
+	if u.Username == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return data.User{}, false
+	}
+
+	return u, true
+}
+
+// isValidScope returns true if the scope exists.
+func isValidScope(scope string) bool {
+	for _, s := range data.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apiKeyToInfo converts a data.ApiKey to its public data.ApiKeyInfo.
+func apiKeyToInfo(k data.ApiKey) data.ApiKeyInfo {
+	return data.ApiKeyInfo{
+		Name:       k.Name,
+		KeyId:      k.KeyId,
+		Scopes:     strings.Split(k.Scopes, ","),
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
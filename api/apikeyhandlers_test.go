@@ -0,0 +1,318 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"go-there/notify"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_getApiKeyListHandler(t *testing.T) {
+	type resp struct {
+		code int
+		body []byte
+	}
+
+	type args struct {
+		req *http.Request
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want resp
+	}{
+		{
+			name: "ok",
+			args: args{
+				req: func() *http.Request {
+					req, _ := http.NewRequest("GET", "/api/users/alice/keys", nil)
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusOK,
+				body: []byte("[{\"name\":\"ci\",\"scopes\":[\"paths:read\",\"paths:write\"],\"created_at\":\"1970-01-01T00:00:00Z\"}]"),
+			},
+		},
+		{
+			name: "no_user",
+			args: args{
+				req: func() *http.Request {
+					req, _ := http.NewRequest("GET", "/api/users/noUser/keys", nil)
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusNotFound,
+				body: nil,
+			},
+		},
+	}
+
+	conf := &config.Configuration{
+		Endpoints: func() map[string]config.Endpoint {
+			m := make(map[string]config.Endpoint)
+
+			m["manage_users"] = config.Endpoint{
+				Enabled: true,
+			}
+
+			return m
+		}(),
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	Init(conf, e, mockDataSourcer{}, notify.NewLocalNotifier())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			e.ServeHTTP(w, tt.args.req)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			assert.Equal(t, tt.want.body, w.Body.Bytes())
+		})
+	}
+}
+
+func Test_getCreateApiKeyHandler(t *testing.T) {
+	type resp struct {
+		code int
+		body []byte
+	}
+
+	type args struct {
+		req *http.Request
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want resp
+	}{
+		{
+			name: "ok",
+			args: args{
+				req: func() *http.Request {
+					body := strings.NewReader("{\"name\": \"ci\", \"scopes\": [\"paths:read\"]}")
+
+					req, _ := http.NewRequest("POST", "/api/users/alice/keys", body)
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name: "invalid_name",
+			args: args{
+				req: func() *http.Request {
+					body := strings.NewReader("{\"name\": \"c i\"}")
+
+					req, _ := http.NewRequest("POST", "/api/users/alice/keys", body)
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"invalid api key name\"}"),
+			},
+		},
+		{
+			name: "invalid_scope",
+			args: args{
+				req: func() *http.Request {
+					body := strings.NewReader("{\"name\": \"ci\", \"scopes\": [\"paths:delete\"]}")
+
+					req, _ := http.NewRequest("POST", "/api/users/alice/keys", body)
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"invalid scope paths:delete\"}"),
+			},
+		},
+		{
+			name: "expired",
+			args: args{
+				req: func() *http.Request {
+					body := strings.NewReader("{\"name\": \"ci\", \"expires_at\": \"2000-01-01T00:00:00Z\"}")
+
+					req, _ := http.NewRequest("POST", "/api/users/alice/keys", body)
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"invalid expiration date\"}"),
+			},
+		},
+		{
+			name: "key_exists",
+			args: args{
+				req: func() *http.Request {
+					body := strings.NewReader("{\"name\": \"key_exists\"}")
+
+					req, _ := http.NewRequest("POST", "/api/users/alice/keys", body)
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"api key already exists\"}"),
+			},
+		},
+		{
+			name: "key_err",
+			args: args{
+				req: func() *http.Request {
+					body := strings.NewReader("{\"name\": \"key_err\"}")
+
+					req, _ := http.NewRequest("POST", "/api/users/alice/keys", body)
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusInternalServerError,
+				body: nil,
+			},
+		},
+		{
+			name: "no_user",
+			args: args{
+				req: func() *http.Request {
+					body := strings.NewReader("{\"name\": \"ci\"}")
+
+					req, _ := http.NewRequest("POST", "/api/users/noUser/keys", body)
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusNotFound,
+				body: nil,
+			},
+		},
+	}
+
+	conf := &config.Configuration{
+		Endpoints: func() map[string]config.Endpoint {
+			m := make(map[string]config.Endpoint)
+
+			m["manage_users"] = config.Endpoint{
+				Enabled: true,
+			}
+
+			return m
+		}(),
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	Init(conf, e, mockDataSourcer{}, notify.NewLocalNotifier())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			e.ServeHTTP(w, tt.args.req)
+
+			assert.Equal(t, tt.want.code, w.Code)
+
+			if tt.want.code == http.StatusOK {
+				assert.Contains(t, w.Body.String(), "\"api_key\":")
+			} else {
+				assert.Equal(t, tt.want.body, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func Test_getDeleteApiKeyHandler(t *testing.T) {
+	type args struct {
+		req *http.Request
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want int
+	}{
+		{
+			name: "ok",
+			args: args{
+				req: func() *http.Request {
+					req, _ := http.NewRequest("DELETE", "/api/users/alice/keys/ci", nil)
+
+					return req
+				}(),
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "no_key",
+			args: args{
+				req: func() *http.Request {
+					req, _ := http.NewRequest("DELETE", "/api/users/alice/keys/no_key", nil)
+
+					return req
+				}(),
+			},
+			want: http.StatusNotFound,
+		},
+		{
+			name: "no_user",
+			args: args{
+				req: func() *http.Request {
+					req, _ := http.NewRequest("DELETE", "/api/users/noUser/keys/ci", nil)
+
+					return req
+				}(),
+			},
+			want: http.StatusNotFound,
+		},
+	}
+
+	conf := &config.Configuration{
+		Endpoints: func() map[string]config.Endpoint {
+			m := make(map[string]config.Endpoint)
+
+			m["manage_users"] = config.Endpoint{
+				Enabled: true,
+			}
+
+			return m
+		}(),
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	Init(conf, e, mockDataSourcer{}, notify.NewLocalNotifier())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			e.ServeHTTP(w, tt.args.req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
@@ -3,7 +3,6 @@ package api
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jwt"
 	"go-there/auth"
 	"go-there/data"
@@ -11,7 +10,14 @@ import (
 	"time"
 )
 
-func getGetJwtHandler() func(c *gin.Context) {
+// impersonationJwtTtl is the lifetime of the impersonation tokens.
+const impersonationJwtTtl = time.Hour
+
+// getGetJwtHandler returns a gin handler which creates a JWT for the logged user. When exchanging a password for a JWT,
+// the user must also provide a valid second factor in a X-Otp-Code header if he enabled it. If requireAdminTotp is set,
+// admins who did not enable the two-factor authentication cannot exchange their password. If the logged user is
+// impersonated, an impersonation token containing the real user is created, with a shorter lifetime.
+func getGetJwtHandler(ds DataSourcer, requireAdminTotp bool) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		u := auth.GetLoggedUser(c)
 
@@ -20,8 +26,19 @@ func getGetJwtHandler() func(c *gin.Context) {
 			return
 		}
 
+		// The second factor is the one of the user who provided the password
+		if auth.GetAuthType(c) == data.Basic && !checkPasswordLogin(c, ds, auth.GetActor(c), requireAdminTotp) {
+			return
+		}
+
+		ttl := time.Hour * 24 * 7
+
+		if auth.IsImpersonating(c) {
+			ttl = impersonationJwtTtl
+		}
+
 		t := jwt.New()
-		if err := t.Set(jwt.ExpirationKey, time.Now().Add(time.Hour*24*7).Unix()); err != nil {
+		if err := t.Set(jwt.ExpirationKey, time.Now().Add(ttl).Unix()); err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
 			_ = c.Error(fmt.Errorf("error creating a JWT: %w", err))
 			return
@@ -37,9 +54,17 @@ func getGetJwtHandler() func(c *gin.Context) {
 			return
 		}
 
+		if auth.IsImpersonating(c) {
+			if err := t.Set("actor", auth.GetActor(c).Username); err != nil {
+				c.AbortWithStatus(http.StatusInternalServerError)
+				_ = c.Error(fmt.Errorf("error creating a JWT: %w", err))
+				return
+			}
+		}
+
 		var err error
 
-		jwtBytes, err := jwt.Sign(t, jwa.RS256, auth.JwtSigningKey)
+		jwtBytes, err := jwt.Sign(t, auth.JwtSigningAlgorithm, auth.JwtSigningKey)
 
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
@@ -50,3 +75,62 @@ func getGetJwtHandler() func(c *gin.Context) {
 		c.JSON(http.StatusOK, data.JwtResponse{Jwt: string(jwtBytes)})
 	}
 }
+
+// getCreateSessionHandler returns a gin handler which creates a browser session for the logged user and sets the session
+// cookies. A session can only be created with a username/password, and the same second factor rules as the JWT
+// exchange apply. Returns http.StatusBadRequest if another authentication type is used.
+func getCreateSessionHandler(ds DataSourcer, requireAdminTotp bool) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		u := auth.GetLoggedUser(c)
+
+		if u.Username == "" || auth.GetAuthType(c) != data.Basic {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "password login required"})
+			return
+		}
+
+		// Sessions do not keep track of the real user
+		if auth.IsImpersonating(c) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "cannot create a session while impersonating"})
+			return
+		}
+
+		if !checkPasswordLogin(c, ds, u, requireAdminTotp) {
+			return
+		}
+
+		csrfToken, err := auth.CreateSession(c, u)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, data.SessionResponse{CsrfToken: csrfToken})
+	}
+}
+
+// getDeleteSessionHandler returns a gin handler which removes the session cookies.
+func getDeleteSessionHandler() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		auth.ClearSession(c)
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// checkPasswordLogin enforces the second factor of a user who authenticated with a username/password. If the user
+// enabled it, a valid code must be provided in a X-Otp-Code header. If requireAdminTotp is set, admins must have enabled
+// it. If the check fails, the request is aborted and false is returned.
+func checkPasswordLogin(c *gin.Context, ds DataSourcer, u data.User, requireAdminTotp bool) bool {
+	if u.TotpEnabled {
+		return checkSecondFactor(c, ds, u, c.GetHeader("X-Otp-Code"))
+	}
+
+	if requireAdminTotp && u.IsAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, data.ErrorResponse{Error: "2fa required for admin accounts"})
+		return false
+	}
+
+	return true
+}
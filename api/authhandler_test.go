@@ -0,0 +1,96 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/assert"
+	"go-there/auth"
+	"go-there/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_getCreateSessionHandler(t *testing.T) {
+	type args struct {
+		user     data.User
+		authType int
+		otpCode  string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want int
+	}{
+		{
+			name: "ok",
+			args: args{user: data.User{Id: 1, Username: "alice"}, authType: data.Basic},
+			want: http.StatusOK,
+		},
+		{
+			name: "ok_2fa",
+			args: args{
+				user:     data.User{Id: 4, Username: "totp_enabled", TotpSecret: testTotpSecret, TotpEnabled: true},
+				authType: data.Basic,
+				otpCode:  currentTotpCode(),
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "missing_2fa",
+			args: args{
+				user:     data.User{Id: 4, Username: "totp_enabled", TotpSecret: testTotpSecret, TotpEnabled: true},
+				authType: data.Basic,
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "api_key",
+			args: args{user: data.User{Id: 1, Username: "alice"}, authType: data.ApiKeyAuth},
+			want: http.StatusBadRequest,
+		},
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	assert.Nil(t, err)
+
+	auth.JwtSigningKey = key
+	auth.JwtSigningAlgorithm = jwa.ES256
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+			e.Use(func(c *gin.Context) {
+				c.Keys = make(map[string]interface{})
+				c.Keys["user"] = tt.args.user
+				c.Keys["authType"] = tt.args.authType
+			})
+
+			e.POST("/api/session", getCreateSessionHandler(mockDataSourcer{}, false))
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/session", nil)
+
+			if tt.args.otpCode != "" {
+				req.Header.Set("X-Otp-Code", tt.args.otpCode)
+			}
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+
+			if tt.want == http.StatusOK {
+				assert.Len(t, w.Result().Cookies(), 2)
+				assert.Contains(t, w.Body.String(), "\"csrf_token\":\"")
+			} else {
+				assert.Len(t, w.Result().Cookies(), 0)
+			}
+		})
+	}
+}
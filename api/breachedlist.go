@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Allowed length of the hex encoded SHA-1 prefixes of a breached password list
+const (
+	breachedPrefixMinLen = 8
+	breachedPrefixMaxLen = sha1.Size * 2
+)
+
+// breachedList contains the sorted SHA-1 prefixes of breached passwords. The prefixes all have the same size and are
+// stored back to back, so that a large list does not need one allocation per entry.
+type breachedList struct {
+	size     int
+	prefixes []byte
+}
+
+// loadBreachedList reads a breached password list. Each line contains the hex encoded SHA-1 prefix of a password,
+// optionally followed by a colon and a number of occurrences which is ignored. The prefixes must all have the same even
+// length, and be sorted. Empty lines are ignored. Returns an error if the file cannot be read or is invalid.
+func loadBreachedList(path string) (*breachedList, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	bl := &breachedList{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		prefix := strings.TrimSpace(scanner.Text())
+
+		if i := strings.IndexByte(prefix, ':'); i >= 0 {
+			prefix = prefix[:i]
+		}
+
+		if prefix == "" {
+			continue
+		}
+
+		if bl.size == 0 {
+			if len(prefix) < breachedPrefixMinLen || len(prefix) > breachedPrefixMaxLen || len(prefix)%2 != 0 {
+				return nil, fmt.Errorf("line %d : invalid prefix length %d", line, len(prefix))
+			}
+
+			bl.size = len(prefix) / 2
+		}
+
+		b, err := hex.DecodeString(prefix)
+
+		if err != nil || len(b) != bl.size {
+			return nil, fmt.Errorf("line %d : invalid prefix", line)
+		}
+
+		if n := len(bl.prefixes); n > 0 && bytes.Compare(bl.prefixes[n-bl.size:], b) > 0 {
+			return nil, fmt.Errorf("line %d : the list is not sorted", line)
+		}
+
+		bl.prefixes = append(bl.prefixes, b...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return bl, nil
+}
+
+// len returns the number of prefixes in the list.
+func (bl *breachedList) len() int {
+	if bl.size == 0 {
+		return 0
+	}
+
+	return len(bl.prefixes) / bl.size
+}
+
+// contains returns true if the SHA-1 of the password starts with one of the prefixes of the list.
+func (bl *breachedList) contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	prefix := sum[:bl.size]
+	n := bl.len()
+
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(bl.prefixes[i*bl.size:(i+1)*bl.size], prefix) >= 0
+	})
+
+	return i < n && bytes.Equal(bl.prefixes[i*bl.size:(i+1)*bl.size], prefix)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// writeBreachedList writes the content in a temporary breached password list and returns its path.
+func writeBreachedList(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "go-there")
+
+	assert.Nil(t, err)
+
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	path := filepath.Join(dir, "breached.txt")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+// sha1Prefix returns the n first hex characters of the SHA-1 of the password, in uppercase.
+func sha1Prefix(password string, n int) string {
+	return fmt.Sprintf("%X", sha1.Sum([]byte(password)))[:n]
+}
+
+func Test_loadBreachedList(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantLen int
+		wantErr bool
+	}{
+		{
+			name:    "ok",
+			content: "0000000000\n1234567890:12\n\nABCDEF0123:3\nabcdef0124\n",
+			wantLen: 4,
+		},
+		{
+			name:    "ok_empty",
+			content: "",
+			wantLen: 0,
+		},
+		{
+			name:    "not_sorted",
+			content: "1234567890\n0000000000\n",
+			wantErr: true,
+		},
+		{
+			name:    "different_lengths",
+			content: "1234567890\n123456789012\n",
+			wantErr: true,
+		},
+		{
+			name:    "odd_length",
+			content: "123456789\n",
+			wantErr: true,
+		},
+		{
+			name:    "too_short",
+			content: "123456\n",
+			wantErr: true,
+		},
+		{
+			name:    "not_hex",
+			content: "123456789Z\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bl, err := loadBreachedList(writeBreachedList(t, tt.content))
+
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantLen, bl.len())
+		})
+	}
+
+	_, err := loadBreachedList("/nonexistent/breached.txt")
+
+	assert.NotNil(t, err)
+}
+
+func Test_breachedList_contains(t *testing.T) {
+	// The prefixes of the list must be sorted
+	passwords := []string{"password", "123456", "qwerty", "letmein", "superpassword"}
+	prefixes := make([]string, len(passwords))
+
+	for i, p := range passwords {
+		prefixes[i] = sha1Prefix(p, 10)
+	}
+
+	sort.Strings(prefixes)
+
+	content := ""
+
+	for _, p := range prefixes {
+		content += p + ":1\n"
+	}
+
+	bl, err := loadBreachedList(writeBreachedList(t, content))
+
+	assert.Nil(t, err)
+
+	for _, p := range passwords {
+		assert.True(t, bl.contains(p), p)
+	}
+
+	assert.False(t, bl.contains("correct horse battery staple"))
+	assert.False(t, bl.contains(""))
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go-there/auth"
+	"go-there/config"
+	"go-there/data"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// Maximum number of previous passwords checked, as each check is a costly hash comparison
+const maxPasswordHistorySize = 24
+
+// Minimum length of a username for the passwords containing it to be rejected
+const minRejectedUsernameLen = 3
+
+// passwordPolicy represents the rules applied to new passwords in addition to the user rules. No rule is applied if
+// the value is zero. historySize includes the current password.
+type passwordPolicy struct {
+	minLowercase   int
+	minUppercase   int
+	minDigits      int
+	minSymbols     int
+	rejectUsername bool
+	historySize    int
+	breached       *breachedList
+}
+
+var policy = passwordPolicy{}
+
+// ApplyPasswordPolicy parses the password policy and loads the breached password list if one is configured. Returns a
+// data.ErrSettings if the policy is invalid or the list cannot be loaded.
+func ApplyPasswordPolicy(conf *config.Configuration) error {
+	pp := conf.PasswordPolicy
+
+	if pp.MinLowercase < 0 || pp.MinUppercase < 0 || pp.MinDigits < 0 || pp.MinSymbols < 0 {
+		return fmt.Errorf("%w : %s", data.ErrSettings, "invalid password policy character counts")
+	}
+
+	if pp.HistorySize < 0 || pp.HistorySize > maxPasswordHistorySize {
+		return fmt.Errorf("%w : password history size must be between 0 and %d", data.ErrSettings,
+			maxPasswordHistorySize)
+	}
+
+	p := passwordPolicy{
+		minLowercase:   pp.MinLowercase,
+		minUppercase:   pp.MinUppercase,
+		minDigits:      pp.MinDigits,
+		minSymbols:     pp.MinSymbols,
+		rejectUsername: pp.RejectUsername,
+		historySize:    pp.HistorySize,
+	}
+
+	if pp.BreachedListPath != "" {
+		bl, err := loadBreachedList(pp.BreachedListPath)
+
+		if err != nil {
+			return fmt.Errorf("%w : breached password list : %s", data.ErrSettings, err)
+		}
+
+		log.Info().Int("prefixes", bl.len()).Msg("breached password list loaded")
+
+		p.breached = bl
+	}
+
+	policy = p
+
+	return nil
+}
+
+// check returns a description of the first rule broken by the password of the user, or an empty string if the password
+// follows the policy. The password history is not checked.
+func (p passwordPolicy) check(username string, password string) string {
+	var lower, upper, digits, symbols int
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower++
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsDigit(r):
+			digits++
+		case !unicode.IsLetter(r):
+			symbols++
+		}
+	}
+
+	switch {
+	case lower < p.minLowercase:
+		return fmt.Sprintf("password must contain at least %d lowercase letter(s)", p.minLowercase)
+	case upper < p.minUppercase:
+		return fmt.Sprintf("password must contain at least %d uppercase letter(s)", p.minUppercase)
+	case digits < p.minDigits:
+		return fmt.Sprintf("password must contain at least %d digit(s)", p.minDigits)
+	case symbols < p.minSymbols:
+		return fmt.Sprintf("password must contain at least %d symbol(s)", p.minSymbols)
+	}
+
+	if p.rejectUsername && len(username) >= minRejectedUsernameLen &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return "password must not contain the username"
+	}
+
+	if p.breached != nil && p.breached.contains(password) {
+		return "password appears in a list of breached passwords"
+	}
+
+	return ""
+}
+
+// CheckPassword returns a description of the first user rule or password policy rule broken by the password of the
+// user, or an empty string if the password is valid. The password history is not checked.
+func CheckPassword(username string, password string) string {
+	if !validateInput(password, passwordRegexp, passwordMinLen, passwordMaxLen) {
+		return "invalid password"
+	}
+
+	return policy.check(username, password)
+}
+
+// validatePassword checks that the password of the user follows the user rules and the password policy. Returns false
+// and aborts the request with a description of the broken rule otherwise.
+func validatePassword(c *gin.Context, username string, password string) bool {
+	if msg := CheckPassword(username, password); msg != "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: msg})
+		return false
+	}
+
+	return true
+}
+
+// validatePasswordHistory checks that the password is neither the current password of the user nor one of his
+// previous passwords kept in the history. Returns false and aborts the request otherwise.
+func validatePasswordHistory(c *gin.Context, ds DataSourcer, u data.User, password string) bool {
+	if policy.historySize == 0 {
+		return true
+	}
+
+	hashes := [][]byte{u.PasswordHash}
+
+	if policy.historySize > 1 {
+		previous, err := ds.SelectPasswordHistory(c.Request.Context(), u.Id, policy.historySize-1)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return false
+		}
+
+		hashes = append(hashes, previous...)
+	}
+
+	for _, h := range hashes {
+		if len(h) > 0 && auth.ComparePassword(h, []byte(password)) == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{
+				Error: fmt.Sprintf("password must not be one of the last %d passwords", policy.historySize),
+			})
+			return false
+		}
+	}
+
+	return true
+}
+
+// updatePassword hashes the password of an existing user and updates it in the datasource, along with the API key of
+// newKey if it is set. The previous hash is kept in the password history if it is enabled, in the same transaction. The
+// password must have been validated first. Returns false and aborts the request if it fails, in which case nothing is
+// updated.
+func updatePassword(c *gin.Context, ds DataSourcer, u data.User, password string, newKey data.User) bool {
+	hash, err := auth.GetHashFromPassword(password)
+
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		_ = c.Error(err)
+		return false
+	}
+
+	cu := data.User{
+		Id:           u.Id,
+		Username:     u.Username,
+		PasswordHash: hash,
+		ApiKeyId:     newKey.ApiKeyId,
+		ApiKeyHash:   newKey.ApiKeyHash,
+	}
+
+	keep := 0
+
+	// The current password is always checked, so the history only keeps the previous ones
+	if policy.historySize > 1 {
+		keep = policy.historySize - 1
+	}
+
+	err = ds.UpdateUserCredentials(c.Request.Context(), cu, u.PasswordHash, keep)
+
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		_ = c.Error(err)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"go-there/data"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestApplyPasswordPolicy(t *testing.T) {
+	breachedPath := writeBreachedList(t, sha1Prefix("superpassword", 10)+"\n")
+
+	tests := []struct {
+		name    string
+		policy  config.PasswordPolicy
+		wantErr error
+	}{
+		{
+			name:   "ok_default",
+			policy: config.PasswordPolicy{},
+		},
+		{
+			name: "ok",
+			policy: config.PasswordPolicy{
+				MinLowercase:     1,
+				MinUppercase:     1,
+				MinDigits:        1,
+				MinSymbols:       1,
+				RejectUsername:   true,
+				HistorySize:      5,
+				BreachedListPath: breachedPath,
+			},
+		},
+		{
+			name:    "negative_count",
+			policy:  config.PasswordPolicy{MinDigits: -1},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name:    "history_too_large",
+			policy:  config.PasswordPolicy{HistorySize: maxPasswordHistorySize + 1},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name:    "no_breached_list",
+			policy:  config.PasswordPolicy{BreachedListPath: "/nonexistent/breached.txt"},
+			wantErr: data.ErrSettings,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyPasswordPolicy(&config.Configuration{PasswordPolicy: tt.policy})
+
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr))
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.policy.HistorySize, policy.historySize)
+			assert.Equal(t, tt.policy.BreachedListPath != "", policy.breached != nil)
+		})
+	}
+
+	policy = passwordPolicy{}
+}
+
+func Test_passwordPolicy_check(t *testing.T) {
+	bl, err := loadBreachedList(writeBreachedList(t, sha1Prefix("Sup3r-password", 10)+"\n"))
+
+	assert.Nil(t, err)
+
+	p := passwordPolicy{
+		minLowercase:   2,
+		minUppercase:   1,
+		minDigits:      1,
+		minSymbols:     1,
+		rejectUsername: true,
+		breached:       bl,
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     string
+	}{
+		{
+			name:     "ok",
+			username: "alice",
+			password: "Correct-h0rse",
+			want:     "",
+		},
+		{
+			name:     "ok_short_username",
+			username: "al",
+			password: "Correct-h0rse-al",
+			want:     "",
+		},
+		{
+			name:     "lowercase",
+			username: "alice",
+			password: "CORRECT-H0RSe",
+			want:     "password must contain at least 2 lowercase letter(s)",
+		},
+		{
+			name:     "uppercase",
+			username: "alice",
+			password: "correct-h0rse",
+			want:     "password must contain at least 1 uppercase letter(s)",
+		},
+		{
+			name:     "digits",
+			username: "alice",
+			password: "Correct-horse",
+			want:     "password must contain at least 1 digit(s)",
+		},
+		{
+			name:     "symbols",
+			username: "alice",
+			password: "Correcth0rse",
+			want:     "password must contain at least 1 symbol(s)",
+		},
+		{
+			name:     "username",
+			username: "alice",
+			password: "Correct-h0rse-ALICE",
+			want:     "password must not contain the username",
+		},
+		{
+			name:     "breached",
+			username: "alice",
+			password: "Sup3r-password",
+			want:     "password appears in a list of breached passwords",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, p.check(tt.username, tt.password))
+		})
+	}
+}
+
+func Test_getUpdateUserHandlerPassword(t *testing.T) {
+	type resp struct {
+		code int
+		body []byte
+	}
+
+	type args struct {
+		user     string
+		password string
+	}
+
+	tests := []struct {
+		name        string
+		args        args
+		want        resp
+		wantHistory []byte
+	}{
+		{
+			name: "ok",
+			args: args{
+				user:     "dave",
+				password: "supernewpassword",
+			},
+			want: resp{
+				code: http.StatusOK,
+				body: []byte("{}"),
+			},
+			wantHistory: []byte("$2a$10$5vUiFPUJJoSyIdCIhn1/n.0yxyhaHjR2L3qS1JKBh1x2UOWd2cEqi"),
+		},
+		{
+			name: "current_password",
+			args: args{
+				user:     "dave",
+				password: "superpassword",
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"password must not be one of the last 2 passwords\"}"),
+			},
+		},
+		{
+			name: "previous_password",
+			args: args{
+				user:     "alice",
+				password: "superpassword",
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"password must not be one of the last 2 passwords\"}"),
+			},
+		},
+		{
+			name: "username",
+			args: args{
+				user:     "dave",
+				password: "mynameisdave",
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"password must not contain the username\"}"),
+			},
+		},
+		{
+			name: "invalid_password",
+			args: args{
+				user:     "dave",
+				password: "short",
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"invalid password\"}"),
+			},
+		},
+		{
+			name: "no_user",
+			args: args{
+				user:     "noUser",
+				password: "supernewpassword",
+			},
+			want: resp{
+				code: http.StatusNotFound,
+				body: nil,
+			},
+		},
+	}
+
+	policy = passwordPolicy{rejectUsername: true, historySize: 2}
+
+	defer func() {
+		policy = passwordPolicy{}
+	}()
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	e.PATCH("/api/users/:user", getUpdateUserHandler(mockDataSourcer{}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passwordHistory = nil
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PATCH", "/api/users/"+tt.args.user,
+				strings.NewReader("{\"new_password\": \""+tt.args.password+"\"}"))
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			assert.Equal(t, tt.want.body, w.Body.Bytes())
+			assert.Equal(t, tt.wantHistory, passwordHistory)
+		})
+	}
+}
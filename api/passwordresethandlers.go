@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"go-there/auth"
+	"go-there/data"
+	"go-there/notify"
+	"net/http"
+	"time"
+)
+
+// Default validity of a password reset token
+const defaultPasswordResetTtl = time.Hour
+
+const passwordResetSubject = "Password reset"
+
+const passwordResetBody = `Hello %s,
+
+A password reset was requested for your account. Send the following token with your new password in a POST on
+/api/users/%s/password-reset before it expires in %s:
+
+%s
+
+If you did not request it, you can ignore this message.
+`
+
+// getRequestPasswordResetHandler returns a gin handler which sends a password reset token to a user who forgot his
+// password. It returns http.StatusOK whether the user exists or not, so that it cannot be used to find out which users
+// exist. No token is sent to users without an email or who are disabled. The requests of each user and IP address are
+// limited like the authentication failures, http.StatusTooManyRequests is returned with a Retry-After header when they
+// must wait.
+func getRequestPasswordResetHandler(ds DataSourcer, n notify.Notifier, ttl time.Duration) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		rpr := data.RequestPasswordReset{}
+
+		err := c.ShouldBindBodyWith(&rpr, binding.JSON)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		// Counted before looking up the user, so that the responses don't reveal whether he exists
+		if auth.AbortIfPasswordResetLimited(c, rpr.Username) {
+			return
+		}
+
+		auth.RegisterPasswordResetRequest(rpr.Username, c.ClientIP())
+
+		u, err := ds.SelectUserLogin(c.Request.Context(), rpr.Username)
+
+		if err != nil && !errors.Is(err, data.ErrSqlNoRow) {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		if u.Username == "" || u.Email == "" || u.IsDisabled() {
+			c.Status(http.StatusOK)
+			return
+		}
+
+		err = sendPasswordResetToken(c.Request.Context(), ds, n, u, ttl)
+
+		if err != nil {
+			log.Warn().Err(err).Str("user", u.Username).Msg("error sending password reset token")
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// getSendPasswordResetHandler returns a gin handler which sends a password reset token to the requested user. Returns
+// http.StatusNotFound if the user does not exist, and http.StatusBadRequest if he has no email.
+func getSendPasswordResetHandler(ds DataSourcer, n notify.Notifier, ttl time.Duration) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		u, ok := selectRequestedUser(c, ds)
+
+		if !ok {
+			return
+		}
+
+		if u.Email == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "user has no email"})
+			return
+		}
+
+		err := sendPasswordResetToken(c.Request.Context(), ds, n, u, ttl)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// getPasswordResetHandler returns a gin handler which changes the password of the requested user if the provided
+// password reset token is valid. The token can only be used once, and the new password must follow the user rules and
+// the password policy. The password history is only checked once the token is verified, so that it cannot be used to
+// guess the passwords of a user. The authentication failures of the user are removed once his password is changed.
+func getPasswordResetHandler(ds DataSourcer) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		pr := data.PasswordReset{}
+
+		err := c.ShouldBindBodyWith(&pr, binding.JSON)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		// Checked first so that the token is not consumed by an invalid password
+		if !validatePassword(c, c.Param("user"), pr.NewPassword) {
+			return
+		}
+
+		u, err := ds.SelectUserLogin(c.Request.Context(), c.Param("user"))
+
+		if err != nil && !errors.Is(err, data.ErrSqlNoRow) {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		// An unknown user is handled like an invalid token
+		if u.Username == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, data.ErrorResponse{Error: "invalid token"})
+			return
+		}
+
+		tokenHash := auth.HashPasswordResetToken(pr.Token)
+
+		// Checked without consuming it, so that the token is not lost if the password is rejected
+		if !checkPasswordResetToken(c, ds.CheckPasswordResetToken(c.Request.Context(), u.Id, tokenHash)) {
+			return
+		}
+
+		if !validatePasswordHistory(c, ds, u, pr.NewPassword) {
+			return
+		}
+
+		if !checkPasswordResetToken(c, ds.ConsumePasswordResetToken(c.Request.Context(), u.Id, tokenHash)) {
+			return
+		}
+
+		if !updatePassword(c, ds, u, pr.NewPassword, data.User{}) {
+			return
+		}
+
+		if err := auth.UnlockUser(c.Request.Context(), u.Username); err != nil {
+			log.Warn().Err(err).Msg("error unlocking user after a password reset")
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// checkPasswordResetToken checks the result of a password reset token verification. Returns false and aborts the
+// request with http.StatusUnauthorized if the token is invalid, or http.StatusInternalServerError if it failed.
+func checkPasswordResetToken(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, data.ErrSqlNoRow) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, data.ErrorResponse{Error: "invalid token"})
+		return false
+	}
+
+	c.AbortWithStatus(http.StatusInternalServerError)
+	_ = c.Error(err)
+
+	return false
+}
+
+// sendPasswordResetToken generates a password reset token valid for ttl, replaces the previous token of the user and
+// sends it to his email. Returns an error if the token cannot be stored or sent.
+func sendPasswordResetToken(ctx context.Context, ds DataSourcer, n notify.Notifier, u data.User, ttl time.Duration) error {
+	token, hash, err := auth.GeneratePasswordResetToken()
+
+	if err != nil {
+		return err
+	}
+
+	err = ds.ReplacePasswordResetToken(ctx, u.Id, hash, time.Now().Add(ttl))
+
+	if err != nil {
+		return err
+	}
+
+	return n.Notify(u.Email, passwordResetSubject, fmt.Sprintf(passwordResetBody, u.Username, u.Username, ttl, token))
+}
@@ -0,0 +1,337 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go-there/auth"
+	"go-there/config"
+	"go-there/notify"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_getRequestPasswordResetHandler(t *testing.T) {
+	type args struct {
+		method string
+		url    string
+		body   string
+	}
+
+	type want struct {
+		code int
+		body []byte
+		sent []string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "ok_self_service",
+			args: args{
+				method: "POST",
+				url:    "/api/password-reset",
+				body:   "{\"username\": \"alice\"}",
+			},
+			want: want{
+				code: http.StatusOK,
+				sent: []string{"alice@example.com"},
+			},
+		},
+		{
+			name: "ok_self_service_no_email",
+			args: args{
+				method: "POST",
+				url:    "/api/password-reset",
+				body:   "{\"username\": \"bob\"}",
+			},
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name: "ok_self_service_disabled",
+			args: args{
+				method: "POST",
+				url:    "/api/password-reset",
+				body:   "{\"username\": \"carol\"}",
+			},
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name: "ok_self_service_no_user",
+			args: args{
+				method: "POST",
+				url:    "/api/password-reset",
+				body:   "{\"username\": \"noUser\"}",
+			},
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name: "self_service_no_username",
+			args: args{
+				method: "POST",
+				url:    "/api/password-reset",
+				body:   "{}",
+			},
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "ok_admin",
+			args: args{
+				method: "POST",
+				url:    "/api/users/alice/password-reset/token",
+			},
+			want: want{
+				code: http.StatusOK,
+				sent: []string{"alice@example.com"},
+			},
+		},
+		{
+			name: "admin_no_email",
+			args: args{
+				method: "POST",
+				url:    "/api/users/bob/password-reset/token",
+			},
+			want: want{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"user has no email\"}"),
+			},
+		},
+		{
+			name: "admin_no_user",
+			args: args{
+				method: "POST",
+				url:    "/api/users/noUser/password-reset/token",
+			},
+			want: want{
+				code: http.StatusNotFound,
+			},
+		},
+	}
+
+	conf := &config.Configuration{
+		Endpoints: map[string]config.Endpoint{
+			"manage_users":   {Enabled: true},
+			"password_reset": {Enabled: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := notify.NewLocalNotifier()
+
+			_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+			Init(conf, e, mockDataSourcer{}, n)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.args.method, tt.args.url, strings.NewReader(tt.args.body))
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			assert.Equal(t, tt.want.body, w.Body.Bytes())
+
+			sent := make([]string, 0)
+
+			for _, m := range n.Messages() {
+				sent = append(sent, m.To)
+
+				assert.Equal(t, passwordResetSubject, m.Subject)
+				assert.Contains(t, m.Body, "/api/users/alice/password-reset")
+			}
+
+			if tt.want.sent == nil {
+				assert.Empty(t, sent)
+			} else {
+				assert.Equal(t, tt.want.sent, sent)
+			}
+		})
+	}
+}
+
+func Test_getRequestPasswordResetHandlerLimited(t *testing.T) {
+	auth.ApplyLoginSettings(&config.Configuration{Login: config.Login{
+		ProtectionEnabled: true,
+		LockoutSec:        60,
+	}}, nil)
+
+	defer auth.ApplyLoginSettings(&config.Configuration{}, nil)
+
+	conf := &config.Configuration{
+		Endpoints: map[string]config.Endpoint{
+			"password_reset": {Enabled: true},
+		},
+	}
+
+	n := notify.NewLocalNotifier()
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	Init(conf, e, mockDataSourcer{}, n)
+
+	request := func(username string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/password-reset",
+			strings.NewReader("{\"username\": \""+username+"\"}"))
+
+		e.ServeHTTP(w, req)
+
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request("alice").Code)
+
+	// The user and the IP address must wait, whether the user exists or not
+	w := request("alice")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, request("noUser").Code)
+
+	assert.Len(t, n.Messages(), 1)
+}
+
+func Test_getPasswordResetHandler(t *testing.T) {
+	type args struct {
+		user string
+		body string
+	}
+
+	type resp struct {
+		code int
+		body []byte
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want resp
+	}{
+		{
+			name: "ok",
+			args: args{
+				user: "alice",
+				body: "{\"token\": \"reset_token\", \"new_password\": \"supernewpassword\"}",
+			},
+			want: resp{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name: "invalid_token",
+			args: args{
+				user: "alice",
+				body: "{\"token\": \"bad_token\", \"new_password\": \"supernewpassword\"}",
+			},
+			want: resp{
+				code: http.StatusUnauthorized,
+				body: []byte("{\"error\":\"invalid token\"}"),
+			},
+		},
+		{
+			name: "other_user_token",
+			args: args{
+				user: "bob",
+				body: "{\"token\": \"reset_token\", \"new_password\": \"supernewpassword\"}",
+			},
+			want: resp{
+				code: http.StatusUnauthorized,
+				body: []byte("{\"error\":\"invalid token\"}"),
+			},
+		},
+		{
+			name: "no_user",
+			args: args{
+				user: "noUser",
+				body: "{\"token\": \"reset_token\", \"new_password\": \"supernewpassword\"}",
+			},
+			want: resp{
+				code: http.StatusUnauthorized,
+				body: []byte("{\"error\":\"invalid token\"}"),
+			},
+		},
+		{
+			name: "invalid_password",
+			args: args{
+				user: "alice",
+				body: "{\"token\": \"reset_token\", \"new_password\": \"short\"}",
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"invalid password\"}"),
+			},
+		},
+		{
+			name: "no_token",
+			args: args{
+				user: "alice",
+				body: "{\"new_password\": \"supernewpassword\"}",
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "previous_password",
+			args: args{
+				user: "alice",
+				body: "{\"token\": \"reset_token\", \"new_password\": \"superpassword\"}",
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"password must not be one of the last 2 passwords\"}"),
+			},
+		},
+		{
+			// The history must not reveal the passwords of the user without a valid token
+			name: "previous_password_invalid_token",
+			args: args{
+				user: "alice",
+				body: "{\"token\": \"bad_token\", \"new_password\": \"superpassword\"}",
+			},
+			want: resp{
+				code: http.StatusUnauthorized,
+				body: []byte("{\"error\":\"invalid token\"}"),
+			},
+		},
+	}
+
+	policy = passwordPolicy{historySize: 2}
+
+	defer func() {
+		policy = passwordPolicy{}
+	}()
+
+	conf := &config.Configuration{
+		Endpoints: map[string]config.Endpoint{
+			"password_reset": {Enabled: true},
+		},
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	Init(conf, e, mockDataSourcer{}, notify.NewLocalNotifier())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/users/"+tt.args.user+"/password-reset", strings.NewReader(tt.args.body))
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			assert.Equal(t, tt.want.body, w.Body.Bytes())
+		})
+	}
+}
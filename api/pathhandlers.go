@@ -30,7 +30,7 @@ func getPostPathHandler(ds DataSourcer) func(c *gin.Context) {
 			UserId: u.Id,
 		}
 
-		err = ds.InsertPath(p)
+		err = ds.InsertPath(c.Request.Context(), p)
 
 		if err != nil {
 			switch {
@@ -48,6 +48,9 @@ func getPostPathHandler(ds DataSourcer) func(c *gin.Context) {
 	}
 }
 
+// getDeletePathHandler returns a gin handler for DELETE requests when removing a redirect. Users can only remove their
+// own paths, unless one of their roles grants data.PermPathsDelete. Returns http.StatusBadRequest if it cannot bind the
+// required JSON data.
 func getDeletePathHandler(ds DataSourcer) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		u := auth.GetLoggedUser(c)
@@ -66,11 +69,27 @@ func getDeletePathHandler(ds DataSourcer) func(c *gin.Context) {
 			UserId: u.Id,
 		}
 
-		err = ds.DeletePath(p)
+		// The path is deleted whoever created it
+		if auth.HasPermission(u, data.PermPathsDelete) {
+			p, err = ds.SelectPath(c.Request.Context(), dp.Path)
+
+			switch {
+			case errors.Is(err, data.ErrSqlNoRow):
+				c.Status(http.StatusOK)
+				return
+			case err != nil:
+				c.AbortWithStatus(http.StatusInternalServerError)
+				_ = c.Error(err)
+				return
+			}
+		}
+
+		err = ds.DeletePath(c.Request.Context(), p)
 
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
 			_ = c.Error(err)
+			return
 		}
 
 		c.Status(http.StatusOK)
@@ -1,81 +1,222 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"go-there/auth"
 	"go-there/config"
 	"go-there/data"
+	"go-there/notify"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type mockDataSourcer struct {
 }
 
-func (mockDataSourcer) SelectUser(username string) (data.UserInfo, error) {
+func (mockDataSourcer) SelectUser(ctx context.Context, username string) (data.UserInfo, error) {
 	switch username {
 	}
 
 	return data.UserInfo{}, nil
 }
 
-func (mockDataSourcer) SelectAllUsers() ([]data.UserInfo, error) {
+func (mockDataSourcer) SelectAllUsers(ctx context.Context) ([]data.UserInfo, error) {
 	return nil, nil
 }
 
-func (mockDataSourcer) SelectUserLogin(username string) (data.User, error) {
+func (mockDataSourcer) SelectUserLogin(ctx context.Context, username string) (data.User, error) {
 	switch username {
+	case "alice":
+		return data.User{Id: 1, Username: "alice", Email: "alice@example.com"}, nil
+	case "bob":
+		return data.User{Id: 2, Username: "bob", Roles: "superadmin"}, nil
+	case "totp_pending":
+		return data.User{Id: 3, Username: "totp_pending", TotpSecret: testTotpSecret}, nil
+	case "totp_enabled":
+		return data.User{Id: 4, Username: "totp_enabled", TotpSecret: testTotpSecret, TotpEnabled: true}, nil
+	case "carol":
+		return data.User{Id: 5, Username: "carol", Email: "carol@example.com", Disabled: true}, nil
+	case "dave":
+		return data.User{
+			Id:           6,
+			Username:     "dave",
+			PasswordHash: []byte("$2a$10$5vUiFPUJJoSyIdCIhn1/n.0yxyhaHjR2L3qS1JKBh1x2UOWd2cEqi"),
+		}, nil
+	case "root":
+		return data.User{Id: 7, Username: "root", IsAdmin: true}, nil
+	case "noUser":
+		return data.User{}, data.ErrSqlNoRow
 	}
 
 	return data.User{}, nil
 }
 
-func (mockDataSourcer) SelectApiKeyHashByUser(username string) ([]byte, error) {
+func (mockDataSourcer) SelectApiKeyHashByUser(ctx context.Context, username string) ([]byte, error) {
 	switch username {
 	}
 
 	return []byte{}, nil
 }
 
-func (mockDataSourcer) SelectUserLoginByApiKeyHash(apiKeyHash string) (data.User, error) {
+func (mockDataSourcer) SelectUserLoginByApiKeyHash(ctx context.Context, apiKeyHash string) (data.User, error) {
 	switch apiKeyHash {
 	}
 
 	return data.User{}, nil
 }
 
-func (mockDataSourcer) InsertUser(user data.User) error {
+func (mockDataSourcer) SelectUserLoginByApiKeyId(ctx context.Context, keyId string) (data.User, error) {
+	return data.User{}, data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) SelectApiKeyLoginById(ctx context.Context, keyId string) (data.User, data.ApiKey, error) {
+	return data.User{}, data.ApiKey{}, data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) SelectApiKeyLogin(ctx context.Context, keyHash string) (data.User, data.ApiKey, error) {
+	return data.User{}, data.ApiKey{}, data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) SelectApiKeys(ctx context.Context, userId int) ([]data.ApiKey, error) {
+	switch userId {
+	case 1:
+		return []data.ApiKey{
+			{Id: 1, UserId: 1, Name: "ci", Scopes: "paths:read,paths:write", CreatedAt: time.Unix(0, 0).UTC()},
+		}, nil
+	}
+
+	return []data.ApiKey{}, nil
+}
+
+func (mockDataSourcer) InsertApiKey(ctx context.Context, key data.ApiKey) error {
+	switch key.Name {
+	case "key_exists":
+		return data.ErrSqlDuplicateRow
+	case "key_err":
+		return errors.New("key error")
+	}
+
+	return nil
+}
+
+func (mockDataSourcer) UpdateApiKeyLastUsed(ctx context.Context, id int) error {
+	return nil
+}
+
+func (mockDataSourcer) DeleteApiKey(ctx context.Context, key data.ApiKey) error {
+	switch key.Name {
+	case "no_key":
+		return data.ErrSqlNoRow
+	}
+
+	return nil
+}
+
+func (mockDataSourcer) InsertUser(ctx context.Context, user data.User) error {
 	switch user.Username {
 	}
 
 	return nil
 }
 
-func (mockDataSourcer) DeleteUser(username string) error {
+func (mockDataSourcer) DeleteUser(ctx context.Context, username string) error {
 	switch username {
 	}
 
 	return nil
 }
 
-func (mockDataSourcer) UpdateUserPassword(user data.User) error {
+func (mockDataSourcer) UpdateUserPassword(ctx context.Context, user data.User) error {
+	switch user.Username {
+	}
+
+	return nil
+}
+
+func (mockDataSourcer) SelectPasswordHistory(ctx context.Context, userId int, n int) ([][]byte, error) {
+	switch userId {
+	case 1:
+		// Previous password of alice, "superpassword"
+		return [][]byte{[]byte("$2a$10$5vUiFPUJJoSyIdCIhn1/n.0yxyhaHjR2L3qS1JKBh1x2UOWd2cEqi")}, nil
+	}
+
+	return [][]byte{}, nil
+}
+
+// passwordHistory contains the last hash added to the password history through the mock
+var passwordHistory []byte
+
+func (mockDataSourcer) UpdateUserCredentials(ctx context.Context, user data.User, previousHash []byte, keepHistory int) error {
+	if len(user.PasswordHash) > 0 && keepHistory > 0 {
+		passwordHistory = previousHash
+	}
+
+	return nil
+}
+
+func (mockDataSourcer) UpdateUserRoles(ctx context.Context, user data.User) error {
 	switch user.Username {
 	}
 
 	return nil
 }
 
-func (mockDataSourcer) UpdateUserApiKey(user data.User) error {
+func (mockDataSourcer) UpdateUserDisabled(ctx context.Context, user data.User) error {
 	switch user.Username {
+	case "error":
+		return data.ErrSql
 	}
 
 	return nil
 }
 
-func (mockDataSourcer) InsertPath(path data.Path) error {
+func (mockDataSourcer) UpdateUserEmail(ctx context.Context, user data.User) error {
+	return nil
+}
+
+func (mockDataSourcer) UpdateUserTotp(ctx context.Context, user data.User) error {
+	return nil
+}
+
+func (mockDataSourcer) UpdateUserTotpLastStep(ctx context.Context, user data.User) error {
+	return nil
+}
+
+func (mockDataSourcer) ReplaceRecoveryCodes(ctx context.Context, userId int, codeHashes [][]byte) error {
+	return nil
+}
+
+func (mockDataSourcer) ReplacePasswordResetToken(ctx context.Context, userId int, tokenHash []byte, expiresAt time.Time) error {
+	return nil
+}
+
+func (m mockDataSourcer) CheckPasswordResetToken(ctx context.Context, userId int, tokenHash []byte) error {
+	return m.ConsumePasswordResetToken(ctx, userId, tokenHash)
+}
+
+func (mockDataSourcer) ConsumePasswordResetToken(ctx context.Context, userId int, tokenHash []byte) error {
+	if userId == 1 && string(tokenHash) == string(auth.HashPasswordResetToken("reset_token")) {
+		return nil
+	}
+
+	return data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) ConsumeRecoveryCode(ctx context.Context, userId int, codeHash []byte) error {
+	if string(codeHash) == string(auth.HashRecoveryCode("recovery")) {
+		return nil
+	}
+
+	return data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) InsertPath(ctx context.Context, path data.Path) error {
 	switch path.Path {
 	case "path_ok":
 		return nil
@@ -88,9 +229,27 @@ func (mockDataSourcer) InsertPath(path data.Path) error {
 	return nil
 }
 
-func (mockDataSourcer) DeletePath(path data.Path) error {
+func (mockDataSourcer) SelectPath(ctx context.Context, path string) (data.Path, error) {
+	switch path {
+	case "path_other":
+		return data.Path{Path: "path_other", Target: "https://example.com", UserId: 2}, nil
+	case "path_err":
+		return data.Path{}, data.ErrSql
+	}
+
+	return data.Path{}, data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) DeletePath(ctx context.Context, path data.Path) error {
 	switch path.Path {
 	case "path_ok":
+		return nil
+	case "path_other":
+		// Fails if the path is not deleted along with its owner
+		if path.UserId != 2 {
+			return data.ErrSqlNoRow
+		}
+
 		return nil
 	case "path_err":
 		return errors.New("path error")
@@ -210,7 +369,7 @@ func Test_getPostPathHandler(t *testing.T) {
 
 	_, e := gin.CreateTestContext(httptest.NewRecorder())
 
-	Init(conf, e, mockDataSourcer{})
+	Init(conf, e, mockDataSourcer{}, notify.NewLocalNotifier())
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -319,7 +478,7 @@ func Test_getDeletePathHandler(t *testing.T) {
 
 	_, e := gin.CreateTestContext(httptest.NewRecorder())
 
-	Init(conf, e, mockDataSourcer{})
+	Init(conf, e, mockDataSourcer{}, notify.NewLocalNotifier())
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -332,3 +491,63 @@ func Test_getDeletePathHandler(t *testing.T) {
 		})
 	}
 }
+
+func Test_getDeletePathHandlerPermission(t *testing.T) {
+	err := auth.ApplyRoles(&config.Configuration{Roles: map[string]config.Role{
+		"link-admin": {Permissions: []string{data.PermPathsDelete}},
+	}})
+
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name     string
+		user     data.User
+		path     string
+		wantCode int
+	}{
+		{
+			name:     "other_user_path",
+			user:     data.User{Id: 1, Username: "alice", Roles: "link-admin"},
+			path:     "path_other",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "no_permission",
+			user:     data.User{Id: 1, Username: "alice"},
+			path:     "path_other",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "missing_path",
+			user:     data.User{Id: 1, Username: "alice", Roles: "link-admin"},
+			path:     "path_missing",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "db_error",
+			user:     data.User{Id: 1, Username: "alice", Roles: "link-admin"},
+			path:     "path_err",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+			e.Use(func(c *gin.Context) {
+				c.Keys = make(map[string]interface{})
+				c.Keys["user"] = tt.user
+			})
+
+			e.DELETE("/api/path", getDeletePathHandler(mockDataSourcer{}))
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("DELETE", "/api/path", strings.NewReader("{\"Path\": \""+tt.path+"\"}"))
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
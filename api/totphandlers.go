@@ -0,0 +1,240 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/skip2/go-qrcode"
+	"go-there/auth"
+	"go-there/data"
+	"net/http"
+	"time"
+)
+
+// defaultTotpIssuer is the issuer displayed by authenticator applications if none is configured.
+const defaultTotpIssuer = "go-there"
+
+// recoveryCodeCount is the number of recovery codes generated when the two-factor authentication is enabled.
+const recoveryCodeCount = 10
+
+// getEnrollTotpHandler returns a gin handler which generates a new TOTP secret for an user. The secret is only used
+// once it has been verified. Returns the provisioning URI and its QR code, or http.StatusBadRequest if the two-factor
+// authentication is already enabled.
+func getEnrollTotpHandler(ds DataSourcer, issuer string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		u, ok := selectRequestedUser(c, ds)
+
+		if !ok {
+			return
+		}
+
+		if u.TotpEnabled {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "2fa already enabled"})
+			return
+		}
+
+		secret, err := auth.GenerateTotpSecret()
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		u.TotpSecret = secret
+		u.TotpEnabled = false
+
+		err = ds.UpdateUserTotp(c.Request.Context(), u)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		uri := auth.GetTotpProvisioningUri(issuer, u.Username, secret)
+
+		png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, data.TotpEnrollResponse{
+			Secret:          secret,
+			ProvisioningUri: uri,
+			QrCodePng:       base64.StdEncoding.EncodeToString(png),
+		})
+	}
+}
+
+// getVerifyTotpHandler returns a gin handler which enables the two-factor authentication of an user once he provides a
+// valid code for his enrolled secret. Returns the recovery codes, or http.StatusBadRequest if the code is invalid or no
+// secret is enrolled.
+func getVerifyTotpHandler(ds DataSourcer) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		oc := data.OtpCode{}
+
+		err := c.ShouldBindBodyWith(&oc, binding.JSON)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		u, ok := selectRequestedUser(c, ds)
+
+		if !ok {
+			return
+		}
+
+		if u.TotpEnabled {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "2fa already enabled"})
+			return
+		}
+
+		if u.TotpSecret == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "2fa not enrolled"})
+			return
+		}
+
+		if auth.AbortIfOtpLocked(c, u.Username) {
+			return
+		}
+
+		step, ok := auth.ValidateTotp(u.TotpSecret, oc.Code, time.Now())
+		auth.RegisterOtpResult(c.Request.Context(), u.Username, ok)
+
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid 2fa code"})
+			return
+		}
+
+		codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		err = ds.ReplaceRecoveryCodes(c.Request.Context(), u.Id, hashes)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		u.TotpEnabled = true
+
+		err = ds.UpdateUserTotp(c.Request.Context(), u)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		// The code used to verify the secret cannot be reused
+		u.TotpLastStep = step
+		_ = ds.UpdateUserTotpLastStep(c.Request.Context(), u)
+
+		c.JSON(http.StatusOK, data.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// getDisableTotpHandler returns a gin handler which disables the two-factor authentication of an user. A user disabling
+// his own two-factor authentication must provide a valid code. Returns http.StatusUnauthorized if the code is invalid.
+func getDisableTotpHandler(ds DataSourcer) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		u, ok := selectRequestedUser(c, ds)
+
+		if !ok {
+			return
+		}
+
+		if !u.TotpEnabled && u.TotpSecret == "" {
+			c.Status(http.StatusOK)
+			return
+		}
+
+		if u.TotpEnabled && auth.GetLoggedUser(c).Username == u.Username {
+			oc := data.OtpCode{}
+
+			err := c.ShouldBindBodyWith(&oc, binding.JSON)
+
+			if err != nil {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+
+			if !checkSecondFactor(c, ds, u, oc.Code) {
+				return
+			}
+		}
+
+		u.TotpSecret = ""
+		u.TotpEnabled = false
+
+		err := ds.UpdateUserTotp(c.Request.Context(), u)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		err = ds.ReplaceRecoveryCodes(c.Request.Context(), u.Id, nil)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// checkSecondFactor validates a TOTP code, or consumes a recovery code, of an user. A TOTP code can only be used once.
+// If the code is invalid or the validation fails, the request is aborted with the matching status and false is
+// returned.
+func checkSecondFactor(c *gin.Context, ds DataSourcer, u data.User, code string) bool {
+	if code == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, data.ErrorResponse{Error: "2fa code required"})
+		return false
+	}
+
+	if auth.AbortIfOtpLocked(c, u.Username) {
+		return false
+	}
+
+	var err error
+
+	if step, ok := auth.ValidateTotp(u.TotpSecret, code, time.Now()); ok {
+		u.TotpLastStep = step
+		err = ds.UpdateUserTotpLastStep(c.Request.Context(), u)
+	} else {
+		err = ds.ConsumeRecoveryCode(c.Request.Context(), u.Id, auth.HashRecoveryCode(code))
+	}
+
+	if err != nil {
+		if errors.Is(err, data.ErrSqlNoRow) {
+			auth.RegisterOtpResult(c.Request.Context(), u.Username, false)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, data.ErrorResponse{Error: "invalid 2fa code"})
+			return false
+		}
+
+		c.AbortWithStatus(http.StatusInternalServerError)
+		_ = c.Error(err)
+		return false
+	}
+
+	auth.RegisterOtpResult(c.Request.Context(), u.Username, true)
+
+	return true
+}
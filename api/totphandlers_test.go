@@ -0,0 +1,237 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/assert"
+	"go-there/auth"
+	"go-there/config"
+	"go-there/data"
+	"go-there/notify"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTotpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// currentTotpCode computes the current TOTP code of testTotpSecret.
+func currentTotpCode() string {
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(testTotpSecret)
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(time.Now().Unix()/30))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func Test_getVerifyTotpHandler(t *testing.T) {
+	type resp struct {
+		code int
+		body []byte
+	}
+
+	type args struct {
+		user string
+		body string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want resp
+	}{
+		{
+			name: "ok",
+			args: args{user: "totp_pending", body: "{\"code\": \"" + currentTotpCode() + "\"}"},
+			want: resp{code: http.StatusOK},
+		},
+		{
+			name: "invalid_code",
+			args: args{user: "totp_pending", body: "{\"code\": \"000000x\"}"},
+			want: resp{code: http.StatusBadRequest, body: []byte("{\"error\":\"invalid 2fa code\"}")},
+		},
+		{
+			name: "not_enrolled",
+			args: args{user: "alice", body: "{\"code\": \"000000\"}"},
+			want: resp{code: http.StatusBadRequest, body: []byte("{\"error\":\"2fa not enrolled\"}")},
+		},
+		{
+			name: "already_enabled",
+			args: args{user: "totp_enabled", body: "{\"code\": \"000000\"}"},
+			want: resp{code: http.StatusBadRequest, body: []byte("{\"error\":\"2fa already enabled\"}")},
+		},
+	}
+
+	conf := &config.Configuration{
+		Endpoints: map[string]config.Endpoint{
+			"manage_users": {Enabled: true},
+		},
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	Init(conf, e, mockDataSourcer{}, notify.NewLocalNotifier())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/users/"+tt.args.user+"/2fa/verify", strings.NewReader(tt.args.body))
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want.code, w.Code)
+
+			if tt.want.code == http.StatusOK {
+				assert.Contains(t, w.Body.String(), "\"recovery_codes\":[")
+			} else {
+				assert.Equal(t, tt.want.body, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func Test_getEnrollTotpHandler(t *testing.T) {
+	conf := &config.Configuration{
+		Endpoints: map[string]config.Endpoint{
+			"manage_users": {Enabled: true},
+		},
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	Init(conf, e, mockDataSourcer{}, notify.NewLocalNotifier())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/users/alice/2fa", nil)
+
+	e.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "otpauth://totp/go-there:alice?")
+	assert.Contains(t, w.Body.String(), "\"qr_code_png\":\"")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/users/totp_enabled/2fa", nil)
+
+	e.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_getGetJwtHandler(t *testing.T) {
+	type args struct {
+		user     data.User
+		authType int
+		otpCode  string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want int
+	}{
+		{
+			name: "ok_no_2fa",
+			args: args{user: data.User{Id: 1, Username: "alice"}, authType: data.Basic},
+			want: http.StatusOK,
+		},
+		{
+			name: "missing_code",
+			args: args{
+				user:     data.User{Id: 4, Username: "totp_enabled", TotpSecret: testTotpSecret, TotpEnabled: true},
+				authType: data.Basic,
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "ok_code",
+			args: args{
+				user:     data.User{Id: 4, Username: "totp_enabled", TotpSecret: testTotpSecret, TotpEnabled: true},
+				authType: data.Basic,
+				otpCode:  currentTotpCode(),
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "ok_recovery_code",
+			args: args{
+				user:     data.User{Id: 4, Username: "totp_enabled", TotpSecret: testTotpSecret, TotpEnabled: true},
+				authType: data.Basic,
+				otpCode:  "recovery",
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "invalid_code",
+			args: args{
+				user:     data.User{Id: 4, Username: "totp_enabled", TotpSecret: testTotpSecret, TotpEnabled: true},
+				authType: data.Basic,
+				otpCode:  "badcode",
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "ok_jwt_renewal",
+			args: args{
+				user:     data.User{Id: 4, Username: "totp_enabled", TotpSecret: testTotpSecret, TotpEnabled: true},
+				authType: data.Jwt,
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "admin_without_2fa",
+			args: args{user: data.User{Id: 5, Username: "admin", IsAdmin: true}, authType: data.Basic},
+			want: http.StatusForbidden,
+		},
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	assert.Nil(t, err)
+
+	auth.JwtSigningKey = key
+	auth.JwtSigningAlgorithm = jwa.ES256
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+			e.Use(func(c *gin.Context) {
+				c.Keys = make(map[string]interface{})
+				c.Keys["user"] = tt.args.user
+				c.Keys["authType"] = tt.args.authType
+			})
+
+			e.GET("/api/auth", getGetJwtHandler(mockDataSourcer{}, true))
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/auth", nil)
+
+			if tt.args.otpCode != "" {
+				req.Header.Set("X-Otp-Code", tt.args.otpCode)
+			}
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
@@ -1,14 +1,16 @@
 package api
 
 import (
-	"encoding/base64"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"go-there/auth"
 	"go-there/data"
 	"net/http"
+	"net/mail"
 	"regexp"
+	"strings"
+	"time"
 )
 
 // Username default validation
@@ -22,6 +24,9 @@ var passwordRegexp *regexp.Regexp = nil
 var passwordMinLen = 8
 var passwordMaxLen = 64
 
+// Maximum length of the reason given when disabling a user
+const disabledReasonMaxLen = 255
+
 // getCreateHandler returns a gin handler which tries to insert a new user in the database. It first bind provided JSON
 // data (or fails), then hashes the password, generates an API key and tries to insert everything in the database. If it
 // succeeds, an API key is returned to the user, if the new user already exists, it returns 400 and "user already
@@ -36,26 +41,21 @@ func getCreateHandler(ds DataSourcer) func(c *gin.Context) {
 			return
 		}
 
-		if !validateInput(cu.CreateUser, usernameRegexp, usernameMinLen, usernameMaxLen) {
+		if !ValidateUsername(cu.CreateUser) {
 			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid username"})
 			return
 		}
 
-		if !validateInput(cu.CreatePassword, passwordRegexp, passwordMinLen, passwordMaxLen) {
-			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid password"})
+		if !validatePassword(c, cu.CreateUser, cu.CreatePassword) {
 			return
 		}
 
-		hash, err := auth.GetHashFromPassword(cu.CreatePassword)
-
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			_ = c.Error(err)
+		if cu.CreateEmail != "" && !ValidateEmail(cu.CreateEmail) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid email"})
 			return
 		}
 
-		// Generate a random API key
-		apiKey, err := auth.GenerateRandomB64String(16)
+		hash, err := auth.GetHashFromPassword(cu.CreatePassword)
 
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
@@ -63,8 +63,8 @@ func getCreateHandler(ds DataSourcer) func(c *gin.Context) {
 			return
 		}
 
-		// Get its corresponding hash and salt
-		apiKeyHash, err := auth.GetHashFromPassword(apiKey)
+		// Generate a random API key
+		apiKey, apiKeyId, apiKeyHash, err := auth.GenerateApiKey()
 
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
@@ -75,11 +75,13 @@ func getCreateHandler(ds DataSourcer) func(c *gin.Context) {
 		u := data.User{
 			Username:     cu.CreateUser,
 			IsAdmin:      false,
+			Email:        cu.CreateEmail,
 			PasswordHash: hash,
+			ApiKeyId:     apiKeyId,
 			ApiKeyHash:   apiKeyHash,
 		}
 
-		err = ds.InsertUser(u)
+		err = ds.InsertUser(c.Request.Context(), u)
 
 		if err != nil {
 			switch {
@@ -96,8 +98,7 @@ func getCreateHandler(ds DataSourcer) func(c *gin.Context) {
 		c.JSON(
 			http.StatusOK,
 			data.ApiKeyResponse{
-				// TODO clean that up
-				ApiKey: base64.URLEncoding.EncodeToString(append(apiKeyHash, []byte(":"+apiKey)...)),
+				ApiKey: apiKey,
 			})
 	}
 }
@@ -106,7 +107,7 @@ func getCreateHandler(ds DataSourcer) func(c *gin.Context) {
 // does not exist
 func getUserHandler(ds DataSourcer) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		u, err := ds.SelectUser(c.Param("user"))
+		u, err := ds.SelectUser(c.Request.Context(), c.Param("user"))
 
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
@@ -126,7 +127,7 @@ func getUserHandler(ds DataSourcer) func(c *gin.Context) {
 // getUserHandler returns a gin handler which delete an user in the datasource.
 func getDeleteUserHandler(ds DataSourcer) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		err := ds.DeleteUser(c.Param("user"))
+		err := ds.DeleteUser(c.Request.Context(), c.Param("user"))
 
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
@@ -138,7 +139,8 @@ func getDeleteUserHandler(ds DataSourcer) func(c *gin.Context) {
 	}
 }
 
-// getUpdateUserHandler returns a gin handler which updates an user in the datasource from the request body.
+// getUpdateUserHandler returns a gin handler which updates an user in the datasource from the request body. Only admins
+// can change the disabled state of an user.
 func getUpdateUserHandler(ds DataSourcer) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		pu := data.PatchUser{}
@@ -153,64 +155,225 @@ func getUpdateUserHandler(ds DataSourcer) func(c *gin.Context) {
 		u := data.User{Username: c.Param("user")}
 		ar := data.ApiKeyResponse{}
 
+		// Checked before any change is made
+		if pu.PatchEmail != "" && !ValidateEmail(pu.PatchEmail) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid email"})
+			return
+		}
+
+		if pu.Disabled != nil && !validateDisable(c, u.Username, pu) {
+			return
+		}
+
+		var target data.User
+
 		if pu.PatchPassword != "" {
-			hash, err := auth.GetHashFromPassword(pu.PatchPassword)
+			var ok bool
 
-			if err != nil {
-				c.AbortWithStatus(http.StatusInternalServerError)
-				_ = c.Error(err)
+			// The current and previous passwords of the user are needed to validate the new one
+			target, ok = selectRequestedUser(c, ds)
+
+			if !ok {
 				return
 			}
 
-			u.PasswordHash = hash
+			if !validatePassword(c, target.Username, pu.PatchPassword) ||
+				!validatePasswordHistory(c, ds, target, pu.PatchPassword) {
+				return
+			}
+		}
 
-			err = ds.UpdateUserPassword(u)
+		if pu.PatchApiKey {
+			apiKey, apiKeyId, apiKeyHash, err := auth.GenerateApiKey()
 
 			if err != nil {
 				c.AbortWithStatus(http.StatusInternalServerError)
 				_ = c.Error(err)
 				return
 			}
+
+			u.ApiKeyId = apiKeyId
+			u.ApiKeyHash = apiKeyHash
+			ar.ApiKey = apiKey
 		}
 
-		if pu.PatchApiKey {
-			apiKey, err := auth.GenerateRandomB64String(16)
+		// The password and the API key are updated together, so that a failure leaves both unchanged
+		if pu.PatchPassword != "" {
+			if !updatePassword(c, ds, target, pu.PatchPassword, u) {
+				return
+			}
+		} else if pu.PatchApiKey {
+			err = ds.UpdateUserCredentials(c.Request.Context(), u, nil, 0)
 
 			if err != nil {
 				c.AbortWithStatus(http.StatusInternalServerError)
 				_ = c.Error(err)
 				return
 			}
+		}
+
+		if pu.PatchEmail != "" {
+			u.Email = pu.PatchEmail
 
-			apiKeyHash, err := auth.GetHashFromPassword(apiKey)
+			err = ds.UpdateUserEmail(c.Request.Context(), u)
 
 			if err != nil {
 				c.AbortWithStatus(http.StatusInternalServerError)
 				_ = c.Error(err)
 				return
 			}
+		}
 
-			u.ApiKeyHash = apiKeyHash
+		if pu.Disabled != nil {
+			u.Disabled = *pu.Disabled
 
-			err = ds.UpdateUserApiKey(u)
+			// Enabling a user clears the previous suspension
+			if u.Disabled {
+				u.DisabledReason = pu.DisabledReason
+				u.DisableLinks = pu.DisableLinks
+
+				if pu.DisabledUntil != nil {
+					until := pu.DisabledUntil.UTC()
+					u.DisabledUntil = &until
+				}
+			}
+
+			err = ds.UpdateUserDisabled(c.Request.Context(), u)
 
 			if err != nil {
 				c.AbortWithStatus(http.StatusInternalServerError)
 				_ = c.Error(err)
 				return
 			}
-
-			ar.ApiKey = apiKey
 		}
 
 		c.JSON(http.StatusOK, ar)
 	}
 }
 
+// validateDisable checks that the logged user can change the disabled state of the user, and that the suspension is
+// valid. Only admins can disable users, and they cannot disable themselves. Returns false and aborts the request if the
+// change is not allowed.
+func validateDisable(c *gin.Context, username string, pu data.PatchUser) bool {
+	loggedUser := auth.GetLoggedUser(c)
+
+	if !loggedUser.IsAdmin {
+		c.AbortWithStatus(http.StatusForbidden)
+		return false
+	}
+
+	if !*pu.Disabled {
+		return true
+	}
+
+	if loggedUser.Username == username {
+		c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "cannot disable your own account"})
+		return false
+	}
+
+	if len(pu.DisabledReason) > disabledReasonMaxLen {
+		c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid disabled reason"})
+		return false
+	}
+
+	if pu.DisabledUntil != nil && !pu.DisabledUntil.After(time.Now()) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid disabled until date"})
+		return false
+	}
+
+	return true
+}
+
+// getUnlockUserHandler returns a gin handler which removes the authentication failures of an user, lifting any backoff
+// or lockout.
+func getUnlockUserHandler() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		err := auth.UnlockUser(c.Request.Context(), c.Param("user"))
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// getSetRolesHandler returns a gin handler which replaces the roles of an user. Every role must be defined in the
+// configuration, and the logged user can only grant or remove roles whose permissions he has. Returns
+// http.StatusBadRequest if a role does not exist and http.StatusNotFound if the user does not exist.
+func getSetRolesHandler(ds DataSourcer) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		sr := data.SetRoles{}
+
+		err := c.ShouldBindBodyWith(&sr, binding.JSON)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		roles := make([]string, 0, len(sr.Roles))
+		set := make(map[string]bool)
+
+		for _, r := range sr.Roles {
+			if !auth.IsRole(r) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid role " + r})
+				return
+			}
+
+			if !set[r] {
+				set[r] = true
+				roles = append(roles, r)
+			}
+		}
+
+		u, ok := selectRequestedUser(c, ds)
+
+		if !ok {
+			return
+		}
+
+		loggedUser := auth.GetLoggedUser(c)
+		current := make(map[string]bool)
+
+		for _, r := range u.RoleList() {
+			current[r] = true
+
+			// Removed roles
+			if !set[r] && !auth.CanGrantRole(loggedUser, r) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+
+		for _, r := range roles {
+			// Added roles
+			if !current[r] && !auth.CanGrantRole(loggedUser, r) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+
+		u.Roles = strings.Join(roles, ",")
+
+		err = ds.UpdateUserRoles(c.Request.Context(), u)
+
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
 // getUserList returns a gin handler which fetch the list of all users in the datasource.
 func getUserList(ds DataSourcer) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		users, err := ds.SelectAllUsers()
+		users, err := ds.SelectAllUsers(c.Request.Context())
 
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
@@ -222,6 +385,18 @@ func getUserList(ds DataSourcer) func(c *gin.Context) {
 	}
 }
 
+// ValidateUsername returns true if the username follows the user rules.
+func ValidateUsername(username string) bool {
+	return validateInput(username, usernameRegexp, usernameMinLen, usernameMaxLen)
+}
+
+// ValidateEmail returns true if the input is a single email address, without any display name.
+func ValidateEmail(email string) bool {
+	a, err := mail.ParseAddress(email)
+
+	return err == nil && a.Address == email
+}
+
 // validateInput checks the input max and min length, and checks for a perfect match against the regexp defined in the
 // settings.
 func validateInput(input string, regexp *regexp.Regexp, minLen int, maxLen int) bool {
@@ -1,11 +1,17 @@
 package api
 
 import (
-	"encoding/base64"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"go-there/auth"
+	"go-there/config"
+	"go-there/data"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 // BenchmarkUserCreation mostly used to generate test passwords
@@ -18,20 +24,16 @@ func BenchmarkUserCreation(b *testing.B) {
 		}
 
 		// Generate a random API key
-		apiKey, err := auth.GenerateRandomB64String(16)
+		apiKey, apiKeyId, apiKeyHash, err := auth.GenerateApiKey()
 
 		if err != nil {
 			return
 		}
 
-		// Get its corresponding hash
-		apiKeyHash, _ := auth.GetHashFromPassword(apiKey)
-
-		fullApiKey := base64.URLEncoding.EncodeToString(append(apiKeyHash, []byte(":"+apiKey)...))
-
 		_ = hash
+		_ = apiKey
+		_ = apiKeyId
 		_ = apiKeyHash
-		_ = fullApiKey
 	}
 }
 
@@ -129,3 +131,351 @@ func Test_validateInput(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		want  bool
+	}{
+		{
+			name:  "ok",
+			email: "alice@example.com",
+			want:  true,
+		},
+		{
+			name:  "no_domain",
+			email: "alice",
+			want:  false,
+		},
+		{
+			name:  "display_name",
+			email: "Alice <alice@example.com>",
+			want:  false,
+		},
+		{
+			name:  "multiple",
+			email: "alice@example.com, bob@example.com",
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ValidateEmail(tt.email))
+		})
+	}
+}
+
+func Test_getSetRolesHandler(t *testing.T) {
+	type resp struct {
+		code int
+		body []byte
+	}
+
+	type args struct {
+		body string
+		user string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want resp
+	}{
+		{
+			name: "ok",
+			args: args{
+				body: "{\"roles\": [\"viewer\"]}",
+				user: "alice",
+			},
+			want: resp{
+				code: http.StatusOK,
+				body: nil,
+			},
+		},
+		{
+			name: "invalid_role",
+			args: args{
+				body: "{\"roles\": [\"unknown\"]}",
+				user: "alice",
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"invalid role unknown\"}"),
+			},
+		},
+		{
+			name: "grant_more_permissions",
+			args: args{
+				body: "{\"roles\": [\"superadmin\"]}",
+				user: "alice",
+			},
+			want: resp{
+				code: http.StatusForbidden,
+				body: nil,
+			},
+		},
+		{
+			name: "remove_more_permissions",
+			args: args{
+				body: "{\"roles\": []}",
+				user: "bob",
+			},
+			want: resp{
+				code: http.StatusForbidden,
+				body: nil,
+			},
+		},
+		{
+			name: "no_user",
+			args: args{
+				body: "{\"roles\": [\"viewer\"]}",
+				user: "noUser",
+			},
+			want: resp{
+				code: http.StatusNotFound,
+				body: nil,
+			},
+		},
+	}
+
+	err := auth.ApplyRoles(&config.Configuration{Roles: map[string]config.Role{
+		"viewer":     {Permissions: []string{data.PermUsersRead}},
+		"helpdesk":   {Permissions: []string{data.PermUsersRead, data.PermUsersRoles}},
+		"superadmin": {Permissions: []string{data.PermAll}},
+	}})
+
+	assert.Nil(t, err)
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	e.Use(func(c *gin.Context) {
+		c.Keys = make(map[string]interface{})
+		c.Keys["user"] = data.User{Username: "carol", Roles: "helpdesk"}
+	})
+
+	e.PUT("/api/users/:user/roles", getSetRolesHandler(mockDataSourcer{}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", "/api/users/"+tt.args.user+"/roles", strings.NewReader(tt.args.body))
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			assert.Equal(t, tt.want.body, w.Body.Bytes())
+		})
+	}
+}
+
+func Test_getUpdateUserHandlerDisable(t *testing.T) {
+	type resp struct {
+		code int
+		body []byte
+	}
+
+	type args struct {
+		body    string
+		user    string
+		isAdmin bool
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want resp
+	}{
+		{
+			name: "ok_disable",
+			args: args{
+				body:    "{\"disabled\": true, \"disabled_reason\": \"left the company\", \"disable_links\": true}",
+				user:    "alice",
+				isAdmin: true,
+			},
+			want: resp{
+				code: http.StatusOK,
+				body: []byte("{}"),
+			},
+		},
+		{
+			name: "ok_suspend",
+			args: args{
+				body:    "{\"disabled\": true, \"disabled_until\": \"" + time.Now().Add(time.Hour).Format(time.RFC3339) + "\"}",
+				user:    "alice",
+				isAdmin: true,
+			},
+			want: resp{
+				code: http.StatusOK,
+				body: []byte("{}"),
+			},
+		},
+		{
+			name: "ok_enable_self",
+			args: args{
+				body:    "{\"disabled\": false}",
+				user:    "root",
+				isAdmin: true,
+			},
+			want: resp{
+				code: http.StatusOK,
+				body: []byte("{}"),
+			},
+		},
+		{
+			name: "not_admin",
+			args: args{
+				body: "{\"disabled\": false}",
+				user: "root",
+			},
+			want: resp{
+				code: http.StatusForbidden,
+				body: nil,
+			},
+		},
+		{
+			name: "disable_self",
+			args: args{
+				body:    "{\"disabled\": true}",
+				user:    "root",
+				isAdmin: true,
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"cannot disable your own account\"}"),
+			},
+		},
+		{
+			name: "reason_too_long",
+			args: args{
+				body:    "{\"disabled\": true, \"disabled_reason\": \"" + strings.Repeat("a", 256) + "\"}",
+				user:    "alice",
+				isAdmin: true,
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"invalid disabled reason\"}"),
+			},
+		},
+		{
+			name: "until_in_the_past",
+			args: args{
+				body:    "{\"disabled\": true, \"disabled_until\": \"2000-01-01T00:00:00Z\"}",
+				user:    "alice",
+				isAdmin: true,
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: []byte("{\"error\":\"invalid disabled until date\"}"),
+			},
+		},
+		{
+			name: "db_err",
+			args: args{
+				body:    "{\"disabled\": true}",
+				user:    "error",
+				isAdmin: true,
+			},
+			want: resp{
+				code: http.StatusInternalServerError,
+				body: nil,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+			e.Use(func(c *gin.Context) {
+				c.Keys = make(map[string]interface{})
+				c.Keys["user"] = data.User{Username: "root", IsAdmin: tt.args.isAdmin}
+			})
+
+			e.PATCH("/api/users/:user", getUpdateUserHandler(mockDataSourcer{}))
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PATCH", "/api/users/"+tt.args.user, strings.NewReader(tt.args.body))
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			assert.Equal(t, tt.want.body, w.Body.Bytes())
+		})
+	}
+}
+
+func Test_manageable(t *testing.T) {
+	err := auth.ApplyRoles(&config.Configuration{Roles: map[string]config.Role{
+		"helpdesk":   {Permissions: []string{data.PermUsersRead, data.PermUsersUpdate}},
+		"superadmin": {Permissions: []string{data.PermAll}},
+	}})
+
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name     string
+		logged   data.User
+		target   string
+		wantCode int
+	}{
+		{
+			name:     "regular_user",
+			logged:   data.User{Username: "carol", Roles: "helpdesk"},
+			target:   "alice",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "admin_target",
+			logged:   data.User{Username: "carol", Roles: "helpdesk"},
+			target:   "root",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "privileged_target",
+			logged:   data.User{Username: "carol", Roles: "helpdesk"},
+			target:   "bob",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "self",
+			logged:   data.User{Username: "bob", Roles: "superadmin"},
+			target:   "bob",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "admin",
+			logged:   data.User{Username: "root", IsAdmin: true},
+			target:   "bob",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "no_user",
+			logged:   data.User{Username: "carol", Roles: "helpdesk"},
+			target:   "noUser",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+			e.Use(func(c *gin.Context) {
+				c.Keys = make(map[string]interface{})
+				c.Keys["user"] = tt.logged
+			})
+
+			e.PATCH("/api/users/:user", manageable(config.Endpoint{Auth: true}, mockDataSourcer{}),
+				func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PATCH", "/api/users/"+tt.target, nil)
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"go-there/config"
+	"go-there/data"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// API keys are formatted as gt_<key id>_<secret>. The key id is public and used to find the key, the secret is verified
+// with an HMAC-SHA256 keyed with the server pepper.
+const (
+	apiKeyPrefix     = "gt_"
+	apiKeyIdSize     = 8
+	apiKeySecretSize = 32
+	apiKeyPepperSize = 32
+)
+
+// apiKeyPepper is the server-side secret used to hash the API keys secrets.
+var apiKeyPepper []byte
+
+// parsedApiKey represents an API key provided by a client. Keys in the legacy format embed their bcrypt or argon2id
+// hash instead of a key id.
+type parsedApiKey struct {
+	keyId      string
+	secret     []byte
+	legacyHash []byte
+}
+
+// InitApiKeyPepper initialize the pepper used to hash the API keys. If one doesn't exist, a random pepper will be
+// created at the path in the config. Changing the pepper invalidates every API key in the current format.
+func InitApiKeyPepper(config *config.Configuration) {
+	if config.Server.ApiKeyPepperPath == "" {
+		log.Fatal().Msg("invalid API key pepper")
+	}
+
+	if _, err := os.Stat(config.Server.ApiKeyPepperPath); os.IsNotExist(err) {
+		log.Warn().Msg("no API key pepper, trying to generate one")
+
+		pepper := make([]byte, apiKeyPepperSize)
+
+		_, err := rand.Read(pepper)
+
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not generate API key pepper")
+		}
+
+		err = ioutil.WriteFile(config.Server.ApiKeyPepperPath, []byte(base64.StdEncoding.EncodeToString(pepper)), 0600)
+
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not write API key pepper to disk")
+		}
+
+		apiKeyPepper = pepper
+
+		log.Info().Msg("successfully generated API key pepper")
+	} else {
+		pepperString, err := ioutil.ReadFile(config.Server.ApiKeyPepperPath)
+
+		if err != nil {
+			log.Fatal().Err(err).Msg("error reading API key pepper from file")
+		}
+
+		pepper, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(pepperString)))
+
+		if err != nil || len(pepper) < apiKeyPepperSize/2 {
+			log.Fatal().Msg("invalid API key pepper, must be at least 16 bytes encoded in base64")
+		}
+
+		apiKeyPepper = pepper
+	}
+}
+
+// GenerateApiKey creates a new random API key and returns (API key, key id, secret hash, error). Only the key id and
+// the hash should be stored.
+func GenerateApiKey() (string, string, []byte, error) {
+	id := make([]byte, apiKeyIdSize)
+
+	_, err := rand.Read(id)
+
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	secret := make([]byte, apiKeySecretSize)
+
+	_, err = rand.Read(secret)
+
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	keyId := hex.EncodeToString(id)
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+
+	return apiKeyPrefix + keyId + "_" + encodedSecret, keyId, hashApiKeySecret([]byte(encodedSecret)), nil
+}
+
+// HashApiKey returns (key id, secret hash, error) of an API key in the current format, so that a key generated outside of
+// go-there can be stored. Returns a data.ErrInvalidKey if the format is invalid or the secret is too short.
+func HashApiKey(apiKey string) (string, []byte, error) {
+	pk, err := parseApiKey(apiKey)
+
+	if err != nil || pk.legacyHash != nil {
+		return "", nil, data.ErrInvalidKey
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(string(pk.secret))
+
+	if err != nil || len(secret) < apiKeySecretSize {
+		return "", nil, data.ErrInvalidKey
+	}
+
+	if _, err := hex.DecodeString(pk.keyId); err != nil {
+		return "", nil, data.ErrInvalidKey
+	}
+
+	return pk.keyId, hashApiKeySecret(pk.secret), nil
+}
+
+// hashApiKeySecret returns the hex encoded HMAC-SHA256 of an API key secret, keyed with the server pepper.
+func hashApiKeySecret(secret []byte) []byte {
+	mac := hmac.New(sha256.New, apiKeyPepper)
+	mac.Write(secret)
+
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// parseApiKey parses an API key in the current or the legacy format. Returns a data.ErrInvalidKey if the format is
+// invalid.
+func parseApiKey(apiKey string) (parsedApiKey, error) {
+	if strings.HasPrefix(apiKey, apiKeyPrefix) {
+		// The secret is b64 URL encoded and can contain "_"
+		s := strings.SplitN(strings.TrimPrefix(apiKey, apiKeyPrefix), "_", 2)
+
+		if len(s) != 2 || len(s[0]) != hex.EncodedLen(apiKeyIdSize) || s[1] == "" {
+			return parsedApiKey{}, data.ErrInvalidKey
+		}
+
+		return parsedApiKey{keyId: s[0], secret: []byte(s[1])}, nil
+	}
+
+	hash, secret, err := validateApiKey(apiKey)
+
+	if err != nil {
+		return parsedApiKey{}, err
+	}
+
+	return parsedApiKey{secret: secret, legacyHash: hash}, nil
+}
+
+// selectApiKeyLogin finds the owner of an API key, then verifies the key secret. The user's main key is looked up
+// first, then the named keys. It returns (user, named key, error), the named key being empty if the main key was used.
+// Returns a data.ErrSqlNoRow if the key doesn't exist, a data.ErrInvalidAuth if the secret is invalid or a data.ErrSql
+// if it fails.
+func selectApiKeyLogin(ctx context.Context, ds DataSourcer, pk parsedApiKey) (data.User, data.ApiKey, error) {
+	var u data.User
+	var key data.ApiKey
+	var err error
+
+	if pk.legacyHash == nil {
+		u, err = ds.SelectUserLoginByApiKeyId(ctx, pk.keyId)
+		keyHash := u.ApiKeyHash
+
+		if errors.Is(err, data.ErrSqlNoRow) {
+			u, key, err = ds.SelectApiKeyLoginById(ctx, pk.keyId)
+			keyHash = key.KeyHash
+		}
+
+		if err != nil {
+			return data.User{}, data.ApiKey{}, err
+		}
+
+		if !hmac.Equal(hashApiKeySecret(pk.secret), keyHash) {
+			return data.User{}, data.ApiKey{}, data.ErrInvalidAuth
+		}
+
+		return u, key, nil
+	}
+
+	// Legacy keys are found by their full hash, which is slow to verify
+	u, err = ds.SelectUserLoginByApiKeyHash(ctx, string(pk.legacyHash))
+	keyHash := u.ApiKeyHash
+
+	if errors.Is(err, data.ErrSqlNoRow) {
+		u, key, err = ds.SelectApiKeyLogin(ctx, string(pk.legacyHash))
+		keyHash = key.KeyHash
+	}
+
+	if err != nil {
+		return data.User{}, data.ApiKey{}, err
+	}
+
+	err = ComparePassword(keyHash, pk.secret)
+
+	if err != nil {
+		return data.User{}, data.ApiKey{}, err
+	}
+
+	return u, key, nil
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestGenerateApiKey(t *testing.T) {
+	apiKey, keyId, keyHash, err := GenerateApiKey()
+
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(apiKey, apiKeyPrefix+keyId+"_"))
+	assert.Len(t, keyId, 16)
+	assert.Len(t, keyHash, 64)
+
+	pk, err := parseApiKey(apiKey)
+
+	assert.Nil(t, err)
+	assert.Equal(t, keyId, pk.keyId)
+	assert.Nil(t, pk.legacyHash)
+	assert.Equal(t, keyHash, hashApiKeySecret(pk.secret))
+
+	// The secret hash depends on the pepper
+	defer func(p []byte) { apiKeyPepper = p }(apiKeyPepper)
+	apiKeyPepper = []byte("another_pepper")
+
+	assert.NotEqual(t, keyHash, hashApiKeySecret(pk.secret))
+}
+
+func Test_parseApiKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		apiKey     string
+		wantId     string
+		wantSecret string
+		wantLegacy bool
+		wantErr    bool
+	}{
+		{
+			name:       "ok",
+			apiKey:     "gt_0123456789abcdef_c2VjcmV0_c2VjcmV0",
+			wantId:     "0123456789abcdef",
+			wantSecret: "c2VjcmV0_c2VjcmV0",
+		},
+		{
+			name:       "ok_legacy",
+			apiKey:     "LktnS3duTjA2Vnh3VHd0NHp5VllSdTpLT2JUNjlLYlNrdDNNTW9ONzZjeWR3PT0=",
+			wantSecret: "KObT69KbSkt3MMoN76cydw==",
+			wantLegacy: true,
+		},
+		{
+			name:    "short_id",
+			apiKey:  "gt_0123_c2VjcmV0",
+			wantErr: true,
+		},
+		{
+			name:    "no_secret",
+			apiKey:  "gt_0123456789abcdef_",
+			wantErr: true,
+		},
+		{
+			name:    "corrupt_legacy",
+			apiKey:  "not?base64",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseApiKey(tt.apiKey)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if tt.wantErr {
+				return
+			}
+
+			assert.Equal(t, tt.wantId, got.keyId)
+			assert.Equal(t, tt.wantSecret, string(got.secret))
+			assert.Equal(t, tt.wantLegacy, got.legacyHash != nil)
+		})
+	}
+}
+
+func TestHashApiKey(t *testing.T) {
+	apiKey, keyId, keyHash, err := GenerateApiKey()
+
+	assert.Nil(t, err)
+
+	gotId, gotHash, err := HashApiKey(apiKey)
+
+	assert.Nil(t, err)
+	assert.Equal(t, keyId, gotId)
+	assert.Equal(t, keyHash, gotHash)
+
+	for _, k := range []string{"", "gt_0123456789abcdef_short", "gt_0123456789abcdeg_" + strings.Repeat("a", 43), "bad_key"} {
+		_, _, err = HashApiKey(k)
+
+		assert.NotNil(t, err, k)
+	}
+}
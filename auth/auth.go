@@ -2,35 +2,26 @@ package auth
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jwt"
 	"go-there/data"
-	"golang.org/x/crypto/bcrypt"
 	"strings"
 	"time"
 )
 
-const bCryptCost = bcrypt.DefaultCost
-
 // DataSourcer is used to access the mysql database.
 type DataSourcer interface {
-	SelectUserLogin(username string) (data.User, error)
-	SelectUserLoginByApiKeyHash(apiKeyHash string) (data.User, error)
-}
-
-// GetHashFromPassword takes a password, and returns (complete bcrypt hash, error).
-func GetHashFromPassword(password string) ([]byte, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bCryptCost)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return hash, nil
+	SelectUserLogin(ctx context.Context, username string) (data.User, error)
+	SelectUserLoginByApiKeyHash(ctx context.Context, apiKeyHash string) (data.User, error)
+	SelectApiKeyLogin(ctx context.Context, keyHash string) (data.User, data.ApiKey, error)
+	SelectUserLoginByApiKeyId(ctx context.Context, keyId string) (data.User, error)
+	SelectApiKeyLoginById(ctx context.Context, keyId string) (data.User, data.ApiKey, error)
+	UpdateApiKeyLastUsed(ctx context.Context, id int) error
+	UpdateUserPassword(ctx context.Context, user data.User) error
 }
 
 // GenerateRandomB64String creates a random base64 URL encoded string from using the crypto/rand package from a byte
@@ -63,6 +54,38 @@ func GetLoggedUser(c *gin.Context) data.User {
 	return u
 }
 
+// HasScope returns true if the logged user did not authenticate with a named API key, or if the key used has been
+// granted the provided scope.
+func HasScope(c *gin.Context, scope string) bool {
+	if c.Keys == nil {
+		return true
+	}
+
+	k, ok := c.Keys["apiKey"].(data.ApiKey)
+
+	if !ok {
+		return true
+	}
+
+	return k.HasScope(scope)
+}
+
+// GetAuthType returns the authentication type used by the logged user (data.Basic, data.Jwt, data.ApiKeyAuth,
+// data.SessionAuth or data.ClientCertAuth), or -1 if no user is logged.
+func GetAuthType(c *gin.Context) int {
+	if c.Keys == nil {
+		return -1
+	}
+
+	t, ok := c.Keys["authType"].(int)
+
+	if !ok {
+		return -1
+	}
+
+	return t
+}
+
 // GetRequestedUser returns the user corresponding to the resource accessed. It returns "" if the resource does not
 // belong to any user.
 func GetRequestedUser(c *gin.Context) string {
@@ -79,7 +102,7 @@ func GetRequestedUser(c *gin.Context) string {
 	return u
 }
 
-// validateApiKey takes an api key with the hash encoded in b64 and returns (hash, apikey, error).
+// validateApiKey takes an api key in the legacy format, with the hash encoded in b64, and returns (hash, apikey, error).
 func validateApiKey(apiKey string) ([]byte, []byte, error) {
 	decodedKey, err := base64.URLEncoding.DecodeString(apiKey)
 
@@ -146,7 +169,7 @@ func basicAuthToLogin(basicAuth string) (data.BasicAuthLogin, error) {
 // jwtToLogin takes a JWT token string and returns a data.JwtLogin if the token is valid or an data.ErrInvalidJwt
 // otherwise.
 func jwtToLogin(jwtAuth string) (data.JwtLogin, error) {
-	token, err := jwt.Parse([]byte(jwtAuth), jwt.WithValidate(true), jwt.WithVerify(jwa.RS256, JwtSigningKey.PublicKey))
+	token, err := jwt.Parse([]byte(jwtAuth), jwt.WithValidate(true), jwt.WithVerify(JwtSigningAlgorithm, JwtSigningKey.Public()))
 
 	if err != nil {
 		return data.JwtLogin{}, fmt.Errorf("%w : %s", data.ErrInvalidJwt, err)
@@ -172,6 +195,14 @@ func jwtToLogin(jwtAuth string) (data.JwtLogin, error) {
 		return data.JwtLogin{}, data.ErrInvalidJwt
 	}
 
+	// Only the impersonation tokens contain an actor
+	if a, ok := token.Get("actor"); ok {
+		jl.Actor, ok = a.(string)
+		if !ok {
+			return data.JwtLogin{}, data.ErrInvalidJwt
+		}
+	}
+
 	e, ok := token.Get(jwt.ExpirationKey)
 	if !ok {
 		return data.JwtLogin{}, data.ErrInvalidJwt
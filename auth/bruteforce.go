@@ -0,0 +1,347 @@
+package auth
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go-there/config"
+	"go-there/data"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default brute-force protection settings
+const (
+	defaultMaxUserFailures = 10
+	defaultMaxIpFailures   = 100
+	defaultLockoutSec      = 900
+	defaultBackoffBaseSec  = 1
+)
+
+// memoryStoreSweepSize is the number of entries of a memoryFailureStore above which expired entries are removed.
+const memoryStoreSweepSize = 10000
+
+// FailureStore stores the consecutive authentication failures of users and IP addresses. AddLoginFailure must be
+// atomic, so that concurrent failures are all counted.
+type FailureStore interface {
+	GetLoginFailures(ctx context.Context, key string) (data.LoginFailures, error)
+	AddLoginFailure(ctx context.Context, key string, ttl time.Duration) error
+	DeleteLoginFailures(ctx context.Context, key string) error
+}
+
+// loginLimiter tracks authentication failures. It is nil if the brute-force protection is disabled.
+var loginLimiter *limiter
+
+// limiter applies an exponential backoff, then a temporary lockout, to users and IP addresses failing to authenticate.
+type limiter struct {
+	store           FailureStore
+	maxUserFailures int
+	maxIpFailures   int
+	lockout         time.Duration
+	backoffBase     time.Duration
+}
+
+// ApplyLoginSettings parses the brute-force protection settings, then apply them to the global variables. If a setting
+// has a zero value, the default is used. The failures are tracked in the provided store, or in memory if it is nil.
+func ApplyLoginSettings(conf *config.Configuration, store FailureStore) {
+	if !conf.Login.ProtectionEnabled {
+		loginLimiter = nil
+		return
+	}
+
+	if store == nil {
+		store = newMemoryFailureStore()
+	}
+
+	l := &limiter{
+		store:           store,
+		maxUserFailures: defaultMaxUserFailures,
+		maxIpFailures:   defaultMaxIpFailures,
+		lockout:         defaultLockoutSec * time.Second,
+		backoffBase:     defaultBackoffBaseSec * time.Second,
+	}
+
+	if conf.Login.MaxUserFailures > 0 {
+		l.maxUserFailures = conf.Login.MaxUserFailures
+	}
+
+	if conf.Login.MaxIpFailures > 0 {
+		l.maxIpFailures = conf.Login.MaxIpFailures
+	}
+
+	if conf.Login.LockoutSec > 0 {
+		l.lockout = time.Duration(conf.Login.LockoutSec) * time.Second
+	}
+
+	if conf.Login.BackoffBaseSec > 0 {
+		l.backoffBase = time.Duration(conf.Login.BackoffBaseSec) * time.Second
+	}
+
+	loginLimiter = l
+}
+
+// UnlockUser removes all the authentication failures of a user. Returns an error if the store fails.
+func UnlockUser(ctx context.Context, username string) error {
+	if loginLimiter == nil {
+		return nil
+	}
+
+	return loginLimiter.store.DeleteLoginFailures(ctx, userFailureKey(username))
+}
+
+// abortWithRetryAfter aborts the request with http.StatusTooManyRequests and a Retry-After header if wait is positive.
+// Returns true if the request was aborted.
+func abortWithRetryAfter(c *gin.Context, wait time.Duration) bool {
+	if wait <= 0 {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.AbortWithStatus(http.StatusTooManyRequests)
+
+	return true
+}
+
+// userFailureKey returns the key used to store the failures of a user.
+func userFailureKey(username string) string {
+	return "login_failures:user:" + username
+}
+
+// ipFailureKey returns the key used to store the failures of an IP address.
+func ipFailureKey(ip string) string {
+	return "login_failures:ip:" + ip
+}
+
+// AbortIfOtpLocked aborts the request with http.StatusTooManyRequests and a Retry-After header if the user cannot
+// attempt to validate a second factor yet. Returns true if the request was aborted.
+func AbortIfOtpLocked(c *gin.Context, username string) bool {
+	if loginLimiter == nil {
+		return false
+	}
+
+	wait := loginLimiter.retryAfterKeys(c.Request.Context(),
+		limitedKey{otpFailureKey(username), loginLimiter.maxUserFailures})
+
+	return abortWithRetryAfter(c, wait)
+}
+
+// RegisterOtpResult tracks the second factor validations of a user. The second factor failures are tracked separately
+// from the password failures, as a valid password resets the password failures. A failure is registered even if ctx is
+// canceled, so that disconnecting doesn't skip it.
+func RegisterOtpResult(ctx context.Context, username string, success bool) {
+	if loginLimiter == nil {
+		return
+	}
+
+	if success {
+		_ = loginLimiter.store.DeleteLoginFailures(ctx, otpFailureKey(username))
+		return
+	}
+
+	loginLimiter.registerKeyFailure(otpFailureKey(username))
+}
+
+// otpFailureKey returns the key used to store the second factor failures of a user.
+func otpFailureKey(username string) string {
+	return "login_failures:otp:" + username
+}
+
+// AbortIfPasswordResetLimited aborts the request with http.StatusTooManyRequests and a Retry-After header if the user
+// or the IP address of the request cannot request a password reset yet. Returns true if the request was aborted.
+func AbortIfPasswordResetLimited(c *gin.Context, username string) bool {
+	if loginLimiter == nil {
+		return false
+	}
+
+	wait := loginLimiter.retryAfterKeys(c.Request.Context(),
+		limitedKey{passwordResetUserKey(username), loginLimiter.maxUserFailures},
+		limitedKey{passwordResetIpKey(c.ClientIP()), loginLimiter.maxIpFailures})
+
+	return abortWithRetryAfter(c, wait)
+}
+
+// RegisterPasswordResetRequest tracks the password reset requests of a user and an IP address, which are delayed and
+// locked out like the authentication failures, so that the inboxes cannot be flooded and the pending tokens replaced.
+// They are tracked separately from the authentication failures, and whether the user exists or not. It is not bound to
+// the request context, so that a client cannot skip it by disconnecting.
+func RegisterPasswordResetRequest(username string, ip string) {
+	if loginLimiter == nil {
+		return
+	}
+
+	loginLimiter.registerKeyFailure(passwordResetUserKey(username))
+	loginLimiter.registerKeyFailure(passwordResetIpKey(ip))
+}
+
+// passwordResetUserKey returns the key used to store the password reset requests of a user.
+func passwordResetUserKey(username string) string {
+	return "password_resets:user:" + username
+}
+
+// passwordResetIpKey returns the key used to store the password reset requests of an IP address.
+func passwordResetIpKey(ip string) string {
+	return "password_resets:ip:" + ip
+}
+
+// retryAfter returns the time to wait before an authentication can be attempted for the user or the IP address. If a
+// store error happens, the authentication is allowed.
+func (l *limiter) retryAfter(ctx context.Context, username string, ip string) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	return l.retryAfterKeys(ctx, l.keys(username, ip)...)
+}
+
+// limitedKey is a store key, and the number of failures after which it is locked out.
+type limitedKey struct {
+	key string
+	max int
+}
+
+// retryAfterKeys returns the longest time to wait before an authentication can be attempted for the provided keys. Each
+// key is delayed from its last failure by the backoff of its number of failures.
+func (l *limiter) retryAfterKeys(ctx context.Context, keys ...limitedKey) time.Duration {
+	var wait time.Duration
+	now := time.Now()
+
+	for _, k := range keys {
+		f, err := l.store.GetLoginFailures(ctx, k.key)
+
+		if err != nil || f.Failures == 0 {
+			continue
+		}
+
+		if d := f.LastFailure.Add(l.delay(f.Failures, k.max)).Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait
+}
+
+// registerFailure increments the failures of the user and the IP address, and delays their next authentication
+// attempts. It is not bound to the request context, so that a client cannot skip it by disconnecting.
+func (l *limiter) registerFailure(username string, ip string) {
+	if l == nil {
+		return
+	}
+
+	if username != "" {
+		l.registerKeyFailure(userFailureKey(username))
+	}
+
+	l.registerKeyFailure(ipFailureKey(ip))
+}
+
+// registerKeyFailure increments the failures stored for the key, which delays the next attempt. They are kept for a
+// lockout after the longest delay, itself at most a lockout.
+func (l *limiter) registerKeyFailure(key string) {
+	_ = l.store.AddLoginFailure(context.Background(), key, 2*l.lockout)
+}
+
+// registerSuccess resets the failures of the user. The failures of the IP address are kept, so a valid account cannot
+// be used to reset the failures of an IP address trying other accounts.
+func (l *limiter) registerSuccess(ctx context.Context, username string) {
+	if l == nil || username == "" {
+		return
+	}
+
+	_ = l.store.DeleteLoginFailures(ctx, userFailureKey(username))
+}
+
+// delay returns the time to wait after the nth consecutive failure. It doubles after each failure, and the lockout
+// duration is returned once the maximum number of failures is reached.
+func (l *limiter) delay(failures int, max int) time.Duration {
+	if failures >= max {
+		return l.lockout
+	}
+
+	// Compared before the conversion, which overflows after many failures
+	d := float64(l.backoffBase) * math.Pow(2, float64(failures-1))
+
+	if d > float64(l.lockout) {
+		return l.lockout
+	}
+
+	return time.Duration(d)
+}
+
+// keys returns the store keys of the username and IP address. The username is ignored if empty.
+func (l *limiter) keys(username string, ip string) []limitedKey {
+	if username == "" {
+		return []limitedKey{{ipFailureKey(ip), l.maxIpFailures}}
+	}
+
+	return []limitedKey{{userFailureKey(username), l.maxUserFailures}, {ipFailureKey(ip), l.maxIpFailures}}
+}
+
+// memoryFailureStore is an in-process FailureStore, used when no shared cache is configured.
+type memoryFailureStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryFailureEntry
+}
+
+type memoryFailureEntry struct {
+	failures  data.LoginFailures
+	expiresAt time.Time
+}
+
+func newMemoryFailureStore() *memoryFailureStore {
+	return &memoryFailureStore{entries: make(map[string]memoryFailureEntry)}
+}
+
+// GetLoginFailures returns the failures stored for the key, or an empty data.LoginFailures if none exist.
+func (s *memoryFailureStore) GetLoginFailures(_ context.Context, key string) (data.LoginFailures, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+
+	if !ok || time.Now().After(e.expiresAt) {
+		return data.LoginFailures{}, nil
+	}
+
+	return e.failures, nil
+}
+
+// AddLoginFailure increments the failures stored for the key, and keeps them until the ttl expires.
+func (s *memoryFailureStore) AddLoginFailure(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// Avoid an unbounded growth when many IP addresses fail to authenticate
+	if len(s.entries) >= memoryStoreSweepSize {
+		for k, e := range s.entries {
+			if now.After(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	f := data.LoginFailures{}
+
+	if e, ok := s.entries[key]; ok && !now.After(e.expiresAt) {
+		f = e.failures
+	}
+
+	f.Failures++
+	f.LastFailure = now
+	s.entries[key] = memoryFailureEntry{failures: f, expiresAt: now.Add(ttl)}
+
+	return nil
+}
+
+// DeleteLoginFailures removes the failures stored for the key.
+func (s *memoryFailureStore) DeleteLoginFailures(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"go-there/data"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_limiter_delay(t *testing.T) {
+	l := &limiter{
+		lockout:     time.Minute,
+		backoffBase: time.Second,
+	}
+
+	type args struct {
+		failures int
+		max      int
+	}
+	tests := []struct {
+		name string
+		args args
+		want time.Duration
+	}{
+		{
+			name: "first",
+			args: args{failures: 1, max: 10},
+			want: time.Second,
+		},
+		{
+			name: "fourth",
+			args: args{failures: 4, max: 10},
+			want: 8 * time.Second,
+		},
+		{
+			name: "capped",
+			args: args{failures: 9, max: 10},
+			want: time.Minute,
+		},
+		{
+			name: "capped_overflow",
+			args: args{failures: 80, max: 100},
+			want: time.Minute,
+		},
+		{
+			name: "lockout",
+			args: args{failures: 3, max: 3},
+			want: time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, l.delay(tt.args.failures, tt.args.max))
+		})
+	}
+}
+
+func Test_memoryFailureStore(t *testing.T) {
+	s := newMemoryFailureStore()
+
+	f, err := s.GetLoginFailures(context.Background(), "key")
+
+	assert.Nil(t, err)
+	assert.Equal(t, data.LoginFailures{}, f)
+
+	assert.Nil(t, s.AddLoginFailure(context.Background(), "key", time.Minute))
+	assert.Nil(t, s.AddLoginFailure(context.Background(), "key", time.Minute))
+	assert.Nil(t, s.AddLoginFailure(context.Background(), "expired", -time.Second))
+
+	f, _ = s.GetLoginFailures(context.Background(), "key")
+	assert.Equal(t, 2, f.Failures)
+	assert.WithinDuration(t, time.Now(), f.LastFailure, time.Second)
+
+	// An expired entry starts over
+	assert.Nil(t, s.AddLoginFailure(context.Background(), "expired", time.Minute))
+
+	f, _ = s.GetLoginFailures(context.Background(), "expired")
+	assert.Equal(t, 1, f.Failures)
+
+	assert.Nil(t, s.DeleteLoginFailures(context.Background(), "key"))
+
+	f, _ = s.GetLoginFailures(context.Background(), "key")
+	assert.Equal(t, 0, f.Failures)
+}
+
+func Test_limiter_concurrentFailures(t *testing.T) {
+	l := &limiter{
+		store:           newMemoryFailureStore(),
+		maxUserFailures: 10,
+		maxIpFailures:   100,
+		lockout:         time.Minute,
+		backoffBase:     time.Second,
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			l.registerFailure("alice", "10.0.0.1")
+		}()
+	}
+
+	wg.Wait()
+
+	f, _ := l.store.GetLoginFailures(context.Background(), ipFailureKey("10.0.0.1"))
+	assert.Equal(t, 50, f.Failures)
+
+	// The user is locked out, the IP address is in backoff
+	assert.InDelta(t, time.Minute.Seconds(), l.retryAfter(context.Background(), "alice", "").Seconds(), 1)
+	assert.InDelta(t, time.Minute.Seconds(), l.retryAfter(context.Background(), "", "10.0.0.1").Seconds(), 1)
+}
+
+func TestGetAuthMiddlewareLockout(t *testing.T) {
+	ApplyLoginSettings(&config.Configuration{Login: config.Login{
+		ProtectionEnabled: true,
+		MaxUserFailures:   2,
+		LockoutSec:        60,
+		BackoffBaseSec:    0,
+	}}, nil)
+
+	defer ApplyLoginSettings(&config.Configuration{}, nil)
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	e.Use(GetAuthMiddleware(mockDataSourcer{}, "", ""))
+
+	e.GET("/ping", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	login := func(password string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		req.Header = map[string][]string{
+			"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte("alice:"+password))},
+		}
+
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login("badpassword").Code)
+
+	// Backoff after the first failure
+	w := login("superpassword")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// The failures of the IP address are kept after unlocking the user
+	assert.Nil(t, UnlockUser(context.Background(), "alice"))
+	assert.Equal(t, http.StatusTooManyRequests, login("superpassword").Code)
+
+	_ = loginLimiter.store.DeleteLoginFailures(context.Background(), ipFailureKey(""))
+	assert.Equal(t, http.StatusOK, login("superpassword").Code)
+
+	// Lockout after the maximum number of failures
+	loginLimiter.registerFailure("alice", "")
+	loginLimiter.registerFailure("alice", "")
+
+	w = login("superpassword")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-there/config"
+	"go-there/data"
+	"strings"
+)
+
+// Client certificate fields which can be mapped to a username
+const (
+	CertFieldCommonName = "commonname"
+	CertFieldEmail      = "email"
+	CertFieldDns        = "dns"
+)
+
+// clientCertUserField is the certificate field mapped to a username. The client certificate authentication is disabled
+// if it is empty.
+var clientCertUserField = ""
+
+// ApplyClientCertSettings parses the client certificate settings, then apply them to the global variables. The client
+// certificate authentication is enabled if a client CA is configured. Returns a data.ErrSettings if the user field is
+// invalid.
+func ApplyClientCertSettings(conf *config.Configuration) error {
+	if conf.Server.ClientCaPath == "" {
+		clientCertUserField = ""
+		return nil
+	}
+
+	switch f := strings.ToLower(conf.Server.ClientCertUserField); f {
+	case "":
+		clientCertUserField = CertFieldCommonName
+	case CertFieldCommonName, CertFieldEmail, CertFieldDns:
+		clientCertUserField = f
+	default:
+		return fmt.Errorf("%w : invalid client certificate user field %s", data.ErrSettings,
+			conf.Server.ClientCertUserField)
+	}
+
+	return nil
+}
+
+// clientCertUsername returns the username mapped from the verified client certificate of the request, or "" if there
+// is none. Certificates are verified against the configured CA by the TLS server, so only verified chains are used.
+func clientCertUsername(c *gin.Context) string {
+	if clientCertUserField == "" || c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 ||
+		len(c.Request.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return certUsername(c.Request.TLS.VerifiedChains[0][0], clientCertUserField)
+}
+
+// certUsername returns the value of the field of a certificate, or "" if the field is empty. For SAN fields, the first
+// value is used.
+func certUsername(cert *x509.Certificate, field string) string {
+	switch field {
+	case CertFieldCommonName:
+		return cert.Subject.CommonName
+	case CertFieldEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case CertFieldDns:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestCert creates a certificate signed by the parent, or self-signed if parent is nil. It returns
+// (certificate, private key).
+func newTestCert(t *testing.T, subject pkix.Name, emails []string, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        subject,
+		EmailAddresses: emails,
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+
+	assert.Nil(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+
+	assert.Nil(t, err)
+
+	return cert, key
+}
+
+func TestApplyClientCertSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    config.Server
+		wantField string
+		wantErr   bool
+	}{
+		{
+			name:      "disabled",
+			server:    config.Server{ClientCertUserField: "email"},
+			wantField: "",
+		},
+		{
+			name:      "default",
+			server:    config.Server{ClientCaPath: "/ca.pem"},
+			wantField: CertFieldCommonName,
+		},
+		{
+			name:      "email",
+			server:    config.Server{ClientCaPath: "/ca.pem", ClientCertUserField: "Email"},
+			wantField: CertFieldEmail,
+		},
+		{
+			name:    "invalid",
+			server:  config.Server{ClientCaPath: "/ca.pem", ClientCertUserField: "serial"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientCertUserField = ""
+
+			err := ApplyClientCertSettings(&config.Configuration{Server: tt.server})
+
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantField, clientCertUserField)
+		})
+	}
+
+	clientCertUserField = ""
+}
+
+func TestGetAuthMiddlewareClientCert(t *testing.T) {
+	ca, caKey := newTestCert(t, pkix.Name{CommonName: "go-there test CA"}, nil, nil, nil)
+	otherCa, otherCaKey := newTestCert(t, pkix.Name{CommonName: "other CA"}, nil, nil, nil)
+
+	aliceCert, aliceKey := newTestCert(t, pkix.Name{CommonName: "alice"}, []string{"bob"}, ca, caKey)
+	unknownCert, unknownKey := newTestCert(t, pkix.Name{CommonName: "unknown"}, nil, ca, caKey)
+	untrustedCert, untrustedKey := newTestCert(t, pkix.Name{CommonName: "alice"}, nil, otherCa, otherCaKey)
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	e.Use(GetAuthMiddleware(mockDataSourcer{}, "", ""))
+
+	e.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, GetLoggedUser(c).Username)
+	})
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	s := httptest.NewUnstartedServer(e)
+	s.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	s.StartTLS()
+	defer s.Close()
+
+	clientCertUserField = CertFieldCommonName
+	defer func() { clientCertUserField = "" }()
+
+	type args struct {
+		cert *x509.Certificate
+		key  *ecdsa.PrivateKey
+	}
+
+	tests := []struct {
+		name     string
+		args     args
+		want     int
+		wantUser string
+	}{
+		{
+			name:     "ok",
+			args:     args{cert: aliceCert, key: aliceKey},
+			want:     http.StatusOK,
+			wantUser: "alice",
+		},
+		{
+			name: "unknown_user",
+			args: args{cert: unknownCert, key: unknownKey},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "no_cert",
+			args: args{},
+			want: http.StatusUnauthorized,
+		},
+		{
+			// The client does not send a certificate the server cannot verify
+			name: "untrusted_ca",
+			args: args{cert: untrustedCert, key: untrustedKey},
+			want: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := s.Client().Transport.(*http.Transport).Clone()
+
+			if tt.args.cert != nil {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{
+					{Certificate: [][]byte{tt.args.cert.Raw}, PrivateKey: tt.args.key},
+				}
+			}
+
+			resp, err := (&http.Client{Transport: transport}).Get(s.URL + "/ping")
+
+			assert.Nil(t, err)
+
+			defer resp.Body.Close()
+
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			assert.Equal(t, tt.want, resp.StatusCode)
+			assert.Equal(t, tt.wantUser, string(body))
+		})
+	}
+
+	// The username can be mapped from a SAN
+	clientCertUserField = CertFieldEmail
+
+	assert.Equal(t, "bob", certUsername(aliceCert, clientCertUserField))
+	assert.Equal(t, "", certUsername(unknownCert, clientCertUserField))
+}
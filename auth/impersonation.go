@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go-there/config"
+	"go-there/data"
+	"net/http"
+)
+
+// impersonationSettings contains the impersonation rules.
+type impersonationSettings struct {
+	enabled     bool
+	allowAdmins bool
+}
+
+// impersonation contains the current impersonation rules. The impersonation is disabled by default.
+var impersonation = impersonationSettings{}
+
+// ApplyImpersonationSettings applies the impersonation settings to the global variables.
+func ApplyImpersonationSettings(conf *config.Configuration) {
+	impersonation = impersonationSettings{
+		enabled:     conf.Impersonation.Enabled,
+		allowAdmins: conf.Impersonation.AllowAdmins,
+	}
+}
+
+// GetActor returns the user who really authenticated. It is the impersonating user if the logged user is impersonated,
+// or the logged user otherwise.
+func GetActor(c *gin.Context) data.User {
+	if c.Keys == nil {
+		return data.User{}
+	}
+
+	u, ok := c.Keys["actor"].(data.User)
+
+	if !ok {
+		return GetLoggedUser(c)
+	}
+
+	return u
+}
+
+// IsImpersonating returns true if the logged user is impersonated by another user.
+func IsImpersonating(c *gin.Context) bool {
+	if c.Keys == nil {
+		return false
+	}
+
+	_, ok := c.Keys["actor"].(data.User)
+
+	return ok
+}
+
+// CanImpersonate returns true if the actor is allowed to impersonate the target. The actor must be an admin or have the
+// data.PermUsersImpersonate permission. Admins can only be impersonated by admins, if it is allowed in the settings, and
+// a user who is not admin cannot impersonate a user with a role he cannot grant.
+func CanImpersonate(actor data.User, target data.User) bool {
+	if !impersonation.enabled {
+		return false
+	}
+
+	if !actor.IsAdmin && !HasPermission(actor, data.PermUsersImpersonate) {
+		return false
+	}
+
+	if target.IsAdmin && (!impersonation.allowAdmins || !actor.IsAdmin) {
+		return false
+	}
+
+	if !actor.IsAdmin {
+		for _, r := range target.RoleList() {
+			if !CanGrantRole(actor, r) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// impersonate replaces the logged user by the target user. Named API keys must be granted all scopes. If the
+// impersonation is not allowed, the request is aborted with the matching status.
+func impersonate(c *gin.Context, ds DataSourcer, username string) {
+	if IsImpersonating(c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, data.ErrorResponse{Error: "already impersonating"})
+		return
+	}
+
+	if k, ok := c.Keys["apiKey"].(data.ApiKey); ok && !k.HasScope(data.ScopeAll) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	target, err := ds.SelectUserLogin(c.Request.Context(), username)
+
+	if err != nil || target.Username == "" {
+		if err != nil && !errors.Is(err, data.ErrSqlNoRow) {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusBadRequest, data.ErrorResponse{Error: "invalid impersonated user"})
+		return
+	}
+
+	actor := GetLoggedUser(c)
+
+	if !CanImpersonate(actor, target) {
+		c.AbortWithStatusJSON(http.StatusForbidden, data.ErrorResponse{Error: "impersonation not allowed"})
+		return
+	}
+
+	setImpersonation(c, actor, target)
+}
+
+// impersonateFromJwt restores the impersonation of an impersonation token. The rules are checked again, as they may
+// have changed since the token was created. If the impersonation is not allowed anymore, the request is aborted.
+func impersonateFromJwt(c *gin.Context, ds DataSourcer, actorName string) {
+	actor, err := ds.SelectUserLogin(c.Request.Context(), actorName)
+
+	if err != nil || actor.Username == "" {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	target := GetLoggedUser(c)
+
+	if !CanImpersonate(actor, target) {
+		c.AbortWithStatusJSON(http.StatusForbidden, data.ErrorResponse{Error: "impersonation not allowed"})
+		return
+	}
+
+	setImpersonation(c, actor, target)
+}
+
+// setImpersonation sets the target as the logged user, keeps track of the actor and writes an audit log line.
+func setImpersonation(c *gin.Context, actor data.User, target data.User) {
+	c.Keys["actor"] = actor
+	c.Keys["user"] = target
+	c.Keys["logUser"] = target.Username
+	c.Keys["logActor"] = actor.Username
+
+	log.Info().
+		Str("method", c.Request.Method).
+		Str("endpoint", c.Request.URL.Path).
+		Str("ip", c.ClientIP()).
+		Str("user", target.Username).
+		Str("actor", actor.Username).
+		Msg("impersonation")
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"go-there/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCanImpersonate(t *testing.T) {
+	err := ApplyRoles(&config.Configuration{
+		Roles: map[string]config.Role{
+			"support":    {Permissions: []string{"users:impersonate", "users:read"}},
+			"superadmin": {Permissions: []string{"*"}},
+		},
+	})
+
+	assert.Nil(t, err)
+
+	defer func() { _ = ApplyRoles(&config.Configuration{}) }()
+
+	type args struct {
+		settings impersonationSettings
+		actor    data.User
+		target   data.User
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			name: "ok_admin",
+			args: args{
+				settings: impersonationSettings{enabled: true},
+				actor:    data.User{Username: "root", IsAdmin: true},
+				target:   data.User{Username: "alice"},
+			},
+			want: true,
+		},
+		{
+			name: "ok_role",
+			args: args{
+				settings: impersonationSettings{enabled: true},
+				actor:    data.User{Username: "support", Roles: "support"},
+				target:   data.User{Username: "alice"},
+			},
+			want: true,
+		},
+		{
+			name: "disabled",
+			args: args{
+				settings: impersonationSettings{},
+				actor:    data.User{Username: "root", IsAdmin: true},
+				target:   data.User{Username: "alice"},
+			},
+			want: false,
+		},
+		{
+			name: "no_permission",
+			args: args{
+				settings: impersonationSettings{enabled: true},
+				actor:    data.User{Username: "bob"},
+				target:   data.User{Username: "alice"},
+			},
+			want: false,
+		},
+		{
+			name: "admin_target",
+			args: args{
+				settings: impersonationSettings{enabled: true},
+				actor:    data.User{Username: "root", IsAdmin: true},
+				target:   data.User{Username: "admin2", IsAdmin: true},
+			},
+			want: false,
+		},
+		{
+			name: "role_admin_target_allowed",
+			args: args{
+				settings: impersonationSettings{enabled: true, allowAdmins: true},
+				actor:    data.User{Username: "support", Roles: "support"},
+				target:   data.User{Username: "admin2", IsAdmin: true},
+			},
+			want: false,
+		},
+		{
+			name: "ok_admin_target_allowed",
+			args: args{
+				settings: impersonationSettings{enabled: true, allowAdmins: true},
+				actor:    data.User{Username: "root", IsAdmin: true},
+				target:   data.User{Username: "admin2", IsAdmin: true},
+			},
+			want: true,
+		},
+		{
+			name: "privileged_role_target",
+			args: args{
+				settings: impersonationSettings{enabled: true},
+				actor:    data.User{Username: "support", Roles: "support"},
+				target:   data.User{Username: "bob", Roles: "superadmin"},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			impersonation = tt.args.settings
+
+			assert.Equal(t, tt.want, CanImpersonate(tt.args.actor, tt.args.target))
+		})
+	}
+
+	impersonation = impersonationSettings{}
+}
+
+func TestGetAuthMiddlewareImpersonation(t *testing.T) {
+	key, err := generateJwtSigningKey(jwa.ES256)
+
+	assert.Nil(t, err)
+
+	JwtSigningKey = key
+	JwtSigningAlgorithm = jwa.ES256
+
+	// Impersonation token of alice created by root
+	token := jwt.New()
+	_ = token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour).Unix())
+	_ = token.Set("username", "alice")
+	_ = token.Set("is_admin", false)
+	_ = token.Set("actor", "root")
+	impersonationJwt, _ := jwt.Sign(token, jwa.ES256, key)
+
+	rootLogin := "Basic " + base64.StdEncoding.EncodeToString([]byte("root:superpassword"))
+	aliceLogin := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:superpassword"))
+
+	type args struct {
+		settings      impersonationSettings
+		authorization string
+		impersonate   string
+	}
+
+	tests := []struct {
+		name      string
+		args      args
+		want      int
+		wantUser  string
+		wantActor string
+	}{
+		{
+			name: "ok",
+			args: args{
+				settings:      impersonationSettings{enabled: true},
+				authorization: rootLogin,
+				impersonate:   "alice",
+			},
+			want:      http.StatusOK,
+			wantUser:  "alice",
+			wantActor: "root",
+		},
+		{
+			name: "ok_no_impersonation",
+			args: args{
+				settings:      impersonationSettings{enabled: true},
+				authorization: rootLogin,
+			},
+			want:      http.StatusOK,
+			wantUser:  "root",
+			wantActor: "root",
+		},
+		{
+			name: "ok_jwt",
+			args: args{
+				settings:      impersonationSettings{enabled: true},
+				authorization: "Bearer " + string(impersonationJwt),
+			},
+			want:      http.StatusOK,
+			wantUser:  "alice",
+			wantActor: "root",
+		},
+		{
+			name: "jwt_disabled",
+			args: args{
+				authorization: "Bearer " + string(impersonationJwt),
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "jwt_nested",
+			args: args{
+				settings:      impersonationSettings{enabled: true},
+				authorization: "Bearer " + string(impersonationJwt),
+				impersonate:   "alice",
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "disabled",
+			args: args{
+				authorization: rootLogin,
+				impersonate:   "alice",
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "not_admin",
+			args: args{
+				settings:      impersonationSettings{enabled: true},
+				authorization: aliceLogin,
+				impersonate:   "root",
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "admin_target",
+			args: args{
+				settings:      impersonationSettings{enabled: true},
+				authorization: rootLogin,
+				impersonate:   "admin2",
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "ok_admin_target_allowed",
+			args: args{
+				settings:      impersonationSettings{enabled: true, allowAdmins: true},
+				authorization: rootLogin,
+				impersonate:   "admin2",
+			},
+			want:      http.StatusOK,
+			wantUser:  "admin2",
+			wantActor: "root",
+		},
+		{
+			name: "unknown_target",
+			args: args{
+				settings:      impersonationSettings{enabled: true},
+				authorization: rootLogin,
+				impersonate:   "noUser",
+			},
+			want: http.StatusBadRequest,
+		},
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	e.Use(GetAuthMiddleware(mockDataSourcer{}, "", ""))
+
+	e.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, GetLoggedUser(c).Username+":"+GetActor(c).Username)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			impersonation = tt.args.settings
+
+			req, _ := http.NewRequest("GET", "/ping", nil)
+			req.Header.Set("Authorization", tt.args.authorization)
+
+			if tt.args.impersonate != "" {
+				req.Header.Set("X-Impersonate-User", tt.args.impersonate)
+			}
+
+			w := httptest.NewRecorder()
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+
+			if tt.want == http.StatusOK {
+				assert.Equal(t, tt.wantUser+":"+tt.wantActor, w.Body.String())
+			}
+		})
+	}
+
+	impersonation = impersonationSettings{}
+}
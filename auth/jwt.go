@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/rs/zerolog/log"
+	"go-there/config"
+	"go-there/data"
+	"io/ioutil"
+	"os"
+)
+
+// JwtSigningKey is the private key used to sign and verify JWT tokens.
+var JwtSigningKey crypto.Signer
+
+// JwtSigningAlgorithm is the algorithm matching JwtSigningKey.
+var JwtSigningAlgorithm = jwa.RS256
+
+// InitJwtSigningKey initialize the key used to sign JWT token. If one doesn't exist, a key of the type configured in
+// Server.JwtSigningAlgorithm will be created at the path in the config. If the key already exists, the algorithm is
+// detected from the key type.
+func InitJwtSigningKey(config *config.Configuration) {
+	if config.Server.JwtSigningKeyPath == "" {
+		log.Fatal().Msg("invalid JWT signing key")
+	}
+
+	alg, err := parseJwtAlgorithm(config.Server.JwtSigningAlgorithm)
+
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	if _, err := os.Stat(config.Server.JwtSigningKeyPath); os.IsNotExist(err) {
+		log.Warn().Msgf("no JWT signing key, trying to generate one (%s)", alg)
+
+		key, err := generateJwtSigningKey(alg)
+
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not generate JWT signing key")
+		}
+
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not marshal JWT signing key")
+		}
+
+		pemBytes := pem.EncodeToMemory(
+			&pem.Block{
+				Type:  "PRIVATE KEY",
+				Bytes: keyBytes,
+			},
+		)
+
+		err = ioutil.WriteFile(config.Server.JwtSigningKeyPath, pemBytes, 0700)
+
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not marshal JWT signing key to disk")
+		}
+
+		JwtSigningKey = key
+		JwtSigningAlgorithm = alg
+
+		log.Info().Msg("successfully generated JWT signing key")
+	} else {
+		keyString, err := ioutil.ReadFile(config.Server.JwtSigningKeyPath)
+
+		if err != nil {
+			log.Fatal().Err(err).Msg("error reading JWT signing key from file")
+		}
+
+		key, keyAlg, err := parseJwtSigningKey(keyString)
+
+		if err != nil {
+			log.Fatal().Err(err).Msg("error parsing JWT signing key")
+		}
+
+		if config.Server.JwtSigningAlgorithm != "" && keyAlg != alg {
+			log.Warn().Msgf("configured JWT algorithm is %s but the existing key uses %s, using %s", alg, keyAlg, keyAlg)
+		}
+
+		JwtSigningKey = key
+		JwtSigningAlgorithm = keyAlg
+	}
+}
+
+// parseJwtAlgorithm returns the jwa.SignatureAlgorithm corresponding to the configured algorithm name. An empty name
+// defaults to jwa.RS256. Returns data.ErrSettings if the algorithm is not supported.
+func parseJwtAlgorithm(alg string) (jwa.SignatureAlgorithm, error) {
+	switch jwa.SignatureAlgorithm(alg) {
+	case "", jwa.RS256:
+		return jwa.RS256, nil
+	case jwa.ES256:
+		return jwa.ES256, nil
+	case jwa.EdDSA:
+		return jwa.EdDSA, nil
+	default:
+		return "", fmt.Errorf("%w : unsupported JWT signing algorithm %s", data.ErrSettings, alg)
+	}
+}
+
+// generateJwtSigningKey creates a new private key usable with the provided algorithm.
+func generateJwtSigningKey(alg jwa.SignatureAlgorithm) (crypto.Signer, error) {
+	switch alg {
+	case jwa.RS256:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case jwa.ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwa.EdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+
+		return key, err
+	default:
+		return nil, fmt.Errorf("%w : unsupported JWT signing algorithm %s", data.ErrSettings, alg)
+	}
+}
+
+// parseJwtSigningKey parses a PKCS8 private key in the PEM format and returns (key, algorithm, error). The algorithm is
+// detected from the key type.
+func parseJwtSigningKey(pemBytes []byte) (crypto.Signer, jwa.SignatureAlgorithm, error) {
+	block, _ := pem.Decode(pemBytes)
+
+	if block == nil {
+		return nil, "", fmt.Errorf("%w : %s", data.ErrInit, "no PEM data found")
+	}
+
+	parseResult, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("%w : %s", data.ErrInit, err)
+	}
+
+	switch key := parseResult.(type) {
+	case *rsa.PrivateKey:
+		return key, jwa.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return key, jwa.ES256, nil
+		case elliptic.P384():
+			return key, jwa.ES384, nil
+		case elliptic.P521():
+			return key, jwa.ES512, nil
+		}
+	case ed25519.PrivateKey:
+		return key, jwa.EdDSA, nil
+	}
+
+	return nil, "", fmt.Errorf("%w : %s", data.ErrInit, "unsupported JWT signing key type, must be RSA, ECDSA or Ed25519")
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_parseJwtAlgorithm(t *testing.T) {
+	type args struct {
+		alg string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    jwa.SignatureAlgorithm
+		wantErr bool
+	}{
+		{
+			name:    "default",
+			args:    args{alg: ""},
+			want:    jwa.RS256,
+			wantErr: false,
+		},
+		{
+			name:    "es256",
+			args:    args{alg: "ES256"},
+			want:    jwa.ES256,
+			wantErr: false,
+		},
+		{
+			name:    "eddsa",
+			args:    args{alg: "EdDSA"},
+			want:    jwa.EdDSA,
+			wantErr: false,
+		},
+		{
+			name:    "unsupported",
+			args:    args{alg: "HS256"},
+			want:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJwtAlgorithm(tt.args.alg)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_parseJwtSigningKey(t *testing.T) {
+	tests := []struct {
+		name string
+		alg  jwa.SignatureAlgorithm
+	}{
+		{
+			name: "es256",
+			alg:  jwa.ES256,
+		},
+		{
+			name: "eddsa",
+			alg:  jwa.EdDSA,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := generateJwtSigningKey(tt.alg)
+
+			assert.Nil(t, err)
+
+			keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+
+			assert.Nil(t, err)
+
+			pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+
+			got, gotAlg, err := parseJwtSigningKey(pemBytes)
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.alg, gotAlg)
+			assert.Equal(t, key.Public(), got.Public())
+		})
+	}
+
+	_, _, err := parseJwtSigningKey([]byte("not a key"))
+
+	assert.NotNil(t, err)
+}
+
+func Test_jwtToLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		alg  jwa.SignatureAlgorithm
+	}{
+		{
+			name: "es256",
+			alg:  jwa.ES256,
+		},
+		{
+			name: "eddsa",
+			alg:  jwa.EdDSA,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := generateJwtSigningKey(tt.alg)
+
+			assert.Nil(t, err)
+
+			JwtSigningKey = key
+			JwtSigningAlgorithm = tt.alg
+
+			exp := time.Now().Add(time.Hour).Truncate(time.Second)
+
+			token := jwt.New()
+			_ = token.Set(jwt.ExpirationKey, exp.Unix())
+			_ = token.Set("username", "alice")
+			_ = token.Set("is_admin", true)
+
+			signed, err := jwt.Sign(token, tt.alg, key)
+
+			assert.Nil(t, err)
+
+			got, err := jwtToLogin(string(signed))
+
+			assert.Nil(t, err)
+			assert.Equal(t, "alice", got.User.Username)
+			assert.True(t, got.User.IsAdmin)
+			assert.True(t, exp.Equal(got.ExpiresAt))
+
+			// A token signed with another key must be rejected
+			otherKey, err := generateJwtSigningKey(tt.alg)
+
+			assert.Nil(t, err)
+
+			signed, err = jwt.Sign(token, tt.alg, otherKey)
+
+			assert.Nil(t, err)
+
+			_, err = jwtToLogin(string(signed))
+
+			assert.NotNil(t, err)
+		})
+	}
+}
@@ -1,194 +1,335 @@
 package auth
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
-	"go-there/config"
 	"go-there/data"
-	"golang.org/x/crypto/bcrypt"
-	"io/ioutil"
 	"net/http"
-	"os"
 )
 
-var JwtSigningKey *rsa.PrivateKey
+// GetAuthMiddleware returns a gin middleware used for authentication. This middleware first tries to bind either a
+// X-Api-Key header in a data.HeaderLogin struct or the data contained either in the body or as parameters into a
+// data.Login struct. It then tries to authenticate the user with an api key or an user/password if no key is provided,
+// and falls back to the client certificate and the session cookie. A session used for a state-changing request must
+// provide its CSRF token.
+// When a named API key is used, it must be granted readScope for GET, HEAD and OPTIONS requests and writeScope for any
+// other method. An empty scope does not restrict access. Once authenticated, the user can impersonate another user with
+// a X-Impersonate-User header. Disabled users are rejected whatever their credentials, even if their token is still
+// valid. A username/password is rejected if the user enabled his second factor, or if he is an admin and admins must
+// use one: these users must exchange it for a token or a session first.
+func GetAuthMiddleware(ds DataSourcer, readScope string, writeScope string) func(c *gin.Context) {
+	return getAuthMiddleware(ds, readScope, writeScope, false)
+}
 
-// InitJwtSigningKey initialize the RSA key used to sign JWT token. If one doesn't exist, it will be created at the
-// path in the config.
-func InitJwtSigningKey(config *config.Configuration) {
-	if config.Server.JwtSigningKeyPath == "" {
-		log.Fatal().Msg("invalid JWT signing key")
-	}
+// GetLoginMiddleware returns a gin middleware used for authentication by the endpoints exchanging a username/password
+// for a token or a session. It works as GetAuthMiddleware, except that a username/password is accepted without a second
+// factor, which must then be checked by the endpoint.
+func GetLoginMiddleware(ds DataSourcer, readScope string, writeScope string) func(c *gin.Context) {
+	return getAuthMiddleware(ds, readScope, writeScope, true)
+}
+
+// getAuthMiddleware returns the authentication middleware. If passwordOnly is set, a username/password is accepted
+// without a second factor.
+func getAuthMiddleware(ds DataSourcer, readScope string, writeScope string, passwordOnly bool) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var hl data.HeaderLogin
 
-	if _, err := os.Stat(config.Server.JwtSigningKeyPath); os.IsNotExist(err) {
-		log.Warn().Msg("no JWT signing key, trying to generate one")
+		// Tries to bind authentication headers first
+		if err := c.ShouldBindHeader(&hl); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
 
-		JwtSigningKey, err = rsa.GenerateKey(rand.Reader, 4096)
+		authenticate(c, ds, hl, readScope, writeScope, passwordOnly)
 
-		if err != nil {
-			log.Fatal().Err(err).Msg("could not generate JWT signing key")
+		if c.IsAborted() {
+			return
 		}
 
-		keyBytes, err := x509.MarshalPKCS8PrivateKey(JwtSigningKey)
+		// The user owning the credentials is checked, not the one impersonated from a token
+		if GetActor(c).IsDisabled() {
+			c.AbortWithStatusJSON(http.StatusForbidden, data.ErrorResponse{Error: "account disabled"})
+			return
+		}
 
-		if err != nil {
-			log.Fatal().Err(err).Msg("could not marshal JWT signing key")
+		// The impersonation is only applied once the real user is authenticated
+		if hl.XImpersonateUser == "" {
+			return
 		}
 
-		pemBytes := pem.EncodeToMemory(
-			&pem.Block{
-				Type:  "RSA PRIVATE KEY",
-				Bytes: keyBytes,
-			},
-		)
+		impersonate(c, ds, hl.XImpersonateUser)
+	}
+}
 
-		err = ioutil.WriteFile(config.Server.JwtSigningKeyPath, pemBytes, 0700)
+// authenticate tries to authenticate the user with the provided headers, the client certificate or the session cookie.
+// If passwordOnly is not set, a username/password needing a second factor is rejected. If it fails, the request is
+// aborted with the matching status.
+func authenticate(c *gin.Context, ds DataSourcer, hl data.HeaderLogin, readScope string, writeScope string,
+	passwordOnly bool) {
+	// Check API key
+	if hl.XApiKey != "" {
+		// If the header contains an API key, do not bind the other fields
+		pk, err := parseApiKey(hl.XApiKey)
 
 		if err != nil {
-			log.Fatal().Err(err).Msg("could not marshal JWT signing key to disk")
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
 		}
 
-		log.Info().Msg("successfully generated JWT signing key")
-	} else {
-		keyString, err := ioutil.ReadFile(config.Server.JwtSigningKeyPath)
-
-		if err != nil {
-			log.Fatal().Err(err).Msg("error reading JWT signing key from file")
+		// API key failures are only tracked by IP address, so they cannot be used to lock out a user
+		if abortIfLocked(c, "") {
+			return
 		}
 
-		block, _ := pem.Decode(keyString)
-		parseResult, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		u, key, err := selectApiKeyLogin(c.Request.Context(), ds, pk)
 
 		if err != nil {
-			log.Fatal().Err(err).Msg("error parsing JWT signing key")
+			if errors.Is(err, data.ErrSqlNoRow) || errors.Is(err, data.ErrInvalidAuth) {
+				loginLimiter.registerFailure("", c.ClientIP())
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+
+			c.AbortWithStatus(http.StatusInternalServerError)
+			_ = c.Error(err)
+			return
+		}
+
+		if u.Username == "" {
+			loginLimiter.registerFailure("", c.ClientIP())
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
 
-		var ok bool
-		JwtSigningKey, ok = parseResult.(*rsa.PrivateKey)
+		c.Keys = make(map[string]interface{})
+
+		// Named keys can expire and be restricted to some scopes
+		if key.Id != 0 {
+			if key.IsExpired() {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+
+			if scope := requiredScope(c, readScope, writeScope); scope != "" && !key.HasScope(scope) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
 
-		if !ok {
-			log.Fatal().Err(err).Msg("error JWT signing key type, must be RSA")
+			if err := ds.UpdateApiKeyLastUsed(c.Request.Context(), key.Id); err != nil {
+				log.Warn().Err(err).Msg("error updating API key last use")
+			}
+
+			c.Keys["apiKey"] = key
 		}
-	}
 
-}
+		// Keep track of the user if he successfully authenticated
+		c.Keys["authType"] = data.ApiKeyAuth
+		c.Keys["user"] = u
+		c.Keys["logUser"] = u.Username
+		// Keep track of which user data we want to access
+		c.Keys["reqUser"] = c.Param("user")
 
-// GetAuthMiddleware returns a gin middleware used for authentication. This middleware first tries to bind either a
-// X-Api-Key header in a data.HeaderLogin struct or the data contained either in the body or as parameters into a
-// data.Login struct. It then tries to authenticate the user with an api key or an user/password if no key is provided.
-func GetAuthMiddleware(ds DataSourcer) func(c *gin.Context) {
-	return func(c *gin.Context) {
-		var hl data.HeaderLogin
+		return
+	}
 
-		// Tries to bind authentication headers first
-		if err := c.ShouldBindHeader(&hl); err != nil {
+	// Check basic auth and bearer token
+	if hl.Authorization != "" {
+		ld, err := authHeaderToLoginData(hl.Authorization)
+
+		if err != nil {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
 
-		// Check API key
-		if hl.XApiKey != "" {
-			// If the header contains an API key, do not bind the other fields
-			hash, ak, err := validateApiKey(hl.XApiKey)
+		u := data.User{}
+
+		if ld.DataType == data.Basic {
+			// Checked before the password comparison, which is costly
+			if abortIfLocked(c, ld.BasicAuthLogin.Username) {
+				return
+			}
+
+			u, err = ds.SelectUserLogin(c.Request.Context(), ld.BasicAuthLogin.Username)
 
 			if err != nil {
-				c.AbortWithStatus(http.StatusBadRequest)
+				loginLimiter.registerFailure(ld.BasicAuthLogin.Username, c.ClientIP())
+				c.AbortWithStatus(http.StatusUnauthorized)
 				return
 			}
 
-			u, err := ds.SelectUserLoginByApiKeyHash(string(hash))
+			err = ComparePassword(u.PasswordHash, []byte(ld.BasicAuthLogin.Password))
 
 			if err != nil {
-				c.AbortWithStatus(http.StatusInternalServerError)
-				_ = c.Error(err)
+				loginLimiter.registerFailure(ld.BasicAuthLogin.Username, c.ClientIP())
+				c.AbortWithStatus(http.StatusUnauthorized)
 				return
 			}
 
-			if u.Username == "" {
+			loginLimiter.registerSuccess(c.Request.Context(), u.Username)
+
+			// Upgrade the hash if the hashing settings changed since it was created
+			if NeedsRehash(u.PasswordHash) {
+				rehashPassword(c.Request.Context(), ds, u, ld.BasicAuthLogin.Password)
+			}
+
+			if !passwordOnly && !checkSecondFactorNotNeeded(c, u) {
+				return
+			}
+		} else {
+			if ld.IsExpired() {
 				c.AbortWithStatus(http.StatusUnauthorized)
 				return
 			}
 
-			err = bcrypt.CompareHashAndPassword(u.ApiKeyHash, ak)
+			// We still check if the user has not been deleted before his token expired
+			u, err = ds.SelectUserLogin(c.Request.Context(), ld.JwtLogin.User.Username)
 
 			if err != nil {
 				c.AbortWithStatus(http.StatusUnauthorized)
 				return
 			}
+		}
 
-			c.Keys = make(map[string]interface{})
+		c.Keys = make(map[string]interface{})
 
-			// Keep track of the user if he successfully authenticated
-			c.Keys["user"] = u
-			c.Keys["logUser"] = u.Username
-			// Keep track of which user data we want to access
-			c.Keys["reqUser"] = c.Param("user")
+		// Keep track of the user if he successfully authenticated
+		c.Keys["authType"] = ld.DataType
+		c.Keys["user"] = u
+		c.Keys["logUser"] = u.Username
+		// Keep track of which user data we want to access
+		c.Keys["reqUser"] = c.Param("user")
 
-			return
+		// Impersonation tokens keep track of the real user
+		if ld.DataType == data.Jwt && ld.JwtLogin.Actor != "" {
+			impersonateFromJwt(c, ds, ld.JwtLogin.Actor)
 		}
 
-		// Check basic auth and bearer token
-		if hl.Authorization != "" {
-			ld, err := authHeaderToLoginData(hl.Authorization)
+		return
+	}
+
+	// Check the client certificate, already verified by the TLS server
+	if username := clientCertUsername(c); username != "" {
+		u, err := ds.SelectUserLogin(c.Request.Context(), username)
 
-			if err != nil {
-				c.AbortWithStatus(http.StatusBadRequest)
+		if err != nil || u.Username == "" {
+			if err != nil && !errors.Is(err, data.ErrSqlNoRow) {
+				c.AbortWithStatus(http.StatusInternalServerError)
+				_ = c.Error(err)
 				return
 			}
 
-			u := data.User{}
-
-			if ld.DataType == data.Basic {
-				u, err = ds.SelectUserLogin(ld.BasicAuthLogin.Username)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
 
-				if err != nil {
-					c.AbortWithStatus(http.StatusUnauthorized)
-					return
-				}
+		c.Keys = make(map[string]interface{})
 
-				err = bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(ld.BasicAuthLogin.Password))
+		// Keep track of the user if he successfully authenticated
+		c.Keys["authType"] = data.ClientCertAuth
+		c.Keys["user"] = u
+		c.Keys["logUser"] = u.Username
+		// Keep track of which user data we want to access
+		c.Keys["reqUser"] = c.Param("user")
 
-				if err != nil {
-					c.AbortWithStatus(http.StatusUnauthorized)
-					return
-				}
-			} else {
-				if ld.IsExpired() {
-					c.AbortWithStatus(http.StatusUnauthorized)
-					return
-				}
+		return
+	}
 
-				// We still check if the user has not been deleted before his token expired
-				u, err = ds.SelectUserLogin(ld.JwtLogin.User.Username)
+	// Check the session cookie
+	if token, err := c.Cookie(SessionCookieName); err == nil && token != "" {
+		jl, csrfToken, err := sessionToLogin(token)
 
-				if err != nil {
-					c.AbortWithStatus(http.StatusUnauthorized)
-					return
-				}
-			}
+		if err != nil || jl.IsExpired() {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
 
-			c.Keys = make(map[string]interface{})
+		if !validCsrf(c, csrfToken) {
+			c.AbortWithStatusJSON(http.StatusForbidden, data.ErrorResponse{Error: "invalid csrf token"})
+			return
+		}
 
-			// Keep track of the user if he successfully authenticated
-			c.Keys["user"] = u
-			c.Keys["logUser"] = u.Username
-			// Keep track of which user data we want to access
-			c.Keys["reqUser"] = c.Param("user")
+		// We still check if the user has not been deleted before his session expired
+		u, err := ds.SelectUserLogin(c.Request.Context(), jl.User.Username)
 
+		if err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
-		c.AbortWithStatus(http.StatusUnauthorized)
+		c.Keys = make(map[string]interface{})
+
+		// Keep track of the user if he successfully authenticated
+		c.Keys["authType"] = data.SessionAuth
+		c.Keys["user"] = u
+		c.Keys["logUser"] = u.Username
+		// Keep track of which user data we want to access
+		c.Keys["reqUser"] = c.Param("user")
+
+		return
 	}
+
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// abortIfLocked aborts the request with http.StatusTooManyRequests and a Retry-After header if the user or the client
+// IP address cannot attempt to authenticate yet. The username is ignored if empty. Returns true if the request was
+// aborted.
+func abortIfLocked(c *gin.Context, username string) bool {
+	return abortWithRetryAfter(c, loginLimiter.retryAfter(c.Request.Context(), username, c.ClientIP()))
 }
 
-// GetPermissionsMiddleware verify that the logged used has the permission to access the requested resource. A user
-// can only access his profile, and admin can access any profile.
-func GetPermissionsMiddleware(adminOnly bool) func(c *gin.Context) {
+// rehashPassword hashes the password with the current settings and updates the user in the datasource. Errors are only
+// logged, as the user successfully authenticated.
+func rehashPassword(ctx context.Context, ds DataSourcer, u data.User, password string) {
+	hash, err := GetHashFromPassword(password)
+
+	if err != nil {
+		log.Warn().Err(err).Msg("error rehashing password")
+		return
+	}
+
+	u.PasswordHash = hash
+
+	if err := ds.UpdateUserPassword(ctx, u); err != nil {
+		log.Warn().Err(err).Msg("error updating rehashed password")
+	}
+}
+
+// checkSecondFactorNotNeeded checks that a user can authenticate with his password alone: he must not have enabled his
+// second factor, and must not be an admin if admins must use one. Returns false and aborts the request otherwise.
+func checkSecondFactorNotNeeded(c *gin.Context, u data.User) bool {
+	if u.TotpEnabled {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, data.ErrorResponse{
+			Error: "2fa enabled, authenticate with a token or a session",
+		})
+		return false
+	}
+
+	if requireAdminTotp && u.IsAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, data.ErrorResponse{Error: "2fa required for admin accounts"})
+		return false
+	}
+
+	return true
+}
+
+// requiredScope returns the scope a named API key needs to be granted for the request method.
+func requiredScope(c *gin.Context, readScope string, writeScope string) string {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return readScope
+	default:
+		return writeScope
+	}
+}
+
+// GetPermissionsMiddleware verify that the logged used has the permission to access the requested resource. An admin
+// can access any resource, and a user whose roles grant the permission can access the resources of any user. Otherwise,
+// a user can only access his own resources, and no resource at all if adminOnly is set. An empty permission is never
+// granted by roles.
+func GetPermissionsMiddleware(adminOnly bool, permission string) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		loggedUser := GetLoggedUser(c)
 
@@ -197,8 +338,13 @@ func GetPermissionsMiddleware(adminOnly bool) func(c *gin.Context) {
 			return
 		}
 
+		// If one of the user roles allows the access
+		if permission != "" && HasPermission(loggedUser, permission) {
+			return
+		}
+
 		// If admin rights are required
-		if adminOnly && !loggedUser.IsAdmin {
+		if adminOnly {
 			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
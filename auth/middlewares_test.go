@@ -1,20 +1,25 @@
 package auth
 
 import (
+	"context"
 	"encoding/base64"
 	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
 	"github.com/stretchr/testify/assert"
+	"go-there/config"
 	"go-there/data"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type mockDataSourcer struct {
 }
 
-func (mockDataSourcer) SelectUserLogin(username string) (data.User, error) {
+func (mockDataSourcer) SelectUserLogin(ctx context.Context, username string) (data.User, error) {
 	switch username {
 	case "alice":
 		return data.User{
@@ -23,6 +28,35 @@ func (mockDataSourcer) SelectUserLogin(username string) (data.User, error) {
 			PasswordHash: []byte("$2a$10$5vUiFPUJJoSyIdCIhn1/n.0yxyhaHjR2L3qS1JKBh1x2UOWd2cEqi"),
 			ApiKeyHash:   []byte("$2a$10$.KgKwnN06VxwTwt4zyVYRuTTeQPGQ2/5HMIEa/oNZUSH/WmTJFlwO"),
 		}, nil
+	case "root":
+		return data.User{
+			Username:     "root",
+			IsAdmin:      true,
+			PasswordHash: []byte("$2a$10$5vUiFPUJJoSyIdCIhn1/n.0yxyhaHjR2L3qS1JKBh1x2UOWd2cEqi"),
+		}, nil
+	case "admin2":
+		return data.User{Username: "admin2", IsAdmin: true}, nil
+	case "carol":
+		return data.User{
+			Username:     "carol",
+			PasswordHash: []byte("$2a$10$5vUiFPUJJoSyIdCIhn1/n.0yxyhaHjR2L3qS1JKBh1x2UOWd2cEqi"),
+			Disabled:     true,
+		}, nil
+	case "dave":
+		disabledUntil := time.Now().Add(-time.Hour)
+
+		return data.User{
+			Username:      "dave",
+			PasswordHash:  []byte("$2a$10$5vUiFPUJJoSyIdCIhn1/n.0yxyhaHjR2L3qS1JKBh1x2UOWd2cEqi"),
+			Disabled:      true,
+			DisabledUntil: &disabledUntil,
+		}, nil
+	case "erin":
+		return data.User{
+			Username:     "erin",
+			PasswordHash: []byte("$2a$10$5vUiFPUJJoSyIdCIhn1/n.0yxyhaHjR2L3qS1JKBh1x2UOWd2cEqi"),
+			TotpEnabled:  true,
+		}, nil
 	case "aliceErr":
 		return data.User{}, data.ErrSql
 	case "noUser":
@@ -32,7 +66,7 @@ func (mockDataSourcer) SelectUserLogin(username string) (data.User, error) {
 	return data.User{}, nil
 }
 
-func (mockDataSourcer) SelectUserLoginByApiKeyHash(apiKeyHash string) (data.User, error) {
+func (mockDataSourcer) SelectUserLoginByApiKeyHash(ctx context.Context, apiKeyHash string) (data.User, error) {
 	switch apiKeyHash {
 	case ".KgKwnN06VxwTwt4zyVYRu":
 		return data.User{
@@ -43,7 +77,51 @@ func (mockDataSourcer) SelectUserLoginByApiKeyHash(apiKeyHash string) (data.User
 		}, nil
 	}
 
-	return data.User{}, nil
+	return data.User{}, data.ErrSqlNoRow
+}
+
+var mainApiKey, mainApiKeyId, mainApiKeyHash, _ = GenerateApiKey()
+var scopedApiKey, scopedApiKeyId, scopedApiKeyHash, _ = GenerateApiKey()
+var expiredApiKey, expiredApiKeyId, expiredApiKeyHash, _ = GenerateApiKey()
+
+func (mockDataSourcer) SelectUserLoginByApiKeyId(ctx context.Context, keyId string) (data.User, error) {
+	switch keyId {
+	case mainApiKeyId:
+		return data.User{Id: 2, Username: "bob", ApiKeyId: mainApiKeyId, ApiKeyHash: mainApiKeyHash}, nil
+	}
+
+	return data.User{}, data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) SelectApiKeyLogin(ctx context.Context, keyHash string) (data.User, data.ApiKey, error) {
+	return data.User{}, data.ApiKey{}, data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) SelectApiKeyLoginById(ctx context.Context, keyId string) (data.User, data.ApiKey, error) {
+	u := data.User{Id: 1, Username: "alice"}
+
+	switch keyId {
+	case scopedApiKeyId:
+		return u, data.ApiKey{Id: 1, UserId: 1, Name: "ci", KeyId: scopedApiKeyId, KeyHash: scopedApiKeyHash, Scopes: "paths:read"}, nil
+	case expiredApiKeyId:
+		expiresAt := time.Now().Add(-time.Hour)
+
+		return u, data.ApiKey{Id: 2, UserId: 1, Name: "old", KeyId: expiredApiKeyId, KeyHash: expiredApiKeyHash, Scopes: "*", ExpiresAt: &expiresAt}, nil
+	}
+
+	return data.User{}, data.ApiKey{}, data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) UpdateApiKeyLastUsed(ctx context.Context, id int) error {
+	return nil
+}
+
+// rehashedPassword contains the last password hash updated through the mock
+var rehashedPassword []byte
+
+func (mockDataSourcer) UpdateUserPassword(ctx context.Context, user data.User) error {
+	rehashedPassword = user.PasswordHash
+	return nil
 }
 
 func TestGetAuthMiddleware(t *testing.T) {
@@ -174,6 +252,74 @@ func TestGetAuthMiddleware(t *testing.T) {
 				body: nil,
 			},
 		},
+		{
+			name: "ok_api_key_id",
+			args: args{
+				req: func() *http.Request {
+					req, _ := http.NewRequest("GET", "/ping", nil)
+					req.Header = map[string][]string{
+						"X-Api-Key": {mainApiKey},
+					}
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusOK,
+				body: nil,
+			},
+		},
+		{
+			name: "bad_secret_api_key_id",
+			args: args{
+				req: func() *http.Request {
+					req, _ := http.NewRequest("GET", "/ping", nil)
+					req.Header = map[string][]string{
+						"X-Api-Key": {mainApiKey[:len(mainApiKey)-4] + "AAAA"},
+					}
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusUnauthorized,
+				body: nil,
+			},
+		},
+		{
+			name: "unknown_api_key_id",
+			args: args{
+				req: func() *http.Request {
+					req, _ := http.NewRequest("GET", "/ping", nil)
+					req.Header = map[string][]string{
+						"X-Api-Key": {"gt_0123456789abcdef_" + mainApiKey[len(apiKeyPrefix)+17:]},
+					}
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusUnauthorized,
+				body: nil,
+			},
+		},
+		{
+			name: "corrupt_api_key_id",
+			args: args{
+				req: func() *http.Request {
+					req, _ := http.NewRequest("GET", "/ping", nil)
+					req.Header = map[string][]string{
+						"X-Api-Key": {"gt_0123_secret"},
+					}
+
+					return req
+				}(),
+			},
+			want: resp{
+				code: http.StatusBadRequest,
+				body: nil,
+			},
+		},
 		{
 			name: "ok_bad_api_key",
 			args: args{
@@ -232,7 +378,7 @@ func TestGetAuthMiddleware(t *testing.T) {
 
 	_, e := gin.CreateTestContext(httptest.NewRecorder())
 
-	e.Use(GetAuthMiddleware(mockDataSourcer{}))
+	e.Use(GetAuthMiddleware(mockDataSourcer{}, "", ""))
 
 	e.GET("/ping", func(c *gin.Context) {
 		c.Status(http.StatusOK)
@@ -250,6 +396,178 @@ func TestGetAuthMiddleware(t *testing.T) {
 	}
 }
 
+func TestGetAuthMiddlewareScopes(t *testing.T) {
+	type args struct {
+		method string
+		apiKey string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want int
+	}{
+		{
+			name: "ok_read_scope",
+			args: args{
+				method: "GET",
+				apiKey: scopedApiKey,
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "missing_write_scope",
+			args: args{
+				method: "POST",
+				apiKey: scopedApiKey,
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "expired",
+			args: args{
+				method: "GET",
+				apiKey: expiredApiKey,
+			},
+			want: http.StatusUnauthorized,
+		},
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	e.Use(GetAuthMiddleware(mockDataSourcer{}, data.ScopePathsRead, data.ScopePathsWrite))
+
+	e.GET("/ping", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	e.POST("/ping", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.args.method, "/ping", nil)
+			req.Header = map[string][]string{
+				"X-Api-Key": {tt.args.apiKey},
+			}
+
+			w := httptest.NewRecorder()
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestGetAuthMiddlewareRehash(t *testing.T) {
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	e.Use(GetAuthMiddleware(mockDataSourcer{}, "", ""))
+
+	e.GET("/ping", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	login := func() int {
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		req.Header = map[string][]string{
+			"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte("alice:superpassword"))},
+		}
+
+		w := httptest.NewRecorder()
+
+		e.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	// The hash matches the default settings
+	rehashedPassword = nil
+
+	assert.Equal(t, http.StatusOK, login())
+	assert.Nil(t, rehashedPassword)
+
+	// The hash algorithm changed
+	err := ApplyPasswordSettings(&config.Configuration{
+		PasswordHashing: config.PasswordHashing{Algorithm: "argon2id", Argon2MemoryKiB: 1024, Argon2Time: 1},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, login())
+	assert.True(t, strings.HasPrefix(string(rehashedPassword), "$argon2id$"))
+	assert.Nil(t, ComparePassword(rehashedPassword, []byte("superpassword")))
+
+	_ = ApplyPasswordSettings(&config.Configuration{})
+}
+
+func TestGetAuthMiddlewareDisabled(t *testing.T) {
+	key, err := generateJwtSigningKey(jwa.ES256)
+
+	assert.Nil(t, err)
+
+	JwtSigningKey = key
+	JwtSigningAlgorithm = jwa.ES256
+
+	// Token of carol signed before she was disabled
+	token := jwt.New()
+	_ = token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour).Unix())
+	_ = token.Set("username", "carol")
+	_ = token.Set("is_admin", false)
+	carolJwt, _ := jwt.Sign(token, jwa.ES256, key)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{
+			name:          "ok_suspension_expired",
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("dave:superpassword")),
+			want:          http.StatusOK,
+		},
+		{
+			name:          "disabled_basic_auth",
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("carol:superpassword")),
+			want:          http.StatusForbidden,
+		},
+		{
+			name:          "disabled_bad_password",
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("carol:superrpassword")),
+			want:          http.StatusUnauthorized,
+		},
+		{
+			name:          "disabled_jwt",
+			authorization: "Bearer " + string(carolJwt),
+			want:          http.StatusForbidden,
+		},
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	e.Use(GetAuthMiddleware(mockDataSourcer{}, "", ""))
+
+	e.GET("/ping", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/ping", nil)
+			req.Header = map[string][]string{
+				"Authorization": {tt.authorization},
+			}
+
+			w := httptest.NewRecorder()
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
 func TestGetAPermissionsMiddleware(t *testing.T) {
 	type resp struct {
 		code int
@@ -355,7 +673,7 @@ func TestGetAPermissionsMiddleware(t *testing.T) {
 				c.Keys["reqUser"] = tt.args.requestedUser
 			})
 
-			e.Use(GetPermissionsMiddleware(false))
+			e.Use(GetPermissionsMiddleware(false, ""))
 
 			e.GET("/ping", func(c *gin.Context) {
 				c.Status(http.StatusOK)
@@ -476,7 +794,7 @@ func TestGetAPermissionsMiddlewareAdmin(t *testing.T) {
 				c.Keys["reqUser"] = tt.args.requestedUser
 			})
 
-			e.Use(GetPermissionsMiddleware(true))
+			e.Use(GetPermissionsMiddleware(true, ""))
 
 			e.GET("/ping", func(c *gin.Context) {
 				c.Status(http.StatusOK)
@@ -491,3 +809,149 @@ func TestGetAPermissionsMiddlewareAdmin(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPermissionsMiddlewareRoles(t *testing.T) {
+	err := ApplyRoles(&config.Configuration{Roles: map[string]config.Role{
+		"helpdesk": {Permissions: []string{data.PermUsersUpdate}},
+	}})
+
+	assert.Nil(t, err)
+
+	type args struct {
+		method     string
+		adminOnly  bool
+		permission string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want int
+	}{
+		{
+			name: "granted",
+			args: args{method: "PATCH", permission: data.PermUsersUpdate},
+			want: http.StatusOK,
+		},
+		{
+			name: "granted_admin_only",
+			args: args{method: "PATCH", adminOnly: true, permission: data.PermUsersUpdate},
+			want: http.StatusOK,
+		},
+		{
+			name: "not_granted",
+			args: args{method: "DELETE", permission: data.PermUsersDelete},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "no_permission",
+			args: args{method: "PATCH", permission: ""},
+			want: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+			e.Use(func(c *gin.Context) {
+				c.Keys = make(map[string]interface{})
+				c.Keys["user"] = data.User{Username: "alice", Roles: "helpdesk"}
+				c.Keys["reqUser"] = "bob"
+			})
+
+			e.Use(GetPermissionsMiddleware(tt.args.adminOnly, tt.args.permission))
+
+			e.Handle(tt.args.method, "/ping", func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.args.method, "/ping", nil)
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestGetAuthMiddlewareSecondFactor(t *testing.T) {
+	basic := func(username string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":superpassword"))
+	}
+
+	tests := []struct {
+		name             string
+		authorization    string
+		login            bool
+		requireAdminTotp bool
+		want             int
+	}{
+		{
+			name:          "no_2fa",
+			authorization: basic("alice"),
+			want:          http.StatusOK,
+		},
+		{
+			name:          "2fa_enabled",
+			authorization: basic("erin"),
+			want:          http.StatusUnauthorized,
+		},
+		{
+			name:          "2fa_enabled_login",
+			authorization: basic("erin"),
+			login:         true,
+			want:          http.StatusOK,
+		},
+		{
+			name:             "admin_2fa_required",
+			authorization:    basic("root"),
+			requireAdminTotp: true,
+			want:             http.StatusForbidden,
+		},
+		{
+			name:          "admin_2fa_not_required",
+			authorization: basic("root"),
+			want:          http.StatusOK,
+		},
+		{
+			name:             "admin_2fa_required_login",
+			authorization:    basic("root"),
+			login:            true,
+			requireAdminTotp: true,
+			want:             http.StatusOK,
+		},
+	}
+
+	defer ApplyTwoFactorSettings(&config.Configuration{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ApplyTwoFactorSettings(&config.Configuration{TwoFactor: config.TwoFactor{RequireForAdmins: tt.requireAdminTotp}})
+
+			_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+			if tt.login {
+				e.Use(GetLoginMiddleware(mockDataSourcer{}, "", ""))
+			} else {
+				e.Use(GetAuthMiddleware(mockDataSourcer{}, "", ""))
+			}
+
+			e.GET("/ping", func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req, _ := http.NewRequest("GET", "/ping", nil)
+			req.Header = map[string][]string{
+				"Authorization": {tt.authorization},
+			}
+
+			w := httptest.NewRecorder()
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"go-there/config"
+	"go-there/data"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hashing algorithms
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// Default argon2id parameters, the memory is in KiB
+const (
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Time        = 3
+	defaultArgon2Parallelism = 2
+	argon2SaltSize           = 16
+	argon2KeySize            = 32
+)
+
+// hashSettings contains the parameters used to hash new passwords.
+type hashSettings struct {
+	algorithm         string
+	bcryptCost        int
+	argon2Memory      uint32
+	argon2Time        uint32
+	argon2Parallelism uint8
+}
+
+// passwordHashing contains the current password hashing settings. It defaults to bcrypt with bcrypt.DefaultCost.
+var passwordHashing = hashSettings{
+	algorithm:         HashBcrypt,
+	bcryptCost:        bcrypt.DefaultCost,
+	argon2Memory:      defaultArgon2Memory,
+	argon2Time:        defaultArgon2Time,
+	argon2Parallelism: defaultArgon2Parallelism,
+}
+
+// argon2Hash represents a parsed argon2id hash.
+type argon2Hash struct {
+	version     int
+	memory      uint32
+	time        uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// ApplyPasswordSettings parses the password hashing settings, then apply them to the global variables. If a setting has
+// a zero value, the default is used. Returns a data.ErrSettings if the algorithm or a parameter is invalid.
+func ApplyPasswordSettings(conf *config.Configuration) error {
+	s := hashSettings{
+		algorithm:         HashBcrypt,
+		bcryptCost:        bcrypt.DefaultCost,
+		argon2Memory:      defaultArgon2Memory,
+		argon2Time:        defaultArgon2Time,
+		argon2Parallelism: defaultArgon2Parallelism,
+	}
+
+	ph := conf.PasswordHashing
+
+	switch ph.Algorithm {
+	case "", HashBcrypt:
+	case HashArgon2id:
+		s.algorithm = HashArgon2id
+	default:
+		return fmt.Errorf("%w : unsupported password hashing algorithm %s", data.ErrSettings, ph.Algorithm)
+	}
+
+	if ph.BcryptCost != 0 {
+		if ph.BcryptCost < bcrypt.MinCost || ph.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("%w : bcrypt cost must be between %d and %d", data.ErrSettings, bcrypt.MinCost,
+				bcrypt.MaxCost)
+		}
+
+		s.bcryptCost = ph.BcryptCost
+	}
+
+	if ph.Argon2Time != 0 {
+		if ph.Argon2Time < 1 {
+			return fmt.Errorf("%w : invalid argon2 time %d", data.ErrSettings, ph.Argon2Time)
+		}
+
+		s.argon2Time = uint32(ph.Argon2Time)
+	}
+
+	if ph.Argon2Parallelism != 0 {
+		if ph.Argon2Parallelism < 1 || ph.Argon2Parallelism > 255 {
+			return fmt.Errorf("%w : invalid argon2 parallelism %d", data.ErrSettings, ph.Argon2Parallelism)
+		}
+
+		s.argon2Parallelism = uint8(ph.Argon2Parallelism)
+	}
+
+	// Checked last, as the minimum depends on the parallelism
+	if ph.Argon2MemoryKiB != 0 {
+		// argon2 needs at least 8 KiB per lane
+		if ph.Argon2MemoryKiB < 8*int(s.argon2Parallelism) || ph.Argon2MemoryKiB > 1<<22 {
+			return fmt.Errorf("%w : invalid argon2 memory %d", data.ErrSettings, ph.Argon2MemoryKiB)
+		}
+
+		s.argon2Memory = uint32(ph.Argon2MemoryKiB)
+	}
+
+	passwordHashing = s
+
+	return nil
+}
+
+// GetHashFromPassword takes a password, and returns (complete hash, error). The hash is created with the configured
+// algorithm and contains its format identifier and parameters.
+func GetHashFromPassword(password string) ([]byte, error) {
+	if passwordHashing.algorithm == HashArgon2id {
+		salt := make([]byte, argon2SaltSize)
+
+		_, err := rand.Read(salt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		h := argon2Hash{
+			version:     argon2.Version,
+			memory:      passwordHashing.argon2Memory,
+			time:        passwordHashing.argon2Time,
+			parallelism: passwordHashing.argon2Parallelism,
+			salt:        salt,
+		}
+
+		h.key = argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.parallelism, argon2KeySize)
+
+		return h.encode(), nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashing.bcryptCost)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// ComparePassword compares a hash created by GetHashFromPassword with a password. The algorithm is detected from the
+// hash format, so hashes created with previous settings can still be verified. Returns nil on success, or a
+// data.ErrInvalidAuth if the password does not match or the hash format is unknown.
+func ComparePassword(hash []byte, password []byte) error {
+	if bytes.HasPrefix(hash, []byte("$"+HashArgon2id+"$")) {
+		h, err := parseArgon2Hash(hash)
+
+		if err != nil {
+			return err
+		}
+
+		key := argon2.IDKey(password, h.salt, h.time, h.memory, h.parallelism, uint32(len(h.key)))
+
+		if subtle.ConstantTimeCompare(key, h.key) != 1 {
+			return data.ErrInvalidAuth
+		}
+
+		return nil
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, password)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrInvalidAuth, err)
+	}
+
+	return nil
+}
+
+// NeedsRehash returns true if a valid hash was not created with the current algorithm and parameters.
+func NeedsRehash(hash []byte) bool {
+	if bytes.HasPrefix(hash, []byte("$"+HashArgon2id+"$")) {
+		h, err := parseArgon2Hash(hash)
+
+		if err != nil {
+			return false
+		}
+
+		return passwordHashing.algorithm != HashArgon2id ||
+			h.version != argon2.Version ||
+			h.memory != passwordHashing.argon2Memory ||
+			h.time != passwordHashing.argon2Time ||
+			h.parallelism != passwordHashing.argon2Parallelism
+	}
+
+	cost, err := bcrypt.Cost(hash)
+
+	if err != nil {
+		return false
+	}
+
+	return passwordHashing.algorithm != HashBcrypt || cost != passwordHashing.bcryptCost
+}
+
+// encode returns the hash in the PHC string format: $argon2id$v=19$m=65536,t=3,p=2$salt$key
+func (h argon2Hash) encode() []byte {
+	return []byte(fmt.Sprintf(
+		"$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		HashArgon2id,
+		h.version,
+		h.memory,
+		h.time,
+		h.parallelism,
+		base64.RawStdEncoding.EncodeToString(h.salt),
+		base64.RawStdEncoding.EncodeToString(h.key),
+	))
+}
+
+// parseArgon2Hash parses an argon2id hash in the PHC string format. Returns a data.ErrInvalidAuth if the format is
+// invalid.
+func parseArgon2Hash(hash []byte) (argon2Hash, error) {
+	parts := bytes.Split(hash, []byte("$"))
+
+	if len(parts) != 6 || string(parts[1]) != HashArgon2id {
+		return argon2Hash{}, data.ErrInvalidAuth
+	}
+
+	h := argon2Hash{}
+
+	_, err := fmt.Sscanf(string(parts[2]), "v=%d", &h.version)
+
+	if err != nil {
+		return argon2Hash{}, fmt.Errorf("%w : %s", data.ErrInvalidAuth, err)
+	}
+
+	_, err = fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.parallelism)
+
+	if err != nil {
+		return argon2Hash{}, fmt.Errorf("%w : %s", data.ErrInvalidAuth, err)
+	}
+
+	h.salt, err = base64.RawStdEncoding.DecodeString(string(parts[4]))
+
+	if err != nil {
+		return argon2Hash{}, fmt.Errorf("%w : %s", data.ErrInvalidAuth, err)
+	}
+
+	h.key, err = base64.RawStdEncoding.DecodeString(string(parts[5]))
+
+	if err != nil || len(h.key) == 0 {
+		return argon2Hash{}, data.ErrInvalidAuth
+	}
+
+	return h, nil
+}
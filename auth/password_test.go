@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func TestApplyPasswordSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    config.PasswordHashing
+		wantErr bool
+	}{
+		{
+			name:    "default",
+			conf:    config.PasswordHashing{},
+			wantErr: false,
+		},
+		{
+			name:    "argon2id",
+			conf:    config.PasswordHashing{Algorithm: "argon2id", Argon2MemoryKiB: 1024, Argon2Time: 1},
+			wantErr: false,
+		},
+		{
+			name:    "unsupported_algorithm",
+			conf:    config.PasswordHashing{Algorithm: "md5"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid_bcrypt_cost",
+			conf:    config.PasswordHashing{BcryptCost: 40},
+			wantErr: true,
+		},
+		{
+			name:    "invalid_argon2_memory",
+			conf:    config.PasswordHashing{Algorithm: "argon2id", Argon2MemoryKiB: 4},
+			wantErr: true,
+		},
+		{
+			name:    "invalid_argon2_parallelism",
+			conf:    config.PasswordHashing{Algorithm: "argon2id", Argon2Parallelism: 256},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyPasswordSettings(&config.Configuration{PasswordHashing: tt.conf})
+
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+
+	_ = ApplyPasswordSettings(&config.Configuration{})
+}
+
+func TestComparePassword(t *testing.T) {
+	tests := []struct {
+		name   string
+		conf   config.PasswordHashing
+		prefix string
+	}{
+		{
+			name:   "bcrypt",
+			conf:   config.PasswordHashing{Algorithm: "bcrypt", BcryptCost: bcrypt.MinCost},
+			prefix: "$2a$04$",
+		},
+		{
+			name:   "argon2id",
+			conf:   config.PasswordHashing{Algorithm: "argon2id", Argon2MemoryKiB: 1024, Argon2Time: 1},
+			prefix: "$argon2id$v=19$m=1024,t=1,p=2$",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyPasswordSettings(&config.Configuration{PasswordHashing: tt.conf})
+
+			assert.Nil(t, err)
+
+			hash, err := GetHashFromPassword("password")
+
+			assert.Nil(t, err)
+			assert.True(t, strings.HasPrefix(string(hash), tt.prefix))
+			assert.Nil(t, ComparePassword(hash, []byte("password")))
+			assert.NotNil(t, ComparePassword(hash, []byte("wrong_password")))
+			assert.False(t, NeedsRehash(hash))
+		})
+	}
+
+	assert.NotNil(t, ComparePassword([]byte("$argon2id$invalid"), []byte("password")))
+	assert.NotNil(t, ComparePassword([]byte("invalid"), []byte("password")))
+
+	_ = ApplyPasswordSettings(&config.Configuration{})
+}
+
+func TestNeedsRehash(t *testing.T) {
+	_ = ApplyPasswordSettings(&config.Configuration{
+		PasswordHashing: config.PasswordHashing{Algorithm: "bcrypt", BcryptCost: bcrypt.MinCost},
+	})
+
+	bcryptHash, _ := GetHashFromPassword("password")
+
+	_ = ApplyPasswordSettings(&config.Configuration{
+		PasswordHashing: config.PasswordHashing{Algorithm: "argon2id", Argon2MemoryKiB: 1024, Argon2Time: 1},
+	})
+
+	argon2Hash, _ := GetHashFromPassword("password")
+
+	// Outdated algorithm
+	assert.True(t, NeedsRehash(bcryptHash))
+	assert.False(t, NeedsRehash(argon2Hash))
+
+	// Outdated parameters
+	_ = ApplyPasswordSettings(&config.Configuration{
+		PasswordHashing: config.PasswordHashing{Algorithm: "argon2id", Argon2MemoryKiB: 2048, Argon2Time: 1},
+	})
+
+	assert.True(t, NeedsRehash(argon2Hash))
+
+	_ = ApplyPasswordSettings(&config.Configuration{
+		PasswordHashing: config.PasswordHashing{Algorithm: "bcrypt", BcryptCost: bcrypt.MinCost + 1},
+	})
+
+	assert.True(t, NeedsRehash(bcryptHash))
+	assert.True(t, NeedsRehash(argon2Hash))
+
+	// Unknown formats cannot be verified, so they are never rehashed
+	assert.False(t, NeedsRehash([]byte("invalid")))
+
+	_ = ApplyPasswordSettings(&config.Configuration{})
+}
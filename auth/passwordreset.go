@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// Number of random bytes of a password reset token
+const passwordResetTokenSize = 32
+
+// GeneratePasswordResetToken creates a random password reset token and returns (token, hash, error). Only the hash
+// should be stored.
+func GeneratePasswordResetToken() (string, []byte, error) {
+	token, err := GenerateRandomB64String(passwordResetTokenSize)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, HashPasswordResetToken(token), nil
+}
+
+// HashPasswordResetToken returns the hash of a password reset token. The tokens are random and long enough to use a
+// fast hash.
+func HashPasswordResetToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+
+	return []byte(fmt.Sprintf("%x", sum))
+}
@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGeneratePasswordResetToken(t *testing.T) {
+	token, hash, err := GeneratePasswordResetToken()
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, token)
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, HashPasswordResetToken(token))
+
+	other, _, err := GeneratePasswordResetToken()
+
+	assert.Nil(t, err)
+	assert.NotEqual(t, token, other)
+	assert.NotEqual(t, hash, HashPasswordResetToken(other))
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"fmt"
+	"go-there/config"
+	"go-there/data"
+)
+
+// rolePermissions contains the permissions granted by each configured role.
+var rolePermissions = make(map[string]map[string]bool)
+
+// ApplyRoles parses the roles defined in the configuration, then apply them to the global variables. Returns a
+// data.ErrSettings if a role grants an unknown permission.
+func ApplyRoles(conf *config.Configuration) error {
+	rp := make(map[string]map[string]bool)
+
+	for name, role := range conf.Roles {
+		rp[name] = make(map[string]bool)
+
+		for _, p := range role.Permissions {
+			if !isValidPermission(p) {
+				return fmt.Errorf("%w : invalid permission %s in role %s", data.ErrSettings, p, name)
+			}
+
+			rp[name][p] = true
+		}
+	}
+
+	rolePermissions = rp
+
+	return nil
+}
+
+// IsRole returns true if the role is defined in the configuration.
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+
+	return ok
+}
+
+// HasPermission returns true if the user is admin, or if one of his roles grants the permission.
+func HasPermission(u data.User, permission string) bool {
+	if u.IsAdmin {
+		return true
+	}
+
+	for _, r := range u.RoleList() {
+		if rolePermissions[r][data.PermAll] || rolePermissions[r][permission] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanGrantRole returns true if the user has all the permissions granted by the role. It prevents a user from giving
+// more permissions than he has.
+func CanGrantRole(u data.User, role string) bool {
+	rp, ok := rolePermissions[role]
+
+	if !ok {
+		return false
+	}
+
+	for p := range rp {
+		if !HasPermission(u, p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CanManageUser returns true if the user can act on the account of the target. Admins can manage any user, and users
+// can manage themselves. Otherwise, the target must not be an admin and the user must be able to grant all his roles, so
+// that a role cannot be used to take over a more privileged account.
+func CanManageUser(u data.User, target data.User) bool {
+	if u.IsAdmin || u.Username == target.Username {
+		return true
+	}
+
+	if target.IsAdmin {
+		return false
+	}
+
+	for _, r := range target.RoleList() {
+		if !CanGrantRole(u, r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isValidPermission returns true if the permission exists.
+func isValidPermission(permission string) bool {
+	for _, p := range data.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"go-there/data"
+	"testing"
+)
+
+func TestApplyRoles(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   map[string]config.Role
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			roles: map[string]config.Role{
+				"helpdesk":   {Permissions: []string{data.PermUsersRead, data.PermUsersUpdate}},
+				"superadmin": {Permissions: []string{data.PermAll}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid_permission",
+			roles: map[string]config.Role{
+				"helpdesk": {Permissions: []string{"paths:destroy"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyRoles(&config.Configuration{Roles: tt.roles})
+
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	err := ApplyRoles(&config.Configuration{Roles: map[string]config.Role{
+		"helpdesk":   {Permissions: []string{data.PermUsersRead, data.PermUsersUpdate}},
+		"superadmin": {Permissions: []string{data.PermAll}},
+	}})
+
+	assert.Nil(t, err)
+
+	type args struct {
+		u          data.User
+		permission string
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			name: "granted",
+			args: args{u: data.User{Roles: "helpdesk"}, permission: data.PermUsersUpdate},
+			want: true,
+		},
+		{
+			name: "not_granted",
+			args: args{u: data.User{Roles: "helpdesk"}, permission: data.PermUsersDelete},
+			want: false,
+		},
+		{
+			name: "all",
+			args: args{u: data.User{Roles: "helpdesk,superadmin"}, permission: data.PermUsersDelete},
+			want: true,
+		},
+		{
+			name: "admin",
+			args: args{u: data.User{IsAdmin: true}, permission: data.PermUsersDelete},
+			want: true,
+		},
+		{
+			name: "unknown_role",
+			args: args{u: data.User{Roles: "unknown"}, permission: data.PermUsersRead},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, HasPermission(tt.args.u, tt.args.permission))
+		})
+	}
+}
+
+func TestCanGrantRole(t *testing.T) {
+	err := ApplyRoles(&config.Configuration{Roles: map[string]config.Role{
+		"viewer":     {Permissions: []string{data.PermUsersRead}},
+		"helpdesk":   {Permissions: []string{data.PermUsersRead, data.PermUsersUpdate, data.PermUsersRoles}},
+		"superadmin": {Permissions: []string{data.PermAll}},
+	}})
+
+	assert.Nil(t, err)
+
+	helpdesk := data.User{Roles: "helpdesk"}
+
+	assert.True(t, CanGrantRole(helpdesk, "viewer"))
+	assert.True(t, CanGrantRole(helpdesk, "helpdesk"))
+	assert.False(t, CanGrantRole(helpdesk, "superadmin"))
+	assert.False(t, CanGrantRole(helpdesk, "unknown"))
+	assert.True(t, CanGrantRole(data.User{IsAdmin: true}, "superadmin"))
+}
+
+func TestCanManageUser(t *testing.T) {
+	err := ApplyRoles(&config.Configuration{Roles: map[string]config.Role{
+		"viewer":     {Permissions: []string{data.PermUsersRead}},
+		"helpdesk":   {Permissions: []string{data.PermUsersRead, data.PermUsersUpdate}},
+		"superadmin": {Permissions: []string{data.PermAll}},
+	}})
+
+	assert.Nil(t, err)
+
+	helpdesk := data.User{Username: "carol", Roles: "helpdesk"}
+
+	assert.True(t, CanManageUser(helpdesk, data.User{Username: "alice"}))
+	assert.True(t, CanManageUser(helpdesk, data.User{Username: "alice", Roles: "viewer"}))
+	assert.True(t, CanManageUser(helpdesk, helpdesk))
+	assert.False(t, CanManageUser(helpdesk, data.User{Username: "root", IsAdmin: true}))
+	assert.False(t, CanManageUser(helpdesk, data.User{Username: "bob", Roles: "superadmin"}))
+	assert.True(t, CanManageUser(data.User{Username: "root", IsAdmin: true}, data.User{Username: "admin", IsAdmin: true}))
+}
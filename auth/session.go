@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/jwt"
+	"go-there/config"
+	"go-there/data"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Session cookies and CSRF header names. The CSRF cookie is readable by scripts so it can be sent back in the CSRF
+// header.
+const (
+	SessionCookieName = "gt_session"
+	CsrfCookieName    = "gt_csrf"
+	CsrfHeaderName    = "X-Csrf-Token"
+)
+
+const defaultSessionTtlSec = 12 * 60 * 60
+
+// sessionSettings contains the parameters of the session cookies.
+type sessionSettings struct {
+	ttl      time.Duration
+	sameSite http.SameSite
+	domain   string
+}
+
+// session contains the current session settings.
+var session = sessionSettings{
+	ttl:      defaultSessionTtlSec * time.Second,
+	sameSite: http.SameSiteStrictMode,
+}
+
+// ApplySessionSettings parses the session settings, then apply them to the global variables. If a setting has a zero
+// value, the default is used. Returns a data.ErrSettings if a setting is invalid.
+func ApplySessionSettings(conf *config.Configuration) error {
+	s := sessionSettings{
+		ttl:      defaultSessionTtlSec * time.Second,
+		sameSite: http.SameSiteStrictMode,
+		domain:   conf.Session.Domain,
+	}
+
+	if conf.Session.TtlSec < 0 {
+		return fmt.Errorf("%w : invalid session TTL %d", data.ErrSettings, conf.Session.TtlSec)
+	}
+
+	if conf.Session.TtlSec > 0 {
+		s.ttl = time.Duration(conf.Session.TtlSec) * time.Second
+	}
+
+	switch strings.ToLower(conf.Session.SameSite) {
+	case "", "strict":
+	case "lax":
+		s.sameSite = http.SameSiteLaxMode
+	default:
+		return fmt.Errorf("%w : invalid session SameSite %s, must be Strict or Lax", data.ErrSettings,
+			conf.Session.SameSite)
+	}
+
+	session = s
+
+	return nil
+}
+
+// CreateSession creates a session for the user and sets the session and CSRF cookies. The session is a token signed
+// with the JWT signing key, containing the CSRF token so both cookies cannot be mixed between sessions. It returns
+// (CSRF token, error).
+func CreateSession(c *gin.Context, u data.User) (string, error) {
+	csrfToken, err := GenerateRandomB64String(32)
+
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(session.ttl)
+
+	t := jwt.New()
+	if err := t.Set(jwt.ExpirationKey, expiresAt.Unix()); err != nil {
+		return "", fmt.Errorf("error creating a session: %w", err)
+	}
+	if err := t.Set("username", u.Username); err != nil {
+		return "", fmt.Errorf("error creating a session: %w", err)
+	}
+	if err := t.Set("is_admin", u.IsAdmin); err != nil {
+		return "", fmt.Errorf("error creating a session: %w", err)
+	}
+	if err := t.Set("csrf", csrfToken); err != nil {
+		return "", fmt.Errorf("error creating a session: %w", err)
+	}
+
+	token, err := jwt.Sign(t, JwtSigningAlgorithm, JwtSigningKey)
+
+	if err != nil {
+		return "", fmt.Errorf("error signing a session: %w", err)
+	}
+
+	setSessionCookie(c, SessionCookieName, string(token), expiresAt, true)
+	setSessionCookie(c, CsrfCookieName, csrfToken, expiresAt, false)
+
+	return csrfToken, nil
+}
+
+// ClearSession expires the session and CSRF cookies. The session token itself stays valid until it expires.
+func ClearSession(c *gin.Context) {
+	setSessionCookie(c, SessionCookieName, "", time.Unix(0, 0), true)
+	setSessionCookie(c, CsrfCookieName, "", time.Unix(0, 0), false)
+}
+
+// setSessionCookie sets a Secure cookie with the configured SameSite mode and domain.
+func setSessionCookie(c *gin.Context, name string, value string, expiresAt time.Time, httpOnly bool) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   session.domain,
+		Expires:  expiresAt,
+		Secure:   true,
+		HttpOnly: httpOnly,
+		SameSite: session.sameSite,
+	})
+}
+
+// sessionToLogin takes a session token and returns (data.JwtLogin, CSRF token, error) if the token is valid or a
+// data.ErrInvalidAuth otherwise.
+func sessionToLogin(token string) (data.JwtLogin, string, error) {
+	jl, err := jwtToLogin(token)
+
+	if err != nil {
+		return data.JwtLogin{}, "", fmt.Errorf("%w : %s", data.ErrInvalidAuth, err)
+	}
+
+	// Only the session tokens contain a CSRF token
+	t, err := jwt.Parse([]byte(token))
+
+	if err != nil {
+		return data.JwtLogin{}, "", fmt.Errorf("%w : %s", data.ErrInvalidAuth, err)
+	}
+
+	v, ok := t.Get("csrf")
+	if !ok {
+		return data.JwtLogin{}, "", data.ErrInvalidAuth
+	}
+	csrfToken, ok := v.(string)
+	if !ok || csrfToken == "" {
+		return data.JwtLogin{}, "", data.ErrInvalidAuth
+	}
+
+	return jl, csrfToken, nil
+}
+
+// validCsrf returns true if the request method cannot change the state, or if the CSRF header matches both the CSRF
+// cookie and the CSRF token of the session.
+func validCsrf(c *gin.Context, csrfToken string) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	header := c.GetHeader(CsrfHeaderName)
+	cookie, err := c.Cookie(CsrfCookieName)
+
+	if err != nil || header == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(header), []byte(csrfToken)) == 1
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"go-there/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApplySessionSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    config.Session
+		wantErr bool
+	}{
+		{
+			name:    "default",
+			conf:    config.Session{},
+			wantErr: false,
+		},
+		{
+			name:    "lax",
+			conf:    config.Session{TtlSec: 3600, SameSite: "Lax", Domain: "example.com"},
+			wantErr: false,
+		},
+		{
+			name:    "invalid_same_site",
+			conf:    config.Session{SameSite: "None"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid_ttl",
+			conf:    config.Session{TtlSec: -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplySessionSettings(&config.Configuration{Session: tt.conf})
+
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+
+	_ = ApplySessionSettings(&config.Configuration{})
+}
+
+func TestGetAuthMiddlewareSession(t *testing.T) {
+	key, err := generateJwtSigningKey(jwa.ES256)
+
+	assert.Nil(t, err)
+
+	JwtSigningKey = key
+	JwtSigningAlgorithm = jwa.ES256
+
+	// Create a session
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	csrfToken, err := CreateSession(c, data.User{Username: "alice"})
+
+	assert.Nil(t, err)
+
+	cookies := make(map[string]*http.Cookie)
+
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+
+	assert.True(t, cookies[SessionCookieName].HttpOnly)
+	assert.True(t, cookies[SessionCookieName].Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookies[SessionCookieName].SameSite)
+	assert.False(t, cookies[CsrfCookieName].HttpOnly)
+	assert.Equal(t, csrfToken, cookies[CsrfCookieName].Value)
+
+	// A bearer token does not contain a CSRF token and cannot be used as a session
+	token := jwt.New()
+	_ = token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour).Unix())
+	_ = token.Set("username", "alice")
+	_ = token.Set("is_admin", false)
+	bearer, _ := jwt.Sign(token, jwa.ES256, key)
+
+	type args struct {
+		method    string
+		session   string
+		csrf      string
+		csrfToken string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want int
+	}{
+		{
+			name: "ok_get",
+			args: args{method: "GET", session: cookies[SessionCookieName].Value},
+			want: http.StatusOK,
+		},
+		{
+			name: "ok_post",
+			args: args{
+				method:    "POST",
+				session:   cookies[SessionCookieName].Value,
+				csrf:      csrfToken,
+				csrfToken: csrfToken,
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "missing_csrf",
+			args: args{method: "POST", session: cookies[SessionCookieName].Value},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "missing_csrf_cookie",
+			args: args{method: "POST", session: cookies[SessionCookieName].Value, csrfToken: csrfToken},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "other_session_csrf",
+			args: args{
+				method:    "POST",
+				session:   cookies[SessionCookieName].Value,
+				csrf:      "other_csrf_token",
+				csrfToken: "other_csrf_token",
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "bearer_token",
+			args: args{method: "GET", session: string(bearer)},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "invalid_session",
+			args: args{method: "GET", session: "invalid"},
+			want: http.StatusUnauthorized,
+		},
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	e.Use(GetAuthMiddleware(mockDataSourcer{}, "", ""))
+
+	e.GET("/ping", func(c *gin.Context) {
+		assert.Equal(t, data.SessionAuth, GetAuthType(c))
+		c.Status(http.StatusOK)
+	})
+
+	e.POST("/ping", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.args.method, "/ping", nil)
+			req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: tt.args.session})
+
+			if tt.args.csrf != "" {
+				req.AddCookie(&http.Cookie{Name: CsrfCookieName, Value: tt.args.csrf})
+			}
+
+			if tt.args.csrfToken != "" {
+				req.Header.Set(CsrfHeaderName, tt.args.csrfToken)
+			}
+
+			w := httptest.NewRecorder()
+
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
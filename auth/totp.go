@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"go-there/config"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, following RFC 6238 defaults supported by most authenticator applications
+const (
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSkewSteps  = 1
+	totpSecretSize = 20
+)
+
+// recoveryCodeSize is the number of random bytes of a recovery code.
+const recoveryCodeSize = 10
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// requireAdminTotp is true if admins must use a second factor. It is disabled by default.
+var requireAdminTotp = false
+
+// ApplyTwoFactorSettings applies the two-factor settings to the global variables.
+func ApplyTwoFactorSettings(conf *config.Configuration) {
+	requireAdminTotp = conf.TwoFactor.RequireForAdmins
+}
+
+// GenerateTotpSecret creates a random base32 encoded TOTP secret.
+func GenerateTotpSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// GetTotpProvisioningUri returns the otpauth:// URI used by authenticator applications to enroll a secret.
+func GetTotpProvisioningUri(issuer string, username string, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + username,
+		RawQuery: v.Encode(),
+	}
+
+	return u.String()
+}
+
+// ValidateTotp checks a TOTP code against a secret at the provided time, allowing one step of clock skew. It returns
+// (time step matched, true) if the code is valid. The time step should be stored to prevent the code from being reused.
+func ValidateTotp(secret string, code string, t time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	step := t.Unix() / totpPeriod
+
+	for i := int64(-totpSkewSteps); i <= totpSkewSteps; i++ {
+		if hmac.Equal([]byte(totpCode(key, step+i)), []byte(code)) {
+			return step + i, true
+		}
+	}
+
+	return 0, false
+}
+
+// totpCode computes the TOTP code of a key for a time step.
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// GenerateRecoveryCodes creates n random recovery codes and returns (codes, hashes, error). Only the hashes should be
+// stored.
+func GenerateRecoveryCodes(n int) ([]string, [][]byte, error) {
+	codes := make([]string, n)
+	hashes := make([][]byte, n)
+
+	for i := range codes {
+		b := make([]byte, recoveryCodeSize)
+
+		_, err := rand.Read(b)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes[i] = strings.ToLower(totpEncoding.EncodeToString(b))
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hash of a recovery code. The codes are random and long enough to use a fast hash.
+func HashRecoveryCode(code string) []byte {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+
+	return []byte(fmt.Sprintf("%x", sum))
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTotp(t *testing.T) {
+	// RFC 6238 SHA1 test secret "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	type args struct {
+		code string
+		t    time.Time
+	}
+	tests := []struct {
+		name  string
+		args  args
+		want  int64
+		want1 bool
+	}{
+		{
+			name:  "ok_59",
+			args:  args{code: "287082", t: time.Unix(59, 0)},
+			want:  1,
+			want1: true,
+		},
+		{
+			name:  "ok_1111111109",
+			args:  args{code: "081804", t: time.Unix(1111111109, 0)},
+			want:  37037036,
+			want1: true,
+		},
+		{
+			name:  "ok_skew",
+			args:  args{code: "081804", t: time.Unix(1111111109+totpPeriod, 0)},
+			want:  37037036,
+			want1: true,
+		},
+		{
+			name:  "too_old",
+			args:  args{code: "081804", t: time.Unix(1111111109+3*totpPeriod, 0)},
+			want:  0,
+			want1: false,
+		},
+		{
+			name:  "bad_length",
+			args:  args{code: "0818", t: time.Unix(1111111109, 0)},
+			want:  0,
+			want1: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1 := ValidateTotp(secret, tt.args.code, tt.args.t)
+
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want1, got1)
+		})
+	}
+}
+
+func TestGetTotpProvisioningUri(t *testing.T) {
+	secret, err := GenerateTotpSecret()
+
+	assert.Nil(t, err)
+
+	uri := GetTotpProvisioningUri("go-there", "alice", secret)
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/go-there:alice?"))
+	assert.Contains(t, uri, "secret="+secret)
+	assert.Contains(t, uri, "issuer=go-there")
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(10)
+
+	assert.Nil(t, err)
+	assert.Len(t, codes, 10)
+	assert.Len(t, hashes, 10)
+
+	for i := range codes {
+		assert.Equal(t, hashes[i], HashRecoveryCode(" "+strings.ToUpper(codes[i])+" "))
+	}
+}
@@ -0,0 +1,114 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"go-there/api"
+	"go-there/auth"
+	"go-there/config"
+	"go-there/data"
+)
+
+// DataSourcer represents the database.DataBase methods needed to create the first admin.
+type DataSourcer interface {
+	SelectUserLogin(ctx context.Context, username string) (data.User, error)
+	InsertUser(ctx context.Context, user data.User) error
+}
+
+// Init creates the admin configured in the BootstrapAdmin section if it is set and the user doesn't exist yet. It never
+// modifies an existing user, so it can safely run at each startup. Returns a data.ErrSettings if the configuration is
+// invalid or a data.ErrSql if it fails.
+func Init(conf *config.Configuration, ds DataSourcer) error {
+	ba := conf.BootstrapAdmin
+
+	if ba.Username == "" {
+		return nil
+	}
+
+	created, err := CreateAdmin(context.Background(), ds, ba.Username, ba.Password, ba.ApiKey)
+
+	if err != nil {
+		return err
+	}
+
+	if created {
+		log.Info().Str("user", ba.Username).Msg("bootstrap admin created")
+	} else {
+		log.Info().Str("user", ba.Username).Msg("bootstrap admin already exists, skipping")
+	}
+
+	return nil
+}
+
+// CreateAdmin creates an admin user with a password, an API key in the current format, or both. A random API key is
+// generated if none is provided, and can be regenerated later. Returns false without modifying anything if the user
+// already exists, and true if it was created. The password must follow the user rules and the password policy, including
+// the breached password list. Returns a data.ErrSettings if no credential, an invalid API key or an invalid password is
+// provided, or a data.ErrSql if it fails.
+func CreateAdmin(ctx context.Context, ds DataSourcer, username string, password string, apiKey string) (bool, error) {
+	if password == "" && apiKey == "" {
+		return false, fmt.Errorf("%w : the bootstrap admin needs a password or an API key", data.ErrSettings)
+	}
+
+	u := data.User{
+		Username: username,
+		IsAdmin:  true,
+	}
+
+	var err error
+
+	// Validated even if the user exists, so that an invalid configuration is always reported
+	if apiKey != "" {
+		u.ApiKeyId, u.ApiKeyHash, err = auth.HashApiKey(apiKey)
+
+		if err != nil {
+			return false, fmt.Errorf("%w : invalid bootstrap admin API key : %s", data.ErrSettings, err)
+		}
+	}
+
+	existing, err := ds.SelectUserLogin(ctx, username)
+
+	if err != nil && !errors.Is(err, data.ErrSqlNoRow) {
+		return false, err
+	}
+
+	if existing.Username != "" {
+		return false, nil
+	}
+
+	if password != "" {
+		// Only checked at creation, so that a stricter policy doesn't prevent starting once the admin exists
+		if msg := api.CheckPassword(username, password); msg != "" {
+			return false, fmt.Errorf("%w : invalid bootstrap admin password : %s", data.ErrSettings, msg)
+		}
+
+		u.PasswordHash, err = auth.GetHashFromPassword(password)
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if apiKey == "" {
+		_, u.ApiKeyId, u.ApiKeyHash, err = auth.GenerateApiKey()
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = ds.InsertUser(ctx, u)
+
+	// Another instance created the user in the meantime
+	if errors.Is(err, data.ErrSqlDuplicateRow) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,187 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go-there/auth"
+	"go-there/config"
+	"go-there/data"
+	"testing"
+)
+
+type mockDataSourcer struct {
+	users map[string]data.User
+	err   error
+}
+
+func (m *mockDataSourcer) SelectUserLogin(ctx context.Context, username string) (data.User, error) {
+	if m.err != nil {
+		return data.User{}, m.err
+	}
+
+	u, ok := m.users[username]
+
+	if !ok {
+		return data.User{}, data.ErrSqlNoRow
+	}
+
+	return u, nil
+}
+
+func (m *mockDataSourcer) InsertUser(ctx context.Context, user data.User) error {
+	if _, ok := m.users[user.Username]; ok {
+		return data.ErrSqlDuplicateRow
+	}
+
+	m.users[user.Username] = user
+
+	return nil
+}
+
+func TestCreateAdmin(t *testing.T) {
+	apiKey, keyId, keyHash, err := auth.GenerateApiKey()
+
+	assert.Nil(t, err)
+
+	type args struct {
+		username string
+		password string
+		apiKey   string
+	}
+
+	tests := []struct {
+		name        string
+		args        args
+		dbErr       error
+		want        bool
+		wantErr     error
+		wantKeyId   string
+		wantKeyHash []byte
+	}{
+		{
+			name: "ok_password",
+			args: args{
+				username: "admin",
+				password: "superpassword",
+			},
+			want: true,
+		},
+		{
+			name: "ok_api_key",
+			args: args{
+				username: "admin",
+				apiKey:   apiKey,
+			},
+			want:        true,
+			wantKeyId:   keyId,
+			wantKeyHash: keyHash,
+		},
+		{
+			name: "ok_existing_user",
+			args: args{
+				username: "alice",
+				password: "superpassword",
+			},
+			want: false,
+		},
+		{
+			name: "invalid_password",
+			args: args{
+				username: "admin",
+				password: "short",
+			},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name: "ok_existing_user_invalid_password",
+			args: args{
+				username: "alice",
+				password: "short",
+			},
+			want: false,
+		},
+		{
+			name: "no_credentials",
+			args: args{
+				username: "admin",
+			},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name: "invalid_api_key",
+			args: args{
+				username: "alice",
+				apiKey:   "gt_0123456789abcdef_short",
+			},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name: "db_err",
+			args: args{
+				username: "admin",
+				password: "superpassword",
+			},
+			dbErr:   data.ErrSql,
+			wantErr: data.ErrSql,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice := data.User{Username: "alice", PasswordHash: []byte("hash")}
+			ds := &mockDataSourcer{users: map[string]data.User{"alice": alice}, err: tt.dbErr}
+
+			got, err := CreateAdmin(context.Background(), ds, tt.args.username, tt.args.password, tt.args.apiKey)
+
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr))
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+
+			// Existing users are never modified
+			assert.Equal(t, alice, ds.users["alice"])
+
+			if !got {
+				return
+			}
+
+			u := ds.users[tt.args.username]
+
+			assert.True(t, u.IsAdmin)
+			assert.NotEmpty(t, u.ApiKeyId)
+
+			if tt.args.password != "" {
+				assert.Nil(t, auth.ComparePassword(u.PasswordHash, []byte(tt.args.password)))
+			} else {
+				assert.Empty(t, u.PasswordHash)
+			}
+
+			if tt.wantKeyId != "" {
+				assert.Equal(t, tt.wantKeyId, u.ApiKeyId)
+				assert.Equal(t, tt.wantKeyHash, u.ApiKeyHash)
+			}
+		})
+	}
+}
+
+func TestInit(t *testing.T) {
+	ds := &mockDataSourcer{users: map[string]data.User{}}
+	conf := &config.Configuration{BootstrapAdmin: config.BootstrapAdmin{Username: "admin", Password: "superpassword"}}
+
+	// Idempotent
+	assert.Nil(t, Init(conf, ds))
+	first := ds.users["admin"]
+
+	assert.Nil(t, Init(conf, ds))
+	assert.Equal(t, first, ds.users["admin"])
+
+	// Disabled without a username
+	ds = &mockDataSourcer{users: map[string]data.User{}}
+
+	assert.Nil(t, Init(&config.Configuration{}, ds))
+	assert.Empty(t, ds.users)
+}
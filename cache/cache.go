@@ -2,7 +2,6 @@ package cache
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"github.com/rs/zerolog/log"
 	"go-there/config"
@@ -14,136 +13,141 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
-type Cache struct {
-	rc *rediscache.Cache
+// Cache represents a cache of the redirection targets. A target is fresh for a while, then it is stale and should be
+// revalidated, although it can still be served. The paths which don't exist can also be cached for a short time, in
+// which case GetTarget returns a data.ErrSqlNoRow. Implementations must be safe for concurrent use.
+type Cache interface {
+	GetTarget(ctx context.Context, path string) (target string, fresh bool, err error)
+	AddTarget(ctx context.Context, path data.Path) error
+	AddMissingTarget(ctx context.Context, path string) error
+	DeleteTargets(ctx context.Context, paths []string) error
 }
 
-// Init initializes the Redis cache from the configuration. Returns nil if no cache is enabled.
-func Init(config *config.Configuration) *Cache {
-	if config.Cache.Enabled && config.Cache.LocalCacheEnabled {
-		return nil
-	}
+// Ttls defines how long the entries are kept in the cache. A target is fresh for Target, then stale for Stale. A path
+// which doesn't exist is kept for Missing.
+type Ttls struct {
+	Target  time.Duration
+	Stale   time.Duration
+	Missing time.Duration
+}
 
-	// Configure local cache
-	var localCache rediscache.LocalCache
-
-	if config.Cache.LocalCacheEnabled {
-		if config.Cache.LocalCacheSize <= 0 || config.Cache.LocalCacheTtlSec <= 0 {
-			log.Error().Msg("local cache enabled, but not configured")
-		} else {
-			localCache = rediscache.NewTinyLFU(
-				config.Cache.LocalCacheSize,
-				time.Second*time.Duration(config.Cache.LocalCacheTtlSec),
-			)
-		}
-	}
+// Default ttls, the missing paths are kept for a short time as they are not always invalidated on every instance
+const (
+	defaultTargetTtl  = time.Hour
+	defaultMissingTtl = 30 * time.Second
+)
 
-	// Configure network cache
-	client := new(redis.Client)
-
-	if config.Cache.Enabled {
-		// Never retries if it cannot connect to the instance. It will still tries to connect for each request, but it
-		// prevents the total request time to be super long (because of multiple retries) if if fails.
-		client = redis.NewClient(&redis.Options{
-			Network:    "",
-			Addr:       config.Cache.Address + ":" + strconv.Itoa(config.Cache.Port),
-			Username:   config.Cache.User,
-			Password:   config.Cache.Password,
-			MaxRetries: -1,
-		})
-
-		_, err := client.Ping(context.Background()).Result()
-
-		if err != nil {
-			log.Error().Err(fmt.Errorf("%w: %s", data.ErrRedis, err)).
-				Msg("cannot ping the configured redis instance, using local cache only")
-		}
-	}
+// Maximum duration of a Redis operation, if not configured
+const defaultTimeout = time.Second
 
-	cache := new(Cache)
+// entry is the value stored for a path. The target is empty if the path doesn't exist.
+type entry struct {
+	Target     string    `msgpack:"t"`
+	FreshUntil time.Time `msgpack:"f"`
+}
 
-	cache.rc = rediscache.New(&rediscache.Options{
-		Redis:      client,
-		LocalCache: localCache,
-	})
+// newEntry returns an entry for the target, fresh for ttl.
+func newEntry(target string, ttl time.Duration) entry {
+	return entry{
+		Target:     target,
+		FreshUntil: time.Now().Add(ttl),
+	}
+}
 
-	return cache
+// isFresh returns true if the target of the entry doesn't need to be revalidated yet.
+func (e entry) isFresh() bool {
+	return time.Now().Before(e.FreshUntil)
 }
 
-// GetTarget gets a target in the cache from a path. Returns a data.ErrRedis if it fails. Returns "", nil if no cache
-// exists.
-func (cache *Cache) GetTarget(path string) (string, error) {
-	if cache == nil {
-		return "", nil
+// Init creates the cache matching the configuration:
+//
+//	Enabled  LocalCacheEnabled  Cache
+//	false    false              none, nil is returned
+//	false    true               LocalCache
+//	true     false              RedisCache
+//	true     true               TwoTierCache
+//
+// Returns a data.ErrSettings if the configuration is invalid. An unreachable Redis instance is only logged, as each
+// request tries to reach it again. Each Redis operation is canceled when its context is, or after the configured timeout.
+func Init(config *config.Configuration) (Cache, error) {
+	conf := config.Cache
+
+	if !conf.Enabled && !conf.LocalCacheEnabled {
+		return nil, nil
 	}
 
-	var target string
-	err := cache.rc.Get(context.Background(), path, &target)
-
-	if err != nil {
-		if !errors.Is(err, rediscache.ErrCacheMiss) {
-			return "", fmt.Errorf("%w: %s", data.ErrRedis, err)
-		}
+	if conf.TtlSec < 0 || conf.MissingTtlSec < 0 || conf.StaleTtlSec < 0 {
+		return nil, fmt.Errorf("%w : %s", data.ErrSettings, "invalid cache ttl")
 	}
 
-	return target, nil
-}
+	if conf.TimeoutMs < 0 {
+		return nil, fmt.Errorf("%w : %s", data.ErrSettings, "invalid cache timeout")
+	}
 
-// AddTarget adds a target to the cache with a ttl of 1 hour. Returns a data.ErrRedis if it fails. Returns
-// nil if no cache exists.
-func (cache *Cache) AddTarget(path data.Path) error {
-	if cache == nil {
-		return nil
+	ttls := Ttls{
+		Target:  defaultTargetTtl,
+		Stale:   time.Second * time.Duration(conf.StaleTtlSec),
+		Missing: defaultMissingTtl,
 	}
 
-	err := cache.rc.Set(&rediscache.Item{
-		Ctx:   context.Background(),
-		Key:   path.Path,
-		Value: path.Target,
-		TTL:   time.Hour,
-	})
+	if conf.TtlSec > 0 {
+		ttls.Target = time.Second * time.Duration(conf.TtlSec)
+	}
 
-	if err != nil {
-		return fmt.Errorf("%w: %s", data.ErrRedis, err)
+	if conf.MissingTtlSec > 0 {
+		ttls.Missing = time.Second * time.Duration(conf.MissingTtlSec)
 	}
 
-	return nil
-}
+	var local rediscache.LocalCache
 
-// DeleteTargets deletes all targets corresponding to the paths array provided. Returns a data.ErrRedis if it fails.
-// Returns nil if no cache exists.
-func (cache *Cache) DeleteTargets(paths []string) error {
-	if cache == nil {
-		return nil
-	}
+	if conf.LocalCacheEnabled {
+		if conf.LocalCacheSize <= 0 || conf.LocalCacheTtlSec <= 0 {
+			return nil, fmt.Errorf("%w : %s", data.ErrSettings, "local cache enabled, but not configured")
+		}
 
-	var err error
+		localTtl := time.Second * time.Duration(conf.LocalCacheTtlSec)
 
-	for _, p := range paths {
-		if cacheErr := cache.rc.Delete(context.Background(), p); cacheErr != nil {
-			err = cacheErr
+		if !conf.Enabled {
+			// The local cache is the only copy, so it keeps the stale targets too
+			ttls.Target = localTtl
+
+			return NewLocalCache(
+				rediscache.NewTinyLFU(conf.LocalCacheSize, ttls.Target+ttls.Stale),
+				rediscache.NewTinyLFU(conf.LocalCacheSize, ttls.Missing),
+				ttls,
+			), nil
 		}
-	}
 
-	if err != nil {
-		return fmt.Errorf("%w: %s", data.ErrRedis, err)
+		local = rediscache.NewTinyLFU(conf.LocalCacheSize, localTtl)
 	}
 
-	return nil
-}
+	// Never retries if it cannot connect to the instance. It will still tries to connect for each request, but it
+	// prevents the total request time to be super long (because of multiple retries) if if fails.
+	client := redis.NewClient(&redis.Options{
+		Addr:       conf.Address + ":" + strconv.Itoa(conf.Port),
+		Username:   conf.User,
+		Password:   conf.Password,
+		MaxRetries: -1,
+	})
+
+	timeout := defaultTimeout
 
-// DeleteAuthToken deletes the auth token provided. Returns a data.ErrRedis if it fails.
-// Returns nil if no cache exists.
-func (cache *Cache) DeleteAuthToken(authToken string) error {
-	if cache == nil {
-		return nil
+	if conf.TimeoutMs > 0 {
+		timeout = time.Millisecond * time.Duration(conf.TimeoutMs)
 	}
 
-	err := cache.rc.Delete(context.Background(), authToken)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := client.Ping(ctx).Result()
 
 	if err != nil {
-		return fmt.Errorf("%w: %s", data.ErrRedis, err)
+		log.Error().Err(fmt.Errorf("%w: %s", data.ErrRedis, err)).Msg("cannot ping the configured redis instance")
+	}
+
+	if local == nil {
+		return NewRedisCache(client, ttls, timeout), nil
 	}
 
-	return nil
+	return NewTwoTierCache(client, local, ttls, timeout), nil
 }
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"go-there/data"
+	"strconv"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	mr := miniredis.RunT(t)
+	port, _ := strconv.Atoi(mr.Port())
+
+	tests := []struct {
+		name     string
+		conf     config.Cache
+		wantType Cache
+		wantErr  error
+	}{
+		{
+			name:     "disabled",
+			conf:     config.Cache{},
+			wantType: nil,
+		},
+		{
+			name:     "local",
+			conf:     config.Cache{LocalCacheEnabled: true, LocalCacheSize: 10, LocalCacheTtlSec: 60},
+			wantType: &LocalCache{},
+		},
+		{
+			name:     "redis",
+			conf:     config.Cache{Enabled: true, Address: mr.Host(), Port: port},
+			wantType: &RedisCache{},
+		},
+		{
+			name: "two_tier",
+			conf: config.Cache{
+				Enabled:           true,
+				Address:           mr.Host(),
+				Port:              port,
+				LocalCacheEnabled: true,
+				LocalCacheSize:    10,
+				LocalCacheTtlSec:  60,
+			},
+			wantType: &TwoTierCache{},
+		},
+		{
+			name:    "invalid_ttl",
+			conf:    config.Cache{LocalCacheEnabled: true, LocalCacheSize: 10, LocalCacheTtlSec: 60, StaleTtlSec: -1},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name:    "invalid_timeout",
+			conf:    config.Cache{Enabled: true, TimeoutMs: -1},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name:    "local_not_configured",
+			conf:    config.Cache{LocalCacheEnabled: true},
+			wantErr: data.ErrSettings,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Init(&config.Configuration{Cache: tt.conf})
+
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr))
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.IsType(t, tt.wantType, got)
+		})
+	}
+}
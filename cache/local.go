@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"go-there/data"
+
+	rediscache "github.com/go-redis/cache/v8"
+)
+
+// LocalCache keeps the targets in memory only. Each instance has its own copy, so a change made by another instance is
+// only seen once the entry expires.
+type LocalCache struct {
+	rc      *rediscache.Cache
+	missing *rediscache.Cache
+	ttls    Ttls
+}
+
+// NewLocalCache returns a LocalCache storing the targets in lc, and the paths which don't exist in missing. They
+// define the size and lifetime of the entries, which should match ttls: lc keeps the stale targets, and the ttl of
+// missing should be short as the other instances cannot invalidate it.
+func NewLocalCache(lc rediscache.LocalCache, missing rediscache.LocalCache, ttls Ttls) *LocalCache {
+	return &LocalCache{
+		rc:      rediscache.New(&rediscache.Options{LocalCache: lc}),
+		missing: rediscache.New(&rediscache.Options{LocalCache: missing}),
+		ttls:    ttls,
+	}
+}
+
+// GetTarget gets a target in the cache from a path, and whether it is still fresh. Returns a data.ErrSqlNoRow if the
+// path is known not to exist, or "", false, nil on a miss.
+func (cache *LocalCache) GetTarget(ctx context.Context, path string) (string, bool, error) {
+	var e entry
+	err := cache.rc.Get(ctx, path, &e)
+
+	if err == nil {
+		return e.Target, e.isFresh(), nil
+	}
+
+	if !errors.Is(err, rediscache.ErrCacheMiss) {
+		return "", false, err
+	}
+
+	if cache.missing.Exists(ctx, path) {
+		return "", false, data.ErrSqlNoRow
+	}
+
+	return "", false, nil
+}
+
+// AddTarget adds a target to the cache.
+func (cache *LocalCache) AddTarget(ctx context.Context, path data.Path) error {
+	cache.missing.DeleteFromLocalCache(path.Path)
+
+	return cache.rc.Set(&rediscache.Item{
+		Ctx:   ctx,
+		Key:   path.Path,
+		Value: newEntry(path.Target, cache.ttls.Target),
+	})
+}
+
+// AddMissingTarget caches that the path doesn't exist, replacing its target, which may be stale.
+func (cache *LocalCache) AddMissingTarget(ctx context.Context, path string) error {
+	cache.rc.DeleteFromLocalCache(path)
+
+	return cache.missing.Set(&rediscache.Item{
+		Ctx:   ctx,
+		Key:   path,
+		Value: entry{},
+	})
+}
+
+// DeleteTargets deletes all targets corresponding to the paths array provided, or the fact that they don't exist. It
+// never fails.
+func (cache *LocalCache) DeleteTargets(_ context.Context, paths []string) error {
+	for _, p := range paths {
+		cache.rc.DeleteFromLocalCache(p)
+		cache.missing.DeleteFromLocalCache(p)
+	}
+
+	return nil
+}
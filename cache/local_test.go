@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go-there/data"
+	"testing"
+	"time"
+
+	rediscache "github.com/go-redis/cache/v8"
+)
+
+var testTtls = Ttls{
+	Target:  time.Minute,
+	Stale:   time.Minute,
+	Missing: 30 * time.Second,
+}
+
+func newTestLocalCache(ttls Ttls) *LocalCache {
+	return NewLocalCache(
+		rediscache.NewTinyLFU(10, ttls.Target+ttls.Stale),
+		rediscache.NewTinyLFU(10, ttls.Missing),
+		ttls,
+	)
+}
+
+func TestLocalCache(t *testing.T) {
+	c := newTestLocalCache(testTtls)
+
+	target, _, err := c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", target)
+
+	assert.NoError(t, c.AddTarget(context.Background(), data.Path{Path: "docs", Target: "https://example.com/docs"}))
+	assert.NoError(t, c.AddTarget(context.Background(), data.Path{Path: "wiki", Target: "https://example.com/wiki"}))
+
+	target, fresh, err := c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/docs", target)
+	assert.True(t, fresh)
+
+	assert.NoError(t, c.DeleteTargets(context.Background(), []string{"docs", "unknown"}))
+
+	target, _, err = c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", target)
+
+	target, _, err = c.GetTarget(context.Background(), "wiki")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/wiki", target)
+}
+
+func TestLocalCache_Stale(t *testing.T) {
+	c := newTestLocalCache(Ttls{Target: time.Nanosecond, Stale: time.Minute, Missing: time.Minute})
+
+	assert.NoError(t, c.AddTarget(context.Background(), data.Path{Path: "docs", Target: "https://example.com/docs"}))
+
+	time.Sleep(time.Millisecond)
+
+	target, fresh, err := c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/docs", target)
+	assert.False(t, fresh)
+}
+
+func TestLocalCache_MissingTargets(t *testing.T) {
+	c := newTestLocalCache(testTtls)
+
+	assert.NoError(t, c.AddMissingTarget(context.Background(), "docs"))
+
+	_, _, err := c.GetTarget(context.Background(), "docs")
+
+	assert.True(t, errors.Is(err, data.ErrSqlNoRow))
+
+	// Creating the path replaces the missing entry
+	assert.NoError(t, c.AddTarget(context.Background(), data.Path{Path: "docs", Target: "https://example.com/docs"}))
+
+	target, _, err := c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/docs", target)
+
+	// Deleting the path replaces the target
+	assert.NoError(t, c.AddMissingTarget(context.Background(), "docs"))
+
+	_, _, err = c.GetTarget(context.Background(), "docs")
+
+	assert.True(t, errors.Is(err, data.ErrSqlNoRow))
+
+	assert.NoError(t, c.AddMissingTarget(context.Background(), "wiki"))
+	assert.NoError(t, c.DeleteTargets(context.Background(), []string{"wiki"}))
+
+	target, _, err = c.GetTarget(context.Background(), "wiki")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", target)
+}
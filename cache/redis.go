@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-there/data"
+	"strconv"
+	"time"
+
+	rediscache "github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisCache keeps the targets in a Redis instance shared by all the instances. It also stores the data which must be
+// shared between instances, such as the authentication failures.
+type RedisCache struct {
+	client  *redis.Client
+	rc      *rediscache.Cache
+	ttls    Ttls
+	timeout time.Duration
+}
+
+// NewRedisCache returns a RedisCache using the Redis client, keeping the entries for ttls. Each operation is canceled
+// after timeout.
+func NewRedisCache(client *redis.Client, ttls Ttls, timeout time.Duration) *RedisCache {
+	return &RedisCache{
+		client: client,
+		rc: rediscache.New(&rediscache.Options{
+			Redis: client,
+		}),
+		ttls:    ttls,
+		timeout: timeout,
+	}
+}
+
+// withTimeout returns a context derived from ctx, canceled when the Redis operation takes too long. The returned
+// function must be called once the operation is done.
+func (cache *RedisCache) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, cache.timeout)
+}
+
+// GetTarget gets a target in the cache from a path, and whether it is still fresh. Returns a data.ErrSqlNoRow if the
+// path is known not to exist, or a data.ErrRedis if it fails. Returns "", false, nil on a miss.
+func (cache *RedisCache) GetTarget(ctx context.Context, path string) (string, bool, error) {
+	ctx, cancel := cache.withTimeout(ctx)
+	defer cancel()
+
+	var e entry
+	err := cache.rc.Get(ctx, path, &e)
+
+	if err != nil {
+		if !errors.Is(err, rediscache.ErrCacheMiss) {
+			return "", false, fmt.Errorf("%w: %s", data.ErrRedis, err)
+		}
+
+		return "", false, nil
+	}
+
+	if e.Target == "" {
+		return "", false, data.ErrSqlNoRow
+	}
+
+	return e.Target, e.isFresh(), nil
+}
+
+// AddTarget adds a target to the cache, kept while it is fresh or stale. Returns a data.ErrRedis if it fails.
+func (cache *RedisCache) AddTarget(ctx context.Context, path data.Path) error {
+	ctx, cancel := cache.withTimeout(ctx)
+	defer cancel()
+
+	err := cache.rc.Set(&rediscache.Item{
+		Ctx:   ctx,
+		Key:   path.Path,
+		Value: newEntry(path.Target, cache.ttls.Target),
+		TTL:   cache.ttls.Target + cache.ttls.Stale,
+	})
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", data.ErrRedis, err)
+	}
+
+	return nil
+}
+
+// AddMissingTarget caches that the path doesn't exist for a short time. Returns a data.ErrRedis if it fails.
+func (cache *RedisCache) AddMissingTarget(ctx context.Context, path string) error {
+	ctx, cancel := cache.withTimeout(ctx)
+	defer cancel()
+
+	err := cache.rc.Set(&rediscache.Item{
+		Ctx:   ctx,
+		Key:   path,
+		Value: entry{},
+		TTL:   cache.ttls.Missing,
+	})
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", data.ErrRedis, err)
+	}
+
+	return nil
+}
+
+// DeleteTargets deletes all targets corresponding to the paths array provided, or the fact that they don't exist.
+// Returns a data.ErrRedis if it fails.
+func (cache *RedisCache) DeleteTargets(ctx context.Context, paths []string) error {
+	ctx, cancel := cache.withTimeout(ctx)
+	defer cancel()
+
+	var err error
+
+	for _, p := range paths {
+		if cacheErr := cache.rc.Delete(ctx, p); cacheErr != nil &&
+			!errors.Is(cacheErr, rediscache.ErrCacheMiss) {
+			err = cacheErr
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", data.ErrRedis, err)
+	}
+
+	return nil
+}
+
+// DeleteAuthToken deletes the auth token provided. Returns a data.ErrRedis if it fails.
+func (cache *RedisCache) DeleteAuthToken(ctx context.Context, authToken string) error {
+	ctx, cancel := cache.withTimeout(ctx)
+	defer cancel()
+
+	err := cache.rc.Delete(ctx, authToken)
+
+	if err != nil && !errors.Is(err, rediscache.ErrCacheMiss) {
+		return fmt.Errorf("%w: %s", data.ErrRedis, err)
+	}
+
+	return nil
+}
+
+// GetLoginFailures gets the authentication failures stored for the key. They are never kept in the local cache, so that
+// every instance sees the same failures. Returns an empty data.LoginFailures if none exist, or a data.ErrRedis if it
+// fails.
+func (cache *RedisCache) GetLoginFailures(ctx context.Context, key string) (data.LoginFailures, error) {
+	ctx, cancel := cache.withTimeout(ctx)
+	defer cancel()
+
+	values, err := cache.client.HMGet(ctx, key, "failures", "last_failure").Result()
+
+	if err != nil {
+		return data.LoginFailures{}, fmt.Errorf("%w: %s", data.ErrRedis, err)
+	}
+
+	var f data.LoginFailures
+
+	if v, ok := values[0].(string); ok {
+		f.Failures, _ = strconv.Atoi(v)
+	}
+
+	if v, ok := values[1].(string); ok {
+		if ns, err := strconv.ParseInt(v, 10, 64); err == nil {
+			f.LastFailure = time.Unix(0, ns)
+		}
+	}
+
+	return f, nil
+}
+
+// AddLoginFailure increments the authentication failures stored for the key and records the time of the failure, in a
+// single transaction so that concurrent failures are all counted. They expire after ttl. Returns a data.ErrRedis if it
+// fails.
+func (cache *RedisCache) AddLoginFailure(ctx context.Context, key string, ttl time.Duration) error {
+	ctx, cancel := cache.withTimeout(ctx)
+	defer cancel()
+
+	_, err := cache.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, key, "failures", 1)
+		pipe.HSet(ctx, key, "last_failure", time.Now().UnixNano())
+		pipe.PExpire(ctx, key, ttl)
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", data.ErrRedis, err)
+	}
+
+	return nil
+}
+
+// DeleteLoginFailures deletes the authentication failures stored for the key. Returns a data.ErrRedis if it fails.
+func (cache *RedisCache) DeleteLoginFailures(ctx context.Context, key string) error {
+	ctx, cancel := cache.withTimeout(ctx)
+	defer cancel()
+
+	err := cache.rc.Delete(ctx, key)
+
+	if err != nil && !errors.Is(err, rediscache.ErrCacheMiss) {
+		return fmt.Errorf("%w: %s", data.ErrRedis, err)
+	}
+
+	return nil
+}
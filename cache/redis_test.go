@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"go-there/data"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRedisClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	return mr, client
+}
+
+func TestRedisCache_Targets(t *testing.T) {
+	mr, client := newTestRedisClient(t)
+	c := NewRedisCache(client, testTtls, time.Second)
+
+	target, _, err := c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", target)
+
+	assert.NoError(t, c.AddTarget(context.Background(), data.Path{Path: "docs", Target: "https://example.com/docs"}))
+	assert.True(t, mr.Exists("docs"))
+	assert.Equal(t, testTtls.Target+testTtls.Stale, mr.TTL("docs"))
+
+	target, fresh, err := c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/docs", target)
+	assert.True(t, fresh)
+
+	assert.NoError(t, c.DeleteTargets(context.Background(), []string{"docs", "unknown"}))
+	assert.False(t, mr.Exists("docs"))
+
+	mr.Close()
+
+	_, _, err = c.GetTarget(context.Background(), "docs")
+
+	assert.True(t, errors.Is(err, data.ErrRedis))
+	assert.True(t, errors.Is(c.AddTarget(context.Background(), data.Path{Path: "docs"}), data.ErrRedis))
+	assert.True(t, errors.Is(c.DeleteTargets(context.Background(), []string{"docs"}), data.ErrRedis))
+}
+
+func TestRedisCache_Canceled(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	c := NewRedisCache(client, testTtls, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := c.GetTarget(ctx, "docs")
+
+	assert.True(t, errors.Is(err, data.ErrRedis))
+}
+
+func TestRedisCache_Stale(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	c := NewRedisCache(client, Ttls{Target: time.Nanosecond, Stale: time.Minute, Missing: time.Minute}, time.Second)
+
+	assert.NoError(t, c.AddTarget(context.Background(), data.Path{Path: "docs", Target: "https://example.com/docs"}))
+
+	time.Sleep(time.Millisecond)
+
+	target, fresh, err := c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/docs", target)
+	assert.False(t, fresh)
+}
+
+func TestRedisCache_MissingTargets(t *testing.T) {
+	mr, client := newTestRedisClient(t)
+	c := NewRedisCache(client, testTtls, time.Second)
+
+	assert.NoError(t, c.AddMissingTarget(context.Background(), "docs"))
+	assert.Equal(t, testTtls.Missing, mr.TTL("docs"))
+
+	_, _, err := c.GetTarget(context.Background(), "docs")
+
+	assert.True(t, errors.Is(err, data.ErrSqlNoRow))
+
+	mr.FastForward(testTtls.Missing)
+
+	target, _, err := c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", target)
+
+	assert.NoError(t, c.AddMissingTarget(context.Background(), "docs"))
+	assert.NoError(t, c.DeleteTargets(context.Background(), []string{"docs"}))
+
+	target, _, err = c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", target)
+}
+
+func TestRedisCache_ConcurrentLoginFailures(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	c := NewRedisCache(client, testTtls, time.Second)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, c.AddLoginFailure(context.Background(), "ip:10.0.0.1", time.Minute))
+		}()
+	}
+
+	wg.Wait()
+
+	f, err := c.GetLoginFailures(context.Background(), "ip:10.0.0.1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 20, f.Failures)
+}
+
+func TestRedisCache_LoginFailures(t *testing.T) {
+	mr, client := newTestRedisClient(t)
+	c := NewRedisCache(client, testTtls, time.Second)
+
+	f, err := c.GetLoginFailures(context.Background(), "user:alice")
+
+	assert.NoError(t, err)
+	assert.Equal(t, data.LoginFailures{}, f)
+
+	assert.NoError(t, c.AddLoginFailure(context.Background(), "user:alice", time.Minute))
+	assert.NoError(t, c.AddLoginFailure(context.Background(), "user:alice", time.Minute))
+	assert.Equal(t, time.Minute, mr.TTL("user:alice"))
+
+	f, err = c.GetLoginFailures(context.Background(), "user:alice")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, f.Failures)
+	assert.WithinDuration(t, time.Now(), f.LastFailure, time.Second)
+
+	assert.NoError(t, c.DeleteLoginFailures(context.Background(), "user:alice"))
+	assert.False(t, mr.Exists("user:alice"))
+}
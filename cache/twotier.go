@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"go-there/data"
+	"sync"
+	"time"
+
+	rediscache "github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis channel on which the invalidated paths are published, as a JSON array
+const invalidationChannel = "go-there:invalidate"
+
+// TwoTierCache keeps the targets in a local cache in front of a Redis instance. A miss in the local cache falls back to
+// Redis, and a hit in Redis fills the local cache. The data shared between instances, such as the authentication
+// failures, is only stored in Redis.
+//
+// Deleted targets are published on a Redis channel to which every instance is subscribed, so that they are evicted from
+// all the local caches. Invalidations published while an instance is disconnected from Redis are lost, and its local
+// entries only expire with their ttl.
+type TwoTierCache struct {
+	RedisCache
+	pubsub *redis.PubSub
+
+	mu           sync.Mutex
+	onInvalidate func(paths []string)
+}
+
+// NewTwoTierCache returns a TwoTierCache using the Redis client, keeping the entries for ttls, and lc, which defines the
+// size and ttl of the local cache. Each Redis operation is canceled after timeout. It subscribes to the invalidations of
+// the other instances until Close is called.
+func NewTwoTierCache(client *redis.Client, lc rediscache.LocalCache, ttls Ttls, timeout time.Duration) *TwoTierCache {
+	cache := &TwoTierCache{
+		RedisCache: RedisCache{
+			client: client,
+			rc: rediscache.New(&rediscache.Options{
+				Redis:      client,
+				LocalCache: lc,
+			}),
+			ttls:    ttls,
+			timeout: timeout,
+		},
+		pubsub: client.Subscribe(context.Background(), invalidationChannel),
+	}
+
+	// Waits for the subscription, so that no invalidation is missed once the instance starts. The subscription is
+	// retried in the background if Redis is unreachable.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := cache.pubsub.Receive(ctx)
+
+	if err != nil {
+		log.Error().Err(fmt.Errorf("%w: %s", data.ErrRedis, err)).Msg("cannot subscribe to the cache invalidations")
+	}
+
+	go cache.listen()
+
+	return cache
+}
+
+// listen evicts the paths received on the invalidation channel from the local cache, until the subscription is closed.
+func (cache *TwoTierCache) listen() {
+	for msg := range cache.pubsub.Channel() {
+		var paths []string
+
+		if err := json.Unmarshal([]byte(msg.Payload), &paths); err != nil {
+			log.Warn().Err(err).Msg("invalid cache invalidation message")
+			continue
+		}
+
+		cache.mu.Lock()
+		onInvalidate := cache.onInvalidate
+		cache.mu.Unlock()
+
+		// Called first, so that the path is not cached again from a lagging database replica
+		if onInvalidate != nil {
+			onInvalidate(paths)
+		}
+
+		for _, p := range paths {
+			cache.rc.DeleteFromLocalCache(p)
+		}
+	}
+}
+
+// OnInvalidate sets a function called with the paths invalidated by any instance, including this one, before they are
+// evicted from the local cache.
+func (cache *TwoTierCache) OnInvalidate(f func(paths []string)) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.onInvalidate = f
+}
+
+// DeleteTargets deletes all targets corresponding to the paths array provided from Redis and the local cache, then
+// publishes them so that the other instances evict them too. Returns a data.ErrRedis if it fails.
+func (cache *TwoTierCache) DeleteTargets(ctx context.Context, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	err := cache.RedisCache.DeleteTargets(ctx, paths)
+
+	if err != nil {
+		return err
+	}
+
+	msg, err := json.Marshal(paths)
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := cache.withTimeout(ctx)
+	defer cancel()
+
+	err = cache.client.Publish(ctx, invalidationChannel, msg).Err()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", data.ErrRedis, err)
+	}
+
+	return nil
+}
+
+// Close stops listening to the invalidations of the other instances.
+func (cache *TwoTierCache) Close() error {
+	return cache.pubsub.Close()
+}
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"go-there/data"
+	"testing"
+	"time"
+
+	rediscache "github.com/go-redis/cache/v8"
+)
+
+func newTestTwoTierCache(t *testing.T, client *redis.Client) *TwoTierCache {
+	c := NewTwoTierCache(client, rediscache.NewTinyLFU(10, time.Minute), testTtls, time.Second)
+
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+
+	return c
+}
+
+func TestTwoTierCache_Targets(t *testing.T) {
+	mr, client := newTestRedisClient(t)
+	c := newTestTwoTierCache(t, client)
+
+	assert.NoError(t, c.AddTarget(context.Background(), data.Path{Path: "docs", Target: "https://example.com/docs"}))
+	assert.True(t, mr.Exists("docs"))
+
+	// Served by the local cache
+	mr.FlushAll()
+
+	target, _, err := c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/docs", target)
+
+	// Another instance fills Redis, the local cache is filled on the first hit
+	other := newTestTwoTierCache(t, client)
+
+	assert.NoError(t, other.AddTarget(context.Background(), data.Path{Path: "wiki", Target: "https://example.com/wiki"}))
+
+	target, _, err = c.GetTarget(context.Background(), "wiki")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/wiki", target)
+
+	mr.FlushAll()
+
+	target, _, err = c.GetTarget(context.Background(), "wiki")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/wiki", target)
+
+	assert.NoError(t, c.DeleteTargets(context.Background(), []string{"docs", "wiki"}))
+
+	target, _, err = c.GetTarget(context.Background(), "docs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", target)
+}
+
+func TestTwoTierCache_LoginFailures(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	c := newTestTwoTierCache(t, client)
+	other := newTestTwoTierCache(t, client)
+
+	assert.NoError(t, c.AddLoginFailure(context.Background(), "user:alice", time.Minute))
+
+	f, err := other.GetLoginFailures(context.Background(), "user:alice")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, f.Failures)
+
+	// The failures are never kept in the local cache, so the other instance sees every change
+	assert.NoError(t, c.AddLoginFailure(context.Background(), "user:alice", time.Minute))
+
+	f, err = other.GetLoginFailures(context.Background(), "user:alice")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, f.Failures)
+}
+
+func TestTwoTierCache_Invalidation(t *testing.T) {
+	mr, client := newTestRedisClient(t)
+	c := newTestTwoTierCache(t, client)
+	other := newTestTwoTierCache(t, client)
+
+	assert.NoError(t, c.AddTarget(context.Background(), data.Path{Path: "docs", Target: "https://example.com/docs"}))
+	assert.NoError(t, c.AddTarget(context.Background(), data.Path{Path: "wiki", Target: "https://example.com/wiki"}))
+
+	// Fills the local cache of the other instance
+	for _, p := range []string{"docs", "wiki"} {
+		target, _, err := other.GetTarget(context.Background(), p)
+
+		assert.NoError(t, err)
+		assert.NotEmpty(t, target)
+	}
+
+	assert.NoError(t, c.DeleteTargets(context.Background(), []string{"docs"}))
+
+	// Only the local cache of the other instance is left
+	mr.FlushAll()
+
+	assert.Eventually(t, func() bool {
+		target, _, err := other.GetTarget(context.Background(), "docs")
+
+		return err == nil && target == ""
+	}, time.Second, 10*time.Millisecond)
+
+	target, _, err := other.GetTarget(context.Background(), "wiki")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/wiki", target)
+}
+
+func TestTwoTierCache_OnInvalidate(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	c := newTestTwoTierCache(t, client)
+	other := newTestTwoTierCache(t, client)
+
+	invalidated := make(chan []string, 1)
+	other.OnInvalidate(func(paths []string) {
+		invalidated <- paths
+	})
+
+	assert.NoError(t, c.DeleteTargets(context.Background(), []string{"docs", "wiki"}))
+
+	select {
+	case paths := <-invalidated:
+		assert.Equal(t, []string{"docs", "wiki"}, paths)
+	case <-time.After(time.Second):
+		t.Fatal("invalidation not received")
+	}
+}
+
+func TestTwoTierCache_MissingTargetInvalidation(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	c := newTestTwoTierCache(t, client)
+	other := newTestTwoTierCache(t, client)
+
+	assert.NoError(t, c.AddMissingTarget(context.Background(), "docs"))
+
+	// Fills the local cache of the other instance with the missing entry
+	_, _, err := other.GetTarget(context.Background(), "docs")
+
+	assert.True(t, errors.Is(err, data.ErrSqlNoRow))
+
+	// The path is created on the first instance
+	assert.NoError(t, c.DeleteTargets(context.Background(), []string{"docs"}))
+
+	assert.Eventually(t, func() bool {
+		target, _, err := other.GetTarget(context.Background(), "docs")
+
+		return err == nil && target == ""
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTwoTierCache_InvalidMessage(t *testing.T) {
+	mr, client := newTestRedisClient(t)
+	c := newTestTwoTierCache(t, client)
+
+	assert.NoError(t, c.AddTarget(context.Background(), data.Path{Path: "docs", Target: "https://example.com/docs"}))
+
+	mr.FlushAll()
+
+	assert.NoError(t, client.Publish(context.Background(), invalidationChannel, "docs").Err())
+	assert.NoError(t, client.Publish(context.Background(), invalidationChannel, "[\"docs\"]").Err())
+
+	// The valid message is still handled after the invalid one
+	assert.Eventually(t, func() bool {
+		target, _, err := c.GetTarget(context.Background(), "docs")
+
+		return err == nil && target == ""
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,310 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go-there/api"
+	"go-there/auth"
+	"go-there/cache"
+	"go-there/config"
+	"go-there/data"
+	"go-there/database"
+	"go-there/datasource"
+	"go-there/notify"
+	"io"
+	"os"
+	"strings"
+)
+
+// Version of the application, set at build time with -ldflags "-X go-there/cli.Version=..."
+var Version = "dev"
+
+// Exit codes returned by Run
+const (
+	exitOk    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// errUsage is returned by the commands when they are called with invalid arguments.
+var errUsage = errors.New("invalid usage")
+
+const usage = `Usage: go-there [-config path] [command]
+
+Commands:
+  serve                     Start the server (default)
+  user create               Create a user, reading his password from the standard input
+  user list                 List all users
+  user delete               Delete a user and all his paths
+  user set-admin            Grant or revoke the admin status of a user
+  user reset-password       Replace the password of a user, reading it from the standard input
+  user rotate-key           Replace the API key of a user
+  path add                  Add a path for a user
+  path rm                   Remove a path
+  path ls                   List all paths
+  path mv                   Rename a path
+  migrate                   Update the database schema, listing the paths which prevent it
+  check-config              Validate the configuration
+  version                   Print the version
+
+Run 'go-there user <command> -h' or 'go-there path <command> -h' for the flags of a command.
+`
+
+// DataSourcer represents the datasource.DataSource methods needed by the commands.
+type DataSourcer interface {
+	SelectAllUsers(ctx context.Context) ([]data.UserInfo, error)
+	SelectUserLogin(ctx context.Context, username string) (data.User, error)
+	InsertUser(ctx context.Context, user data.User) error
+	DeleteUser(ctx context.Context, username string) error
+	UpdateUserAdmin(ctx context.Context, user data.User) error
+	UpdateUserPassword(ctx context.Context, user data.User) error
+	UpdateUserApiKey(ctx context.Context, user data.User) error
+	SelectPath(ctx context.Context, path string) (data.Path, error)
+	SelectAllPaths(ctx context.Context) ([]data.OwnedPath, error)
+	InsertPath(ctx context.Context, path data.Path) error
+	DeletePath(ctx context.Context, path data.Path) error
+	UpdatePath(ctx context.Context, oldPath string, newPath string) error
+	SelectDuplicatePaths(ctx context.Context) ([]data.DuplicatePath, error)
+	CountIncompletePaths(ctx context.Context) (int, error)
+	MigratePaths(ctx context.Context) error
+}
+
+// env represents what a command needs to run.
+type env struct {
+	ds     DataSourcer
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Run parses the arguments and runs the requested command, or starts the server if no command is provided. Returns the
+// exit code of the process.
+func Run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("go-there", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { _, _ = fmt.Fprint(stderr, usage) }
+
+	configPath := fs.String("config", "", "Path to the configuration file")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOk
+		}
+
+		return exitUsage
+	}
+
+	args = fs.Args()
+
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	// Basic logging for the initialization
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+
+	switch args[0] {
+	case "serve":
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+
+		serve(*configPath)
+		return exitOk
+	case "version":
+		_, _ = fmt.Fprintln(stdout, Version)
+		return exitOk
+	case "help":
+		_, _ = fmt.Fprint(stdout, usage)
+		return exitOk
+	}
+
+	// The output of the commands is kept apart from the logs
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: stderr})
+
+	var err error
+
+	switch args[0] {
+	case "check-config":
+		err = checkConfig(*configPath, stdout)
+	case "user":
+		err = runWithDataSource(*configPath, args[1:], stdin, stdout, stderr, userCommand)
+	case "path":
+		err = runWithDataSource(*configPath, args[1:], stdin, stdout, stderr, pathCommand)
+	case "migrate":
+		err = runWithDataSource(*configPath, args[1:], stdin, stdout, stderr, migrateCommand)
+	default:
+		err = fmt.Errorf("%w : unknown command %s", errUsage, args[0])
+	}
+
+	switch {
+	case err == nil:
+		return exitOk
+	case errors.Is(err, flag.ErrHelp):
+		return exitOk
+	case errors.Is(err, errUsage):
+		_, _ = fmt.Fprintf(stderr, "error: %s\n\n%s", err, usage)
+		return exitUsage
+	default:
+		_, _ = fmt.Fprintf(stderr, "error: %s\n", err)
+		return exitError
+	}
+}
+
+// applySettings applies every setting of the configuration needed before creating users or starting the server.
+// Returns a data.ErrSettings if a setting is invalid.
+func applySettings(conf *config.Configuration) error {
+	if err := api.ApplyUserSettings(conf); err != nil {
+		return err
+	}
+
+	if err := api.ApplyPasswordPolicy(conf); err != nil {
+		return err
+	}
+
+	if err := auth.ApplyRoles(conf); err != nil {
+		return err
+	}
+
+	if err := auth.ApplyPasswordSettings(conf); err != nil {
+		return err
+	}
+
+	if err := auth.ApplySessionSettings(conf); err != nil {
+		return err
+	}
+
+	if err := auth.ApplyClientCertSettings(conf); err != nil {
+		return err
+	}
+
+	auth.ApplyImpersonationSettings(conf)
+	auth.ApplyTwoFactorSettings(conf)
+
+	return nil
+}
+
+// checkConfig loads the configuration and applies all its settings without connecting to the database or the cache.
+// Returns the first invalid setting.
+func checkConfig(configPath string, stdout io.Writer) error {
+	conf, err := config.Init(configPath)
+
+	if err != nil {
+		return err
+	}
+
+	if err := applySettings(conf); err != nil {
+		return err
+	}
+
+	if _, err := notify.Init(conf); err != nil {
+		return err
+	}
+
+	if conf.Server.HttpListenPort <= 0 && conf.Server.HttpsListenPort <= 0 {
+		return fmt.Errorf("%w : %s", data.ErrSettings, "no listening port configured")
+	}
+
+	_, _ = fmt.Fprintln(stdout, "configuration ok")
+
+	return nil
+}
+
+// runWithDataSource loads the configuration, connects to the configured database and cache, then runs the command
+// with the remaining arguments.
+func runWithDataSource(configPath string, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer,
+	command func(e env, args []string) error) error {
+	conf, err := config.Init(configPath)
+
+	if err != nil {
+		return err
+	}
+
+	if err := applySettings(conf); err != nil {
+		return err
+	}
+
+	db, err := database.Init(conf)
+
+	if err != nil {
+		return err
+	}
+
+	appCache, err := cache.Init(conf)
+
+	if err != nil {
+		return err
+	}
+
+	if closer, ok := appCache.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+
+	auth.InitApiKeyPepper(conf)
+
+	// Lets the commands clear the failures shared with the running instances
+	if store, ok := appCache.(auth.FailureStore); ok {
+		auth.ApplyLoginSettings(conf, store)
+	} else {
+		auth.ApplyLoginSettings(conf, nil)
+	}
+
+	return command(env{
+		ds:     datasource.Init(db, appCache),
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+	}, args)
+}
+
+// newFlagSet returns a flag set for a command, writing its errors and usage to the standard error.
+func newFlagSet(e env, name string, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(e.stderr, "Usage: go-there %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parseArgs parses the flags of a command and checks that exactly n arguments follow them.
+func parseArgs(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+
+		return fmt.Errorf("%w : %s", errUsage, err)
+	}
+
+	if fs.NArg() != n {
+		return fmt.Errorf("%w : %s expects %d argument(s)", errUsage, fs.Name(), n)
+	}
+
+	return nil
+}
+
+// readPassword reads a password from the first line of the standard input, so that it never appears in the arguments
+// of the process.
+func readPassword(e env) (string, error) {
+	line, err := bufio.NewReader(e.stdin).ReadString('\n')
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+
+	if password == "" {
+		return "", errors.New("no password provided on the standard input")
+	}
+
+	return password, nil
+}
@@ -0,0 +1,463 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go-there/data"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockDataSourcer struct {
+	updated    []data.User
+	paths      []data.Path
+	renamed    []string
+	duplicates []data.DuplicatePath
+	incomplete int
+	migrated   bool
+}
+
+func (*mockDataSourcer) SelectAllUsers(ctx context.Context) ([]data.UserInfo, error) {
+	return []data.UserInfo{
+		{Username: "alice", Roles: []string{}},
+		{Username: "root", IsAdmin: true, Roles: []string{"viewer", "helpdesk"}, Disabled: true},
+	}, nil
+}
+
+func (*mockDataSourcer) SelectUserLogin(ctx context.Context, username string) (data.User, error) {
+	switch username {
+	case "alice":
+		return data.User{Id: 1, Username: "alice"}, nil
+	case "root":
+		return data.User{Id: 2, Username: "root", IsAdmin: true}, nil
+	case "error":
+		return data.User{}, data.ErrSql
+	}
+
+	return data.User{}, data.ErrSqlNoRow
+}
+
+func (m *mockDataSourcer) InsertUser(ctx context.Context, user data.User) error {
+	if user.Username == "alice" {
+		return data.ErrSqlDuplicateRow
+	}
+
+	m.updated = append(m.updated, user)
+
+	return nil
+}
+
+func (m *mockDataSourcer) DeleteUser(ctx context.Context, username string) error {
+	m.updated = append(m.updated, data.User{Username: username})
+
+	return nil
+}
+
+func (m *mockDataSourcer) UpdateUserAdmin(ctx context.Context, user data.User) error {
+	m.updated = append(m.updated, user)
+
+	return nil
+}
+
+func (m *mockDataSourcer) UpdateUserPassword(ctx context.Context, user data.User) error {
+	m.updated = append(m.updated, user)
+
+	return nil
+}
+
+func (m *mockDataSourcer) UpdateUserApiKey(ctx context.Context, user data.User) error {
+	m.updated = append(m.updated, user)
+
+	return nil
+}
+
+func (*mockDataSourcer) SelectPath(ctx context.Context, path string) (data.Path, error) {
+	switch path {
+	case "docs":
+		return data.Path{Path: "docs", Target: "https://example.com/docs", UserId: 1}, nil
+	case "wiki":
+		return data.Path{Path: "wiki", Target: "https://example.com/wiki", UserId: 2}, nil
+	case "error":
+		return data.Path{}, data.ErrSql
+	}
+
+	return data.Path{}, data.ErrSqlNoRow
+}
+
+func (*mockDataSourcer) SelectAllPaths(ctx context.Context) ([]data.OwnedPath, error) {
+	return []data.OwnedPath{
+		{Path: "docs", Target: "https://example.com/docs", Username: "alice",
+			CreatedAt: time.Date(2021, 8, 1, 10, 0, 0, 0, time.UTC), UpdatedAt: time.Date(2021, 8, 2, 12, 30, 0, 0, time.UTC)},
+		{Path: "wiki", Target: "https://example.com/wiki", Username: "root",
+			CreatedAt: time.Date(2021, 8, 3, 9, 15, 0, 0, time.UTC), UpdatedAt: time.Date(2021, 8, 3, 9, 15, 0, 0, time.UTC)},
+	}, nil
+}
+
+func (m *mockDataSourcer) InsertPath(ctx context.Context, path data.Path) error {
+	m.paths = append(m.paths, path)
+
+	return nil
+}
+
+func (m *mockDataSourcer) DeletePath(ctx context.Context, path data.Path) error {
+	m.paths = append(m.paths, path)
+
+	return nil
+}
+
+func (m *mockDataSourcer) UpdatePath(ctx context.Context, oldPath string, newPath string) error {
+	m.renamed = append(m.renamed, oldPath, newPath)
+
+	return nil
+}
+
+func (m *mockDataSourcer) SelectDuplicatePaths(ctx context.Context) ([]data.DuplicatePath, error) {
+	return m.duplicates, nil
+}
+
+func (m *mockDataSourcer) CountIncompletePaths(ctx context.Context) (int, error) {
+	return m.incomplete, nil
+}
+
+func (m *mockDataSourcer) MigratePaths(ctx context.Context) error {
+	m.migrated = true
+
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+	}{
+		{
+			name:       "version",
+			args:       []string{"version"},
+			wantCode:   exitOk,
+			wantStdout: "dev\n",
+		},
+		{
+			name:     "unknown_command",
+			args:     []string{"unknown"},
+			wantCode: exitUsage,
+		},
+		{
+			name:     "unknown_flag",
+			args:     []string{"-unknown"},
+			wantCode: exitUsage,
+		},
+		{
+			name:     "no_config",
+			args:     []string{"-config", "/nonexistent/go-there.conf", "check-config"},
+			wantCode: exitError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout := new(bytes.Buffer)
+			stderr := new(bytes.Buffer)
+
+			code := Run(tt.args, strings.NewReader(""), stdout, stderr)
+
+			assert.Equal(t, tt.wantCode, code)
+			assert.Equal(t, tt.wantStdout, stdout.String())
+		})
+	}
+}
+
+func Test_userCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		stdin       string
+		wantErr     string
+		wantStdout  string
+		wantUpdated []data.User
+	}{
+		{
+			name:        "create",
+			args:        []string{"create", "-admin", "-email", "bob@example.com", "bob"},
+			stdin:       "superpassword\n",
+			wantUpdated: []data.User{{Username: "bob", IsAdmin: true, Email: "bob@example.com"}},
+		},
+		{
+			name:    "create_exists",
+			args:    []string{"create", "alice"},
+			stdin:   "superpassword\n",
+			wantErr: "user alice already exists",
+		},
+		{
+			name:    "create_invalid_username",
+			args:    []string{"create", "Bob!"},
+			stdin:   "superpassword\n",
+			wantErr: "invalid username",
+		},
+		{
+			name:    "create_invalid_email",
+			args:    []string{"create", "-email", "bob", "bob"},
+			stdin:   "superpassword\n",
+			wantErr: "invalid email",
+		},
+		{
+			name:    "create_invalid_password",
+			args:    []string{"create", "bob"},
+			stdin:   "short\n",
+			wantErr: "invalid password",
+		},
+		{
+			name:    "create_no_password",
+			args:    []string{"create", "bob"},
+			wantErr: "no password provided on the standard input",
+		},
+		{
+			name:    "create_no_username",
+			args:    []string{"create"},
+			wantErr: "invalid usage : user create expects 1 argument(s)",
+		},
+		{
+			name:       "list",
+			args:       []string{"list"},
+			wantStdout: "USERNAME  ADMIN  ROLES            DISABLED\nalice     false                   false\nroot      true   viewer,helpdesk  true\n",
+		},
+		{
+			name:        "delete",
+			args:        []string{"delete", "alice"},
+			wantUpdated: []data.User{{Username: "alice"}},
+		},
+		{
+			name:    "delete_no_user",
+			args:    []string{"delete", "noUser"},
+			wantErr: "user noUser does not exist",
+		},
+		{
+			name:        "set_admin",
+			args:        []string{"set-admin", "alice"},
+			wantUpdated: []data.User{{Id: 1, Username: "alice", IsAdmin: true}},
+		},
+		{
+			name:        "set_admin_revoke",
+			args:        []string{"set-admin", "-revoke", "root"},
+			wantUpdated: []data.User{{Id: 2, Username: "root", IsAdmin: false}},
+		},
+		{
+			name:    "set_admin_error",
+			args:    []string{"set-admin", "error"},
+			wantErr: data.ErrSql.Error(),
+		},
+		{
+			name:        "reset_password",
+			args:        []string{"reset-password", "alice"},
+			stdin:       "newpassword\r\n",
+			wantUpdated: []data.User{{Id: 1, Username: "alice"}},
+		},
+		{
+			name:    "reset_password_no_user",
+			args:    []string{"reset-password", "noUser"},
+			stdin:   "newpassword\n",
+			wantErr: "user noUser does not exist",
+		},
+		{
+			name:        "rotate_key",
+			args:        []string{"rotate-key", "alice"},
+			wantUpdated: []data.User{{Id: 1, Username: "alice"}},
+		},
+		{
+			name:    "unknown",
+			args:    []string{"rename", "alice"},
+			wantErr: "invalid usage : unknown user subcommand rename",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &mockDataSourcer{}
+			stdout := new(bytes.Buffer)
+
+			err := userCommand(env{
+				ds:     ds,
+				stdin:  strings.NewReader(tt.stdin),
+				stdout: stdout,
+				stderr: new(bytes.Buffer),
+			}, tt.args)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+
+			// Generated secrets are only checked for presence
+			for i := range ds.updated {
+				assert.Equal(t, tt.wantUpdated[i].Username, ds.updated[i].Username)
+				assert.Equal(t, tt.wantUpdated[i].IsAdmin, ds.updated[i].IsAdmin)
+				assert.Equal(t, tt.wantUpdated[i].Email, ds.updated[i].Email)
+			}
+
+			assert.Len(t, ds.updated, len(tt.wantUpdated))
+
+			switch tt.args[0] {
+			case "create", "rotate-key":
+				assert.True(t, strings.HasPrefix(stdout.String(), "gt_"), stdout.String())
+				assert.NotEmpty(t, ds.updated[0].ApiKeyHash)
+			case "reset-password":
+				assert.NotEmpty(t, ds.updated[0].PasswordHash)
+			default:
+				assert.Equal(t, tt.wantStdout, stdout.String())
+			}
+		})
+	}
+}
+
+func Test_pathCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantErr     string
+		wantStdout  string
+		wantPaths   []data.Path
+		wantRenamed []string
+	}{
+		{
+			name:      "add",
+			args:      []string{"add", "-user", "alice", "blog", "https://example.com/blog"},
+			wantPaths: []data.Path{{Path: "blog", Target: "https://example.com/blog", UserId: 1}},
+		},
+		{
+			name:    "add_exists",
+			args:    []string{"add", "-user", "alice", "docs", "https://example.com/blog"},
+			wantErr: "path docs already exists",
+		},
+		{
+			name:    "add_no_user",
+			args:    []string{"add", "-user", "noUser", "blog", "https://example.com/blog"},
+			wantErr: "user noUser does not exist",
+		},
+		{
+			name:    "add_missing_user",
+			args:    []string{"add", "blog", "https://example.com/blog"},
+			wantErr: "invalid usage : path add needs a -user",
+		},
+		{
+			name:      "rm",
+			args:      []string{"rm", "wiki"},
+			wantPaths: []data.Path{{Path: "wiki", Target: "https://example.com/wiki", UserId: 2}},
+		},
+		{
+			name:    "rm_no_path",
+			args:    []string{"rm", "blog"},
+			wantErr: "path blog does not exist",
+		},
+		{
+			name: "ls",
+			args: []string{"ls"},
+			wantStdout: "PATH  TARGET                    USER   CREATED              UPDATED\n" +
+				"docs  https://example.com/docs  alice  2021-08-01 10:00:00  2021-08-02 12:30:00\n" +
+				"wiki  https://example.com/wiki  root   2021-08-03 09:15:00  2021-08-03 09:15:00\n",
+		},
+		{
+			name: "ls_user",
+			args: []string{"ls", "-user", "root"},
+			wantStdout: "PATH  TARGET                    USER  CREATED              UPDATED\n" +
+				"wiki  https://example.com/wiki  root  2021-08-03 09:15:00  2021-08-03 09:15:00\n",
+		},
+		{
+			name:        "mv",
+			args:        []string{"mv", "docs", "documentation"},
+			wantRenamed: []string{"docs", "documentation"},
+		},
+		{
+			name:    "mv_exists",
+			args:    []string{"mv", "docs", "wiki"},
+			wantErr: "path wiki already exists",
+		},
+		{
+			name:    "mv_error",
+			args:    []string{"mv", "docs", "error"},
+			wantErr: data.ErrSql.Error(),
+		},
+		{
+			name:    "mv_missing_argument",
+			args:    []string{"mv", "docs"},
+			wantErr: "invalid usage : path mv expects 2 argument(s)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &mockDataSourcer{}
+			stdout := new(bytes.Buffer)
+
+			err := pathCommand(env{
+				ds:     ds,
+				stdin:  strings.NewReader(""),
+				stdout: stdout,
+				stderr: new(bytes.Buffer),
+			}, tt.args)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStdout, stdout.String())
+			assert.Equal(t, tt.wantPaths, ds.paths)
+			assert.Equal(t, tt.wantRenamed, ds.renamed)
+		})
+	}
+}
+
+func Test_migrateCommand(t *testing.T) {
+	tests := []struct {
+		name         string
+		ds           *mockDataSourcer
+		wantErr      string
+		wantStdout   string
+		wantMigrated bool
+	}{
+		{
+			name:         "ok",
+			ds:           &mockDataSourcer{},
+			wantStdout:   "database schema up to date\n",
+			wantMigrated: true,
+		},
+		{
+			name: "duplicates",
+			ds: &mockDataSourcer{
+				duplicates: []data.DuplicatePath{{Path: "docs", Count: 2}, {Path: "wiki", Count: 3}},
+				incomplete: 1,
+			},
+			wantErr: "remove the duplicate and incomplete paths before migrating",
+			wantStdout: "duplicate path docs: 2 rows\nduplicate path wiki: 3 rows\n" +
+				"incomplete paths: 1 rows without a path, target or owner\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout := new(bytes.Buffer)
+
+			err := migrateCommand(env{
+				ds:     tt.ds,
+				stdin:  strings.NewReader(""),
+				stdout: stdout,
+				stderr: new(bytes.Buffer),
+			}, nil)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.wantStdout, stdout.String())
+			assert.Equal(t, tt.wantMigrated, tt.ds.migrated)
+		})
+	}
+}
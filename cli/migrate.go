@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// migrateCommand updates the schema of the database. The paths which prevent it are listed first, in which case nothing
+// is changed.
+func migrateCommand(e env, args []string) error {
+	fs := newFlagSet(e, "migrate", "")
+
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	duplicates, err := e.ds.SelectDuplicatePaths(context.Background())
+
+	if err != nil {
+		return err
+	}
+
+	for _, d := range duplicates {
+		_, _ = fmt.Fprintf(e.stdout, "duplicate path %s: %d rows\n", d.Path, d.Count)
+	}
+
+	incomplete, err := e.ds.CountIncompletePaths(context.Background())
+
+	if err != nil {
+		return err
+	}
+
+	if incomplete > 0 {
+		_, _ = fmt.Fprintf(e.stdout, "incomplete paths: %d rows without a path, target or owner\n", incomplete)
+	}
+
+	if len(duplicates) > 0 || incomplete > 0 {
+		return errors.New("remove the duplicate and incomplete paths before migrating")
+	}
+
+	if err := e.ds.MigratePaths(context.Background()); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(e.stdout, "database schema up to date")
+
+	return nil
+}
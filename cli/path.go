@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-there/data"
+	"text/tabwriter"
+)
+
+// Format of the timestamps listed by path ls, which are stored in UTC
+const pathTimeFormat = "2006-01-02 15:04:05"
+
+// pathCommand runs the path subcommand named by the first argument.
+func pathCommand(e env, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w : missing path subcommand", errUsage)
+	}
+
+	switch args[0] {
+	case "add":
+		return pathAdd(e, args[1:])
+	case "rm":
+		return pathRm(e, args[1:])
+	case "ls":
+		return pathLs(e, args[1:])
+	case "mv":
+		return pathMv(e, args[1:])
+	default:
+		return fmt.Errorf("%w : unknown path subcommand %s", errUsage, args[0])
+	}
+}
+
+// pathAdd adds a path redirecting to a target for a user.
+func pathAdd(e env, args []string) error {
+	fs := newFlagSet(e, "path add", "<path> <target>")
+	username := fs.String("user", "", "Owner of the path (required)")
+
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return fmt.Errorf("%w : path add needs a -user", errUsage)
+	}
+
+	u, err := selectUser(e, *username)
+
+	if err != nil {
+		return err
+	}
+
+	if err := checkPathFree(e, fs.Arg(0)); err != nil {
+		return err
+	}
+
+	err = e.ds.InsertPath(context.Background(), data.Path{
+		Path:   fs.Arg(0),
+		Target: fs.Arg(1),
+		UserId: u.Id,
+	})
+
+	if errors.Is(err, data.ErrSqlDuplicateRow) {
+		return fmt.Errorf("path %s already exists", fs.Arg(0))
+	}
+
+	return err
+}
+
+// pathRm removes a path, whoever created it.
+func pathRm(e env, args []string) error {
+	fs := newFlagSet(e, "path rm", "<path>")
+
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	p, err := selectPath(e, fs.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	return e.ds.DeletePath(context.Background(), p)
+}
+
+// pathLs prints all the paths as a table, or only the paths of a user.
+func pathLs(e env, args []string) error {
+	fs := newFlagSet(e, "path ls", "")
+	username := fs.String("user", "", "Only list the paths of this user")
+
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	paths, err := e.ds.SelectAllPaths(context.Background())
+
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(w, "PATH\tTARGET\tUSER\tCREATED\tUPDATED")
+
+	for _, p := range paths {
+		if *username != "" && p.Username != *username {
+			continue
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Path, p.Target, p.Username,
+			p.CreatedAt.Format(pathTimeFormat), p.UpdatedAt.Format(pathTimeFormat))
+	}
+
+	return w.Flush()
+}
+
+// pathMv renames a path, keeping its target and owner. The new path must not exist.
+func pathMv(e env, args []string) error {
+	fs := newFlagSet(e, "path mv", "<path> <new path>")
+
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+
+	if _, err := selectPath(e, fs.Arg(0)); err != nil {
+		return err
+	}
+
+	if err := checkPathFree(e, fs.Arg(1)); err != nil {
+		return err
+	}
+
+	err := e.ds.UpdatePath(context.Background(), fs.Arg(0), fs.Arg(1))
+
+	switch {
+	case errors.Is(err, data.ErrSqlNoRow):
+		return fmt.Errorf("path %s does not exist", fs.Arg(0))
+	case errors.Is(err, data.ErrSqlDuplicateRow):
+		return fmt.Errorf("path %s already exists", fs.Arg(1))
+	}
+
+	return err
+}
+
+// selectPath fetches a path. Returns an error naming the path if it doesn't exist.
+func selectPath(e env, path string) (data.Path, error) {
+	p, err := e.ds.SelectPath(context.Background(), path)
+
+	if errors.Is(err, data.ErrSqlNoRow) {
+		return data.Path{}, fmt.Errorf("path %s does not exist", path)
+	}
+
+	return p, err
+}
+
+// checkPathFree returns an error if the path already exists.
+func checkPathFree(e env, path string) error {
+	_, err := e.ds.SelectPath(context.Background(), path)
+
+	switch {
+	case err == nil:
+		return fmt.Errorf("path %s already exists", path)
+	case errors.Is(err, data.ErrSqlNoRow):
+		return nil
+	default:
+		return err
+	}
+}
@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go-there/api"
+	"go-there/auth"
+	"go-there/bootstrap"
+	"go-there/cache"
+	"go-there/config"
+	"go-there/data"
+	"go-there/database"
+	"go-there/datasource"
+	"go-there/gopath"
+	"go-there/health"
+	"go-there/logging"
+	"go-there/notify"
+	"go-there/server"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Interval at which the accesses to the paths are added to the database
+const hitsFlushInterval = time.Minute
+
+// Interval at which the database is pinged, so that the health endpoint reports its state
+const healthCheckInterval = 10 * time.Second
+
+// Interval at which the paths snapshot is refreshed, if not configured
+const defaultSnapshotInterval = 5 * time.Minute
+
+// Number of paths fetched at a time during the cache warm-up, if not configured
+const defaultWarmUpBatchSize = 1000
+
+// serve initializes the application from the configuration file and starts the server until it receives an interrupt
+// or a termination signal.
+func serve(configPath string) {
+	conf, err := config.Init(configPath)
+
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	err = applySettings(conf)
+
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	notifier, err := notify.Init(conf)
+
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	logFile, err := logging.Init(conf)
+
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	if logFile != nil {
+		defer func() {
+			_ = logFile.Close()
+		}()
+	}
+
+	if conf.Server.Mode == "debug" {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	e := gin.New()
+
+	if err := server.InitEngine(conf, e); err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	e.Use(gin.Logger())
+	e.Use(gin.Recovery())
+
+	db, err := database.Init(conf)
+
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	appCache, err := cache.Init(conf)
+
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	ds := datasource.Init(db, appCache)
+	stopHits := ds.StartHitsFlush(hitsFlushInterval)
+	stopHealthCheck := ds.StartHealthCheck(healthCheckInterval)
+
+	// Started before serving, so that the saved snapshot is loaded before any lookup
+	stopSnapshot := func() {}
+
+	if conf.Snapshot.Enabled {
+		stopSnapshot, err = startSnapshot(conf, ds)
+
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+	}
+
+	if appCache != nil && conf.Cache.WarmUpEnabled {
+		go warmUp(conf, ds)
+	}
+
+	auth.InitJwtSigningKey(conf)
+	auth.InitApiKeyPepper(conf)
+
+	// Creates the first admin once the password and API key settings are applied
+	err = bootstrap.Init(conf, db)
+
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	// Failures are shared between instances through Redis, and tracked in memory otherwise
+	if store, ok := appCache.(auth.FailureStore); ok {
+		auth.ApplyLoginSettings(conf, store)
+	} else {
+		auth.ApplyLoginSettings(conf, nil)
+	}
+
+	health.Init(conf, e, ds)
+	gopath.Init(conf, e, ds)
+	api.Init(conf, e, ds, notifier)
+
+	if conf.Server.HttpListenPort <= 0 && conf.Server.HttpsListenPort <= 0 {
+		log.Fatal().Err(err).Msg("no listening port configured")
+	}
+
+	s, tlsServer := server.Start(conf, e)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	sig := <-c
+
+	log.Info().Msgf("received %v", sig)
+	log.Info().Msgf("shutting down the http server")
+
+	if s != nil {
+		if err := s.Shutdown(context.Background()); err != nil {
+			log.Error().Err(err).Msg("error shutting down the http server")
+		}
+	}
+
+	if tlsServer != nil {
+		if err := tlsServer.Shutdown(context.Background()); err != nil {
+			log.Error().Err(err).Msg("error shutting down the http server")
+		}
+	}
+
+	stopHits()
+	stopHealthCheck()
+	stopSnapshot()
+
+	if closer, ok := appCache.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing the cache")
+		}
+	}
+}
+
+// startSnapshot starts refreshing the paths snapshot served when the database is unreachable. Returns a
+// data.ErrSettings if the snapshot is not configured.
+func startSnapshot(conf *config.Configuration, ds *datasource.DataSource) (func(), error) {
+	if conf.Snapshot.Path == "" || conf.Snapshot.RefreshIntervalSec < 0 {
+		return nil, fmt.Errorf("%w : %s", data.ErrSettings, "snapshot enabled, but not configured")
+	}
+
+	interval := defaultSnapshotInterval
+
+	if conf.Snapshot.RefreshIntervalSec > 0 {
+		interval = time.Second * time.Duration(conf.Snapshot.RefreshIntervalSec)
+	}
+
+	return ds.StartSnapshot(conf.Snapshot.Path, interval), nil
+}
+
+// warmUp preloads the most accessed paths, or all of them, into the cache.
+func warmUp(conf *config.Configuration, ds *datasource.DataSource) {
+	batchSize := conf.Cache.WarmUpBatchSize
+
+	if batchSize == 0 {
+		batchSize = defaultWarmUpBatchSize
+	}
+
+	start := time.Now()
+	n, err := ds.WarmUp(context.Background(), conf.Cache.WarmUpTopPaths, batchSize)
+
+	if err != nil {
+		log.Error().Err(err).Int("paths", n).Msg("error warming up the cache")
+		return
+	}
+
+	log.Info().Int("paths", n).Dur("duration", time.Since(start)).Msg("cache warmed up")
+}
@@ -0,0 +1,249 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-there/api"
+	"go-there/auth"
+	"go-there/data"
+	"strings"
+	"text/tabwriter"
+)
+
+// userCommand runs the user subcommand named by the first argument.
+func userCommand(e env, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w : missing user subcommand", errUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		return userCreate(e, args[1:])
+	case "list":
+		return userList(e, args[1:])
+	case "delete":
+		return userDelete(e, args[1:])
+	case "set-admin":
+		return userSetAdmin(e, args[1:])
+	case "reset-password":
+		return userResetPassword(e, args[1:])
+	case "rotate-key":
+		return userRotateKey(e, args[1:])
+	default:
+		return fmt.Errorf("%w : unknown user subcommand %s", errUsage, args[0])
+	}
+}
+
+// userCreate creates a user with the password read from the standard input, and prints his API key. The username,
+// password and email are validated with the same rules as the API.
+func userCreate(e env, args []string) error {
+	fs := newFlagSet(e, "user create", "<username>")
+	isAdmin := fs.Bool("admin", false, "Create the user as an admin")
+	email := fs.String("email", "", "Email of the user")
+
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	username := fs.Arg(0)
+
+	if !api.ValidateUsername(username) {
+		return errors.New("invalid username")
+	}
+
+	if *email != "" && !api.ValidateEmail(*email) {
+		return errors.New("invalid email")
+	}
+
+	password, err := readPassword(e)
+
+	if err != nil {
+		return err
+	}
+
+	if msg := api.CheckPassword(username, password); msg != "" {
+		return errors.New(msg)
+	}
+
+	hash, err := auth.GetHashFromPassword(password)
+
+	if err != nil {
+		return err
+	}
+
+	apiKey, apiKeyId, apiKeyHash, err := auth.GenerateApiKey()
+
+	if err != nil {
+		return err
+	}
+
+	err = e.ds.InsertUser(context.Background(), data.User{
+		Username:     username,
+		IsAdmin:      *isAdmin,
+		Email:        *email,
+		PasswordHash: hash,
+		ApiKeyId:     apiKeyId,
+		ApiKeyHash:   apiKeyHash,
+	})
+
+	if errors.Is(err, data.ErrSqlDuplicateRow) {
+		return fmt.Errorf("user %s already exists", username)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(e.stdout, apiKey)
+
+	return nil
+}
+
+// userList prints all the users as a table.
+func userList(e env, args []string) error {
+	fs := newFlagSet(e, "user list", "")
+
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	users, err := e.ds.SelectAllUsers(context.Background())
+
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(w, "USERNAME\tADMIN\tROLES\tDISABLED")
+
+	for _, u := range users {
+		_, _ = fmt.Fprintf(w, "%s\t%t\t%s\t%t\n", u.Username, u.IsAdmin, strings.Join(u.Roles, ","), u.Disabled)
+	}
+
+	return w.Flush()
+}
+
+// userDelete deletes a user and all his paths.
+func userDelete(e env, args []string) error {
+	fs := newFlagSet(e, "user delete", "<username>")
+
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	u, err := selectUser(e, fs.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	return e.ds.DeleteUser(context.Background(), u.Username)
+}
+
+// userSetAdmin grants the admin status to a user, or revokes it.
+func userSetAdmin(e env, args []string) error {
+	fs := newFlagSet(e, "user set-admin", "<username>")
+	revoke := fs.Bool("revoke", false, "Revoke the admin status instead of granting it")
+
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	u, err := selectUser(e, fs.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	u.IsAdmin = !*revoke
+
+	return e.ds.UpdateUserAdmin(context.Background(), u)
+}
+
+// userResetPassword replaces the password of a user by the one read from the standard input, then lifts any lockout.
+// The password must follow the user rules and the password policy, but the password history is not checked so that an
+// operator can always recover an account.
+func userResetPassword(e env, args []string) error {
+	fs := newFlagSet(e, "user reset-password", "<username>")
+
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	u, err := selectUser(e, fs.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	password, err := readPassword(e)
+
+	if err != nil {
+		return err
+	}
+
+	if msg := api.CheckPassword(u.Username, password); msg != "" {
+		return errors.New(msg)
+	}
+
+	u.PasswordHash, err = auth.GetHashFromPassword(password)
+
+	if err != nil {
+		return err
+	}
+
+	err = e.ds.UpdateUserPassword(context.Background(), u)
+
+	if err != nil {
+		return err
+	}
+
+	return auth.UnlockUser(context.Background(), u.Username)
+}
+
+// userRotateKey replaces the API key of a user and prints the new one. The previous key stops working immediately.
+func userRotateKey(e env, args []string) error {
+	fs := newFlagSet(e, "user rotate-key", "<username>")
+
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	u, err := selectUser(e, fs.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	apiKey, apiKeyId, apiKeyHash, err := auth.GenerateApiKey()
+
+	if err != nil {
+		return err
+	}
+
+	u.ApiKeyId = apiKeyId
+	u.ApiKeyHash = apiKeyHash
+
+	err = e.ds.UpdateUserApiKey(context.Background(), u)
+
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(e.stdout, apiKey)
+
+	return nil
+}
+
+// selectUser fetches a user by his username. Returns an error naming the user if he doesn't exist.
+func selectUser(e env, username string) (data.User, error) {
+	u, err := e.ds.SelectUserLogin(context.Background(), username)
+
+	if errors.Is(err, data.ErrSqlNoRow) {
+		return data.User{}, fmt.Errorf("user %s does not exist", username)
+	}
+
+	return u, err
+}
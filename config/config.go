@@ -9,12 +9,28 @@ import (
 
 // Configuration contains all the information needed to run the application.
 type Configuration struct {
-	Server    Server
-	Cache     Cache
-	Database  Database
-	Endpoints map[string]Endpoint
-	Logs      Logs
-	UserRules UserRules
+	Server          Server
+	Cache           Cache
+	Database        Database
+	Endpoints       map[string]Endpoint
+	Logs            Logs
+	UserRules       UserRules
+	PasswordPolicy  PasswordPolicy
+	Roles           map[string]Role
+	Login           Login
+	TwoFactor       TwoFactor
+	PasswordHashing PasswordHashing
+	Session         Session
+	Impersonation   Impersonation
+	Notifier        Notifier
+	PasswordReset   PasswordReset
+	BootstrapAdmin  BootstrapAdmin
+	Snapshot        Snapshot
+}
+
+// Role represents the permissions granted by a role.
+type Role struct {
+	Permissions []string
 }
 
 // Endpoint represents the configuration of each endpoint group.
@@ -27,16 +43,82 @@ type Endpoint struct {
 
 // Server represents the server configuration.
 type Server struct {
-	Mode              string
-	ListenAddress     string
-	HttpListenPort    int
-	HttpsListenPort   int
-	UseAutoCert       bool
-	Domains           []string
-	CertCache         string
-	CertPath          string
-	KeyPath           string
-	JwtSigningKeyPath string
+	Mode                string
+	ListenAddress       string
+	HttpListenPort      int
+	HttpsListenPort     int
+	UseAutoCert         bool
+	Domains             []string
+	CertCache           string
+	CertPath            string
+	KeyPath             string
+	JwtSigningKeyPath   string
+	JwtSigningAlgorithm string
+	ApiKeyPepperPath    string
+	ClientCaPath        string
+	ClientCertRequired  bool
+	ClientCertUserField string
+	TrustedProxies      []string
+}
+
+// Login represents the brute-force protection configuration of the password and API key authentication.
+type Login struct {
+	ProtectionEnabled bool
+	MaxUserFailures   int
+	MaxIpFailures     int
+	LockoutSec        int
+	BackoffBaseSec    int
+}
+
+// TwoFactor represents the TOTP two-factor authentication configuration.
+type TwoFactor struct {
+	Issuer           string
+	RequireForAdmins bool
+}
+
+// PasswordHashing represents the password hashing configuration. The argon2 memory is in KiB.
+type PasswordHashing struct {
+	Algorithm         string
+	BcryptCost        int
+	Argon2MemoryKiB   int
+	Argon2Time        int
+	Argon2Parallelism int
+}
+
+// Session represents the browser session cookies configuration.
+type Session struct {
+	TtlSec   int
+	SameSite string
+	Domain   string
+}
+
+// Impersonation represents the user impersonation configuration.
+type Impersonation struct {
+	Enabled     bool
+	AllowAdmins bool
+}
+
+// Notifier represents the configuration of the notifications sent to the users.
+type Notifier struct {
+	Type         string
+	SmtpAddress  string
+	SmtpPort     int
+	SmtpUser     string
+	SmtpPassword string
+	From         string
+}
+
+// PasswordReset represents the password reset tokens configuration.
+type PasswordReset struct {
+	TokenTtlSec int
+}
+
+// BootstrapAdmin represents the admin user created at startup if it doesn't exist. The password or API key are only
+// used at creation, and can be overridden by environment variables.
+type BootstrapAdmin struct {
+	Username string
+	Password string
+	ApiKey   string
 }
 
 // Cache represents the cache configuration.
@@ -50,18 +132,44 @@ type Cache struct {
 	LocalCacheEnabled bool
 	LocalCacheSize    int
 	LocalCacheTtlSec  int
+	TtlSec            int
+	MissingTtlSec     int
+	StaleTtlSec       int
+	WarmUpEnabled     bool
+	WarmUpTopPaths    int
+	WarmUpBatchSize   int
+	TimeoutMs         int
+}
+
+// Snapshot represents the configuration of the on-disk copy of the paths, served when the database is unreachable.
+type Snapshot struct {
+	Enabled            bool
+	Path               string
+	RefreshIntervalSec int
 }
 
 // Database represents the SQL database configuration.
 type Database struct {
-	Type     string
-	Address  string
-	Port     int
-	SslMode  bool
-	Protocol string
-	Name     string
-	User     string
-	Password string
+	Type                    string
+	Address                 string
+	Port                    int
+	SslMode                 bool
+	Protocol                string
+	Name                    string
+	User                    string
+	Password                string
+	Dsn                     string
+	CaPath                  string
+	Params                  map[string]string
+	QueryTimeoutMs          int
+	ConnectRetrySec         int
+	MaxOpenConns            int
+	MaxIdleConns            int
+	ConnMaxLifetimeSec      int
+	ConnMaxIdleTimeSec      int
+	ReplicaDsns             []string
+	ReplicaCheckIntervalSec int
+	ReplicaMaxLagSec        int
 }
 
 // Logs represents the logging configuration.
@@ -80,6 +188,17 @@ type UserRules struct {
 	PasswordMaxLen int
 }
 
+// PasswordPolicy represents the rules applied to new passwords in addition to the user rules.
+type PasswordPolicy struct {
+	MinLowercase     int
+	MinUppercase     int
+	MinDigits        int
+	MinSymbols       int
+	RejectUsername   bool
+	HistorySize      int
+	BreachedListPath string
+}
+
 // Init initialize the Configuration global variable, then tries to parse the provided configuration file. If an empty path is
 // provided, it tries to read go-there.conf in the binary directory.
 func Init(path string) (*Configuration, error) {
@@ -89,9 +208,28 @@ func Init(path string) (*Configuration, error) {
 		return nil, err
 	}
 
+	applyEnv(conf)
+
 	return conf, nil
 }
 
+// applyEnv overrides the bootstrap admin configuration with the GO_THERE_BOOTSTRAP_ADMIN_USERNAME,
+// GO_THERE_BOOTSTRAP_ADMIN_PASSWORD and GO_THERE_BOOTSTRAP_ADMIN_API_KEY environment variables, if they are set. They
+// keep the admin credentials out of the configuration file.
+func applyEnv(conf *Configuration) {
+	if v, ok := os.LookupEnv("GO_THERE_BOOTSTRAP_ADMIN_USERNAME"); ok {
+		conf.BootstrapAdmin.Username = v
+	}
+
+	if v, ok := os.LookupEnv("GO_THERE_BOOTSTRAP_ADMIN_PASSWORD"); ok {
+		conf.BootstrapAdmin.Password = v
+	}
+
+	if v, ok := os.LookupEnv("GO_THERE_BOOTSTRAP_ADMIN_API_KEY"); ok {
+		conf.BootstrapAdmin.ApiKey = v
+	}
+}
+
 // parseConfig parse a configuration file in toml format and unmarshals it into the conf global var. If an empty path is
 // provided, it tries to read go-there.conf in the binary directory. It returns an error if it cannot read or unmarshal
 // the configuration.
@@ -296,3 +296,19 @@ func TestInit(t *testing.T) {
 		})
 	}
 }
+
+func Test_applyEnv(t *testing.T) {
+	conf := &Configuration{BootstrapAdmin: BootstrapAdmin{Username: "admin", Password: "superpassword"}}
+
+	assert.Nil(t, os.Setenv("GO_THERE_BOOTSTRAP_ADMIN_PASSWORD", "envpassword"))
+	assert.Nil(t, os.Setenv("GO_THERE_BOOTSTRAP_ADMIN_API_KEY", "gt_key"))
+
+	defer func() {
+		_ = os.Unsetenv("GO_THERE_BOOTSTRAP_ADMIN_PASSWORD")
+		_ = os.Unsetenv("GO_THERE_BOOTSTRAP_ADMIN_API_KEY")
+	}()
+
+	applyEnv(conf)
+
+	assert.Equal(t, BootstrapAdmin{Username: "admin", Password: "envpassword", ApiKey: "gt_key"}, conf.BootstrapAdmin)
+}
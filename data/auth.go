@@ -5,8 +5,45 @@ import "time"
 // HeaderLogin represents the information given by a user in the header to authenticate. It should be used to unmarshal
 // incoming authentication data.
 type HeaderLogin struct {
-	XApiKey       string `header:"X-Api-Key"`
-	Authorization string `header:"Authorization"`
+	XApiKey          string `header:"X-Api-Key"`
+	Authorization    string `header:"Authorization"`
+	XImpersonateUser string `header:"X-Impersonate-User"`
+}
+
+// API key scopes. ScopeAll is granted to keys created without explicit scopes and is the only scope allowing to
+// exchange a key for a JWT.
+const (
+	ScopeAll        = "*"
+	ScopePathsRead  = "paths:read"
+	ScopePathsWrite = "paths:write"
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeUsersAdmin = "users:admin"
+)
+
+// Scopes contains all the valid API key scopes.
+var Scopes = []string{ScopeAll, ScopePathsRead, ScopePathsWrite, ScopeUsersRead, ScopeUsersWrite, ScopeUsersAdmin}
+
+// Role permissions. They allow a user to access the resources of other users, or admin only endpoints. PermAll grants
+// every permission.
+const (
+	PermAll              = "*"
+	PermUsersList        = "users:list"
+	PermUsersCreate      = "users:create"
+	PermUsersRead        = "users:read"
+	PermUsersUpdate      = "users:update"
+	PermUsersDelete      = "users:delete"
+	PermUsersKeys        = "users:keys"
+	PermUsersRoles       = "users:roles"
+	PermUsersImpersonate = "users:impersonate"
+	PermPathsCreate      = "paths:create"
+	PermPathsDelete      = "paths:delete"
+)
+
+// Permissions contains all the valid role permissions.
+var Permissions = []string{
+	PermAll, PermUsersList, PermUsersCreate, PermUsersRead, PermUsersUpdate, PermUsersDelete, PermUsersKeys, PermUsersRoles,
+	PermUsersImpersonate, PermPathsCreate, PermPathsDelete,
 }
 
 // B64AuthToken is the b64 form of a data.AuthToken.
@@ -21,9 +58,13 @@ type LoginData struct {
 	JwtLogin
 }
 
+// Authentication types
 const (
 	Basic = iota
 	Jwt
+	ApiKeyAuth
+	SessionAuth
+	ClientCertAuth
 )
 
 // BasicAuthLogin is used to store the username:password from a basic authentication.
@@ -32,10 +73,11 @@ type BasicAuthLogin struct {
 	Password string
 }
 
-// JwtLogin contains the values extracted from a JWT token.
+// JwtLogin contains the values extracted from a JWT token. Actor is the real user of an impersonation token.
 type JwtLogin struct {
 	ExpiresAt time.Time
 	User      User
+	Actor     string
 }
 
 // IsExpired returns true if the JWT is expired.
@@ -15,6 +15,7 @@ var (
 	ErrInvalidKey  = errors.New("auth: invalid api key")
 	ErrInvalidJwt  = errors.New("auth: invalid JWT")
 	ErrInvalidAuth = errors.New("auth: invalid authentication")
+	ErrInvalidOtp  = errors.New("auth: invalid one-time password")
 )
 
 // Init errors
@@ -1,10 +1,18 @@
 package data
 
-// UserInfo contains the name and redirections created by an user.
+import "time"
+
+// UserInfo contains the name and redirections created by an user, and whether he has been disabled.
 type UserInfo struct {
-	Username string     `db:"username" json:"username"`
-	IsAdmin  bool       `db:"is_admin" json:"is_admin"`
-	Paths    []PathInfo `json:"paths,omitempty"`
+	Username       string     `db:"username" json:"username"`
+	IsAdmin        bool       `db:"is_admin" json:"is_admin"`
+	Roles          []string   `json:"roles"`
+	Email          string     `db:"email" json:"email,omitempty"`
+	Disabled       bool       `db:"disabled" json:"disabled"`
+	DisabledReason string     `db:"disabled_reason" json:"disabled_reason,omitempty"`
+	DisabledUntil  *time.Time `db:"disabled_until" json:"disabled_until,omitempty"`
+	DisableLinks   bool       `db:"disable_links" json:"disable_links,omitempty"`
+	Paths          []PathInfo `json:"paths,omitempty"`
 }
 
 // PathInfo contains the pair Path/Target.
@@ -25,14 +33,51 @@ type DeletePath struct {
 }
 
 // CreateUser represents the information given by a user to create another user. It should be used to unmarshal incoming
-// creation data.
+// creation data. The email is optional, and only used to send password reset tokens.
 type CreateUser struct {
 	CreateUser     string `json:"create_user" binding:"required"`
 	CreatePassword string `json:"create_password" binding:"required"`
+	CreateEmail    string `json:"create_email"`
 }
 
-// PatchUser represents the input used to change a user password or request a new API key.
+// PatchUser represents the input used to change a user password or email, request a new API key or disable a user. The
+// disabled state is left unchanged if Disabled is nil. A disabled user is suspended until DisabledUntil if it is not nil, and
+// his links stop redirecting if DisableLinks is set.
 type PatchUser struct {
-	PatchPassword string `json:"new_password"`
-	PatchApiKey   bool   `json:"new_api_key"`
+	PatchPassword  string     `json:"new_password"`
+	PatchApiKey    bool       `json:"new_api_key"`
+	PatchEmail     string     `json:"new_email"`
+	Disabled       *bool      `json:"disabled"`
+	DisabledReason string     `json:"disabled_reason"`
+	DisabledUntil  *time.Time `json:"disabled_until"`
+	DisableLinks   bool       `json:"disable_links"`
+}
+
+// CreateApiKey represents the input used to create a new named API key. If no scope is provided, the key is granted all
+// scopes. If ExpiresAt is nil, the key never expires.
+type CreateApiKey struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// SetRoles represents the input used to replace the roles of a user.
+type SetRoles struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// RequestPasswordReset represents the input used by a user to receive a password reset token.
+type RequestPasswordReset struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// PasswordReset represents the input used to change a user password with a password reset token.
+type PasswordReset struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// OtpCode represents a TOTP or recovery code sent by a user.
+type OtpCode struct {
+	Code string `json:"code" binding:"required"`
 }
@@ -1,13 +1,78 @@
 package data
 
+import (
+	"strings"
+	"time"
+)
+
 // User contains all the information representing an user internally. It should NOT be used to marshal/unmarshal
 // incoming or outgoing data.
 type User struct {
-	Id           int    `db:"id" json:"id"`
-	Username     string `db:"username" json:"username"`
-	IsAdmin      bool   `db:"is_admin" json:"is_admin"`
-	PasswordHash []byte `db:"password_hash" json:"password_hash,omitempty"`
-	ApiKeyHash   []byte `db:"api_key_hash" json:"api_key_hash,omitempty"`
+	Id             int        `db:"id" json:"id"`
+	Username       string     `db:"username" json:"username"`
+	IsAdmin        bool       `db:"is_admin" json:"is_admin"`
+	PasswordHash   []byte     `db:"password_hash" json:"password_hash,omitempty"`
+	ApiKeyId       string     `db:"api_key_id" json:"api_key_id,omitempty"`
+	ApiKeyHash     []byte     `db:"api_key_hash" json:"api_key_hash,omitempty"`
+	Roles          string     `db:"roles" json:"roles"`
+	Email          string     `db:"email" json:"email,omitempty"`
+	TotpSecret     string     `db:"totp_secret" json:"totp_secret,omitempty"`
+	TotpEnabled    bool       `db:"totp_enabled" json:"totp_enabled"`
+	TotpLastStep   int64      `db:"totp_last_step" json:"totp_last_step"`
+	Disabled       bool       `db:"disabled" json:"disabled"`
+	DisabledReason string     `db:"disabled_reason" json:"disabled_reason,omitempty"`
+	DisabledUntil  *time.Time `db:"disabled_until" json:"disabled_until,omitempty"`
+	DisableLinks   bool       `db:"disable_links" json:"disable_links"`
+}
+
+// IsDisabled returns true if the user has been disabled, and the suspension has no expiration date or it is in the
+// future.
+func (u User) IsDisabled() bool {
+	return u.Disabled && (u.DisabledUntil == nil || time.Now().UTC().Before(*u.DisabledUntil))
+}
+
+// RoleList returns the roles of the user as a slice.
+func (u User) RoleList() []string {
+	return SplitList(u.Roles)
+}
+
+// SplitList splits a comma separated list. It returns an empty slice if the list is empty.
+func SplitList(list string) []string {
+	if list == "" {
+		return []string{}
+	}
+
+	return strings.Split(list, ",")
+}
+
+// ApiKey contains the information representing a named API key internally. Scopes is a comma separated list of the
+// scopes granted to the key. KeyId is empty for keys created in the legacy format.
+type ApiKey struct {
+	Id         int        `db:"id"`
+	UserId     int        `db:"user_id"`
+	Name       string     `db:"name"`
+	KeyId      string     `db:"key_id"`
+	KeyHash    []byte     `db:"key_hash"`
+	Scopes     string     `db:"scopes"`
+	ExpiresAt  *time.Time `db:"expires_at"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	CreatedAt  time.Time  `db:"created_at"`
+}
+
+// HasScope returns true if the key has been granted the provided scope, or all scopes.
+func (ak ApiKey) HasScope(scope string) bool {
+	for _, s := range strings.Split(ak.Scopes, ",") {
+		if s == ScopeAll || s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsExpired returns true if the key has an expiration date in the past.
+func (ak ApiKey) IsExpired() bool {
+	return ak.ExpiresAt != nil && time.Now().UTC().After(*ak.ExpiresAt)
 }
 
 // Path contains the information representing a redirection target internally.
@@ -17,11 +82,35 @@ type Path struct {
 	UserId int    `db:"user_id"`
 }
 
+// OwnedPath contains a path, its target, the username of the user who created it and when it was created and last
+// changed.
+type OwnedPath struct {
+	Path      string    `db:"path"`
+	Target    string    `db:"target"`
+	Username  string    `db:"username"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// DuplicatePath contains a path stored in several rows, which prevents the paths from being unique.
+type DuplicatePath struct {
+	Path  string `db:"path"`
+	Count int    `db:"count"`
+}
+
+// LoginFailures represents the consecutive authentication failures of a user or an IP address, and the time of the
+// last one, from which the next authentication is delayed.
+type LoginFailures struct {
+	Failures    int
+	LastFailure time.Time
+}
+
 // LogInfo represents the data logged when a user makes a request.
 type LogInfo struct {
 	Method   string `json:"method"`
 	Endpoint string `json:"endpoint"`
 	User     string `json:"user"`
+	Actor    string `json:"actor,omitempty"`
 	Ip       string `json:"ip"`
 	HttpCode int    `json:"http_code"`
 }
@@ -1,16 +1,61 @@
 package data
 
+import "time"
+
 // ApiKeyResponse should be returned when creating a user or regenerating an API key.
 type ApiKeyResponse struct {
 	ApiKey string `json:"api_key,omitempty"`
 }
 
+// ApiKeyInfo contains the public information of a named API key.
+type ApiKeyInfo struct {
+	Name       string     `json:"name"`
+	KeyId      string     `json:"key_id,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateApiKeyResponse should be returned when creating a named API key. The key is only returned once.
+type CreateApiKeyResponse struct {
+	ApiKeyInfo
+	ApiKey string `json:"api_key"`
+}
+
+// SessionResponse should be returned when creating a session. The CSRF token must be sent back in the X-Csrf-Token
+// header of state-changing requests.
+type SessionResponse struct {
+	CsrfToken string `json:"csrf_token"`
+}
+
 // JwtResponse should be returned when querying the auth endpoint.
 type JwtResponse struct {
 	Jwt string `json:"jwt,omitempty"`
 }
 
+// TotpEnrollResponse should be returned when enrolling a TOTP secret. QrCodePng is a b64 encoded PNG image of the
+// provisioning URI.
+type TotpEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningUri string `json:"provisioning_uri"`
+	QrCodePng       string `json:"qr_code_png"`
+}
+
+// RecoveryCodesResponse should be returned when the two-factor authentication is enabled. The codes are only returned
+// once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
 // ErrorResponse should be returned to the user when additional context is needed when an error occurs.
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
+
+// HealthResponse should be returned when querying the health endpoint. DegradedSince is set when the database is
+// unreachable.
+type HealthResponse struct {
+	Status        string     `json:"status"`
+	DegradedSince *time.Time `json:"degraded_since,omitempty"`
+}
@@ -1,6 +1,9 @@
 package database
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -11,89 +14,347 @@ import (
 	"github.com/rs/zerolog/log"
 	"go-there/config"
 	"go-there/data"
+	"io/ioutil"
+	"time"
 )
 
 // DataBase represents the database containing the application's data.
 type DataBase struct {
-	db *sqlx.DB
+	db       executor
+	pool     *sqlx.DB
+	replicas *replicaSet
+	timeout  time.Duration
 }
 
-// Init initializes and tries to connect to the database defined in the configuration. If it cannot connect, an error is
-// returned.
+// executor runs the queries. It is implemented by *sqlx.DB and *sqlx.Tx, so that the same operations can run inside or
+// outside a transaction.
+type executor interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+}
+
+// Maximum duration of a database operation, if not configured
+const defaultQueryTimeout = 5 * time.Second
+
+// Duration during which the connection is retried at startup if not configured, and the bounds of the wait between two
+// attempts
+const (
+	defaultConnectRetry = time.Minute
+	connectBackoffBase  = 500 * time.Millisecond
+	connectBackoffMax   = 10 * time.Second
+)
+
+// Name under which the TLS configuration using the configured CA is registered in the MySQL driver
+const tlsConfigName = "go-there"
+
+// Init initializes and tries to connect to the database defined in the configuration. The connection is retried with a
+// backoff during the configured duration, as the database may still be starting. If it cannot connect, an error is
+// returned. Each operation is canceled when its context is, or after the configured query timeout. The configured
+// replicas are opened too, without waiting for them to be reachable. Returns a data.ErrSettings if the configuration is
+// invalid.
 func Init(config *config.Configuration) (*DataBase, error) {
+	conf := config.Database
+
+	if conf.QueryTimeoutMs < 0 || conf.MaxOpenConns < 0 || conf.MaxIdleConns < 0 || conf.ConnMaxLifetimeSec < 0 ||
+		conf.ConnMaxIdleTimeSec < 0 || conf.ReplicaCheckIntervalSec < 0 || conf.ReplicaMaxLagSec < 0 {
+		return nil, fmt.Errorf("%w : %s", data.ErrSettings, "invalid database settings")
+	}
+
 	ds, err := connect(config, config.Database.Type)
 
 	if err != nil {
 		return nil, err
 	}
 
+	applyPoolSettings(ds.pool, conf)
+
+	ds.timeout = defaultQueryTimeout
+
+	if conf.QueryTimeoutMs > 0 {
+		ds.timeout = time.Millisecond * time.Duration(conf.QueryTimeoutMs)
+	}
+
+	interval := defaultReplicaCheckInterval
+
+	if conf.ReplicaCheckIntervalSec > 0 {
+		interval = time.Second * time.Duration(conf.ReplicaCheckIntervalSec)
+	}
+
+	maxLag := defaultReplicaMaxLag
+
+	if conf.ReplicaMaxLagSec > 0 {
+		maxLag = time.Second * time.Duration(conf.ReplicaMaxLagSec)
+	}
+
+	ds.replicas, err = openReplicas(conf, interval, ds.timeout, maxLag)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return ds, nil
 }
 
-// connect tries to connect to a database with the specified parameters. Returns data.ErrSql if it fails.
+// withTimeout returns a context derived from ctx, canceled when the database operation takes too long. The returned
+// function must be called once the operation is done.
+func (ds *DataBase) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, ds.timeout)
+}
+
+// Ping checks that the primary database is reachable. Returns a data.ErrSql if it is not.
+func (ds *DataBase) Ping(ctx context.Context) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	if err := ds.pool.PingContext(ctx); err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// connect tries to connect to a database with the specified parameters, until it succeeds or the configured retry
+// duration expires. Returns data.ErrSql if it fails, or data.ErrSettings if the connection settings are invalid.
 func connect(config *config.Configuration, dbType string) (*DataBase, error) {
 	var err error
-	ds := new(DataBase)
+	var dsn string
 
 	switch dbType {
 	case "mysql":
-		ds.db, err = sqlx.Connect(
-			dbType,
-			fmt.Sprintf(
-				"%s:%s@%s(%s:%d)/%s",
-				config.Database.User,
-				config.Database.Password,
-				config.Database.Protocol,
-				config.Database.Address,
-				config.Database.Port,
-				config.Database.Name,
-			),
-		)
+		dsn, err = mysqlDsn(config.Database)
+
+		if err != nil {
+			return nil, err
+		}
 	case "postgres":
 		// TODO
 		log.Fatal().Err(errors.New("not implemented"))
-		ds.db, err = sqlx.Connect(
-			dbType,
-			fmt.Sprintf(
-				"user=%s password=%s host=%s port=%d name=%s sslmode=%s",
-				config.Database.User,
-				config.Database.Password,
-				config.Database.Address,
-				config.Database.Port,
-				config.Database.Name,
-				func() string {
-					if config.Database.SslMode {
-						return "enable"
-					} else {
-						return "disable"
-					}
-				}(),
-			),
+		dsn = fmt.Sprintf(
+			"user=%s password=%s host=%s port=%d name=%s sslmode=%s",
+			config.Database.User,
+			config.Database.Password,
+			config.Database.Address,
+			config.Database.Port,
+			config.Database.Name,
+			func() string {
+				if config.Database.SslMode {
+					return "enable"
+				} else {
+					return "disable"
+				}
+			}(),
 		)
 	default:
 		return nil, fmt.Errorf("%w : %s", data.ErrSql, "invalid sql type")
 	}
 
+	retry := defaultConnectRetry
+
+	if config.Database.ConnectRetrySec != 0 {
+		retry = time.Second * time.Duration(config.Database.ConnectRetrySec)
+	}
+
+	db, err := connectWithRetry(dbType, dsn, retry)
+
 	if err != nil {
-		return ds, fmt.Errorf("%w : %s", data.ErrSql, err)
+		return nil, fmt.Errorf("%w : %s", data.ErrSql, err)
 	}
 
-	return ds, nil
+	return &DataBase{db: db, pool: db}, nil
 }
 
-// SelectUser fetches a user with all the paths he created. Returns a data.ErrSql if it fails.
-func (ds *DataBase) SelectUser(username string) (data.UserInfo, error) {
-	result, err := ds.db.Queryx(ds.db.Rebind("SELECT users.username,users.is_admin,go.path,go.target FROM users INNER JOIN go ON users.id=go.user_id WHERE username=?"), username)
+// InTx runs fn in a transaction: all the operations of the DataBase passed to fn are part of it, and are sent to the
+// primary. The transaction is committed if fn returns nil, and rolled back otherwise, in which case the error of fn is
+// returned. If ds is already a transaction, fn is part of it. Returns a data.ErrSql if the transaction cannot be
+// started or committed.
+// Logs a warning if the rollback fails.
+func (ds *DataBase) InTx(ctx context.Context, fn func(tx *DataBase) error) error {
+	if ds.pool == nil {
+		return fn(ds)
+	}
+
+	tx, err := ds.pool.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	done := false
+
+	// Also rolls back if fn panics
+	defer func() {
+		if done {
+			return
+		}
+
+		if err := tx.Rollback(); err != nil {
+			log.Warn().Err(err).Msg("error rolling back a transaction")
+		}
+	}()
+
+	err = fn(&DataBase{db: tx, timeout: ds.timeout})
+
+	if err != nil {
+		return err
+	}
+
+	done = true
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// connectWithRetry connects to the database, then retries with an exponential backoff until it succeeds or the retry
+// duration expires. It is only tried once if retry is negative.
+// Logs a warning for each failed attempt.
+func connectWithRetry(driver string, dsn string, retry time.Duration) (*sqlx.DB, error) {
+	deadline := time.Now().Add(retry)
+	wait := connectBackoffBase
+
+	for {
+		db, err := sqlx.Connect(driver, dsn)
+
+		if err == nil {
+			return db, nil
+		}
+
+		if time.Now().Add(wait).After(deadline) {
+			return nil, err
+		}
+
+		log.Warn().Err(err).Dur("retry_in", wait).Msg("cannot connect to the database, retrying")
+		time.Sleep(wait)
+
+		wait *= 2
+
+		if wait > connectBackoffMax {
+			wait = connectBackoffMax
+		}
+	}
+}
+
+// mysqlDsn returns the MySQL DSN matching the configuration. The full DSN is used if it is set, instead of the
+// connection fields. The times are always parsed, and the params are added to the DSN, overriding its own params.
+// SslMode enables TLS, verified with the CA of CaPath if it is set. Returns a data.ErrSettings if the DSN or the CA is
+// invalid.
+func mysqlDsn(conf config.Database) (string, error) {
+	cfg := mysql.NewConfig()
+
+	if conf.Dsn != "" {
+		var err error
+		cfg, err = mysql.ParseDSN(conf.Dsn)
+
+		if err != nil {
+			return "", fmt.Errorf("%w : invalid database dsn : %s", data.ErrSettings, err)
+		}
+	} else {
+		cfg.User = conf.User
+		cfg.Passwd = conf.Password
+		cfg.Net = conf.Protocol
+		cfg.Addr = fmt.Sprintf("%s:%d", conf.Address, conf.Port)
+		cfg.DBName = conf.Name
+	}
+
+	cfg.ParseTime = true
+
+	if conf.CaPath != "" {
+		pem, err := ioutil.ReadFile(conf.CaPath)
+
+		if err != nil {
+			return "", fmt.Errorf("%w : cannot read the database CA : %s", data.ErrSettings, err)
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("%w : %s", data.ErrSettings, "no certificate found in the database CA")
+		}
+
+		err = mysql.RegisterTLSConfig(tlsConfigName, &tls.Config{RootCAs: pool})
+
+		if err != nil {
+			return "", fmt.Errorf("%w : %s", data.ErrSettings, err)
+		}
+
+		cfg.TLSConfig = tlsConfigName
+	} else if conf.SslMode {
+		cfg.TLSConfig = "true"
+	}
+
+	if len(conf.Params) > 0 && cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+
+	for k, v := range conf.Params {
+		cfg.Params[k] = v
+	}
+
+	return cfg.FormatDSN(), nil
+}
+
+// applyPoolSettings applies the configured connection pool settings. The database/sql defaults are kept for the unset
+// ones.
+func applyPoolSettings(db *sqlx.DB, conf config.Database) {
+	if conf.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(conf.MaxOpenConns)
+	}
+
+	if conf.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(conf.MaxIdleConns)
+	}
+
+	if conf.ConnMaxLifetimeSec > 0 {
+		db.SetConnMaxLifetime(time.Second * time.Duration(conf.ConnMaxLifetimeSec))
+	}
+
+	if conf.ConnMaxIdleTimeSec > 0 {
+		db.SetConnMaxIdleTime(time.Second * time.Duration(conf.ConnMaxIdleTimeSec))
+	}
+}
+
+// SelectUser fetches a user with all the paths he created, from a replica if any is healthy. Returns a data.ErrSql if
+// it fails.
+func (ds *DataBase) SelectUser(ctx context.Context, username string) (data.UserInfo, error) {
+	var ui data.UserInfo
+
+	err := ds.read(ctx, false, func(ctx context.Context, db executor) error {
+		var err error
+		ui, err = selectUser(ctx, db, username)
+
+		return err
+	})
+
+	return ui, err
+}
+
+// selectUser fetches a user with all the paths he created from db. Returns a data.ErrSql if it fails.
+func selectUser(ctx context.Context, db executor, username string) (data.UserInfo, error) {
+	result, err := db.QueryxContext(ctx, db.Rebind("SELECT users.username,users.is_admin,users.roles,users.email,users.disabled,"+
+		"users.disabled_reason,users.disabled_until,users.disable_links,go.path,go.target FROM users INNER JOIN go ON users.id=go.user_id "+
+		"WHERE username=?"), username)
 
 	if err != nil {
 		return data.UserInfo{}, fmt.Errorf("%w : %s", data.ErrSql, err)
 	}
 
+	defer result.Close()
+
 	type Row struct {
-		Username string `db:"username"`
-		IsAdmin  bool   `db:"is_admin"`
-		Path     string `db:"path"`
-		Target   string `db:"target"`
+		Username       string     `db:"username"`
+		IsAdmin        bool       `db:"is_admin"`
+		Roles          string     `db:"roles"`
+		Email          string     `db:"email"`
+		Disabled       bool       `db:"disabled"`
+		DisabledReason string     `db:"disabled_reason"`
+		DisabledUntil  *time.Time `db:"disabled_until"`
+		DisableLinks   bool       `db:"disable_links"`
+		Path           string     `db:"path"`
+		Target         string     `db:"target"`
 	}
 
 	ui := data.UserInfo{}
@@ -110,25 +371,59 @@ func (ds *DataBase) SelectUser(username string) (data.UserInfo, error) {
 		if ui.Username == "" {
 			ui.Username = r.Username
 			ui.IsAdmin = r.IsAdmin
+			ui.Roles = data.SplitList(r.Roles)
+			ui.Email = r.Email
+			ui.Disabled = r.Disabled
+			ui.DisabledReason = r.DisabledReason
+			ui.DisabledUntil = r.DisabledUntil
+			ui.DisableLinks = r.DisableLinks
 		}
 
 		ui.Paths = append(ui.Paths, data.PathInfo{Path: r.Path, Target: r.Target})
 	}
 
+	// Set when the query is canceled or fails while the rows are read, which would otherwise return a partial list
+	if err := result.Err(); err != nil {
+		return data.UserInfo{}, fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
 	return ui, nil
 }
 
-// SelectAllUsers fetches the complete list of all users. Returns a data.ErrSql if it fails.
-func (ds *DataBase) SelectAllUsers() ([]data.UserInfo, error) {
-	result, err := ds.db.Queryx("SELECT users.username,users.is_admin FROM users")
+// SelectAllUsers fetches the complete list of all users, from a replica if any is healthy. Returns a data.ErrSql if it
+// fails.
+func (ds *DataBase) SelectAllUsers(ctx context.Context) ([]data.UserInfo, error) {
+	var ui []data.UserInfo
+
+	err := ds.read(ctx, false, func(ctx context.Context, db executor) error {
+		var err error
+		ui, err = selectAllUsers(ctx, db)
+
+		return err
+	})
+
+	return ui, err
+}
+
+// selectAllUsers fetches the complete list of all users from db. Returns a data.ErrSql if it fails.
+func selectAllUsers(ctx context.Context, db executor) ([]data.UserInfo, error) {
+	result, err := db.QueryxContext(ctx, "SELECT users.username,users.is_admin,users.roles,users.disabled,users.disabled_reason,"+
+		"users.disabled_until,users.disable_links FROM users")
 
 	if err != nil {
 		return nil, fmt.Errorf("%w : %s", data.ErrSql, err)
 	}
 
+	defer result.Close()
+
 	type Row struct {
-		Username string `db:"username"`
-		IsAdmin  bool   `db:"is_admin"`
+		Username       string     `db:"username"`
+		IsAdmin        bool       `db:"is_admin"`
+		Roles          string     `db:"roles"`
+		Disabled       bool       `db:"disabled"`
+		DisabledReason string     `db:"disabled_reason"`
+		DisabledUntil  *time.Time `db:"disabled_until"`
+		DisableLinks   bool       `db:"disable_links"`
 	}
 
 	ui := make([]data.UserInfo, 0)
@@ -141,20 +436,43 @@ func (ds *DataBase) SelectAllUsers() ([]data.UserInfo, error) {
 			return nil, fmt.Errorf("%w : %s", data.ErrSql, err)
 		}
 
-		ui = append(ui, data.UserInfo{Username: r.Username, IsAdmin: r.IsAdmin})
+		ui = append(ui, data.UserInfo{
+			Username:       r.Username,
+			IsAdmin:        r.IsAdmin,
+			Roles:          data.SplitList(r.Roles),
+			Disabled:       r.Disabled,
+			DisabledReason: r.DisabledReason,
+			DisabledUntil:  r.DisabledUntil,
+			DisableLinks:   r.DisableLinks,
+		})
+	}
+
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("%w : %s", data.ErrSql, err)
 	}
 
 	return ui, nil
 }
 
 // SelectUserLogin fetches the id,username,is_admin,password_hash of a user by his username in the database. Returns a
-// data.ErrSql if it fails.
-func (ds *DataBase) SelectUserLogin(username string) (data.User, error) {
+// data.ErrSqlNoRow if the user doesn't exist or data.ErrSql if it fails.
+func (ds *DataBase) SelectUserLogin(ctx context.Context, username string) (data.User, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
 	u := data.User{}
-	err := ds.db.Get(&u, ds.db.Rebind("SELECT id,username,is_admin,password_hash FROM users WHERE username=?"), username)
+	err := ds.db.GetContext(ctx, &u, ds.db.Rebind(
+		"SELECT id,username,is_admin,roles,email,password_hash,totp_secret,totp_enabled,totp_last_step,disabled,"+
+			"disabled_until FROM users WHERE username=?"),
+		username)
 
 	if err != nil {
-		return data.User{}, fmt.Errorf("%w : %s", data.ErrSql, err)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return data.User{}, data.ErrSqlNoRow
+		default:
+			return data.User{}, fmt.Errorf("%w : %s", data.ErrSql, err)
+		}
 	}
 
 	return u, nil
@@ -162,9 +480,12 @@ func (ds *DataBase) SelectUserLogin(username string) (data.User, error) {
 
 // SelectApiKeyHashByUser fetches a full API key hash from the database by a username. Returns a data.ErrSql if it
 // fails.
-func (ds *DataBase) SelectApiKeyHashByUser(username string) ([]byte, error) {
+func (ds *DataBase) SelectApiKeyHashByUser(ctx context.Context, username string) ([]byte, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
 	ak := make([]byte, 0)
-	err := ds.db.Get(&ak, ds.db.Rebind("SELECT api_key_hash FROM users WHERE username=?"), username)
+	err := ds.db.GetContext(ctx, &ak, ds.db.Rebind("SELECT api_key_hash FROM users WHERE username=?"), username)
 
 	if err != nil {
 		return []byte{}, fmt.Errorf("%w : %s", data.ErrSql, err)
@@ -173,42 +494,204 @@ func (ds *DataBase) SelectApiKeyHashByUser(username string) ([]byte, error) {
 	return ak, nil
 }
 
-// SelectUserLoginByApiKeyHash fetches the id,username,is_admin of a user, by his API key hash.
-func (ds *DataBase) SelectUserLoginByApiKeyHash(apiKeyHash string) (data.User, error) {
+// SelectUserLoginByApiKeyHash fetches the id,username,is_admin of a user, by his API key hash. Returns a
+// data.ErrSqlNoRow if no user has this key or data.ErrSql if it fails.
+func (ds *DataBase) SelectUserLoginByApiKeyHash(ctx context.Context, apiKeyHash string) (data.User, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
 	u := data.User{}
-	err := ds.db.Get(&u, ds.db.Rebind("SELECT id,username,is_admin,api_key_hash FROM users WHERE api_key_hash=?"), apiKeyHash)
+	err := ds.db.GetContext(ctx, &u, ds.db.Rebind("SELECT id,username,is_admin,roles,api_key_hash,disabled,disabled_until FROM users WHERE api_key_hash=?"), apiKeyHash)
 
 	if err != nil {
-		return data.User{}, fmt.Errorf("%w : %s", data.ErrSql, err)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return data.User{}, data.ErrSqlNoRow
+		default:
+			return data.User{}, fmt.Errorf("%w : %s", data.ErrSql, err)
+		}
 	}
 
 	return u, nil
 }
 
-// InsertUser tries to add a new user to the database. If a user with the same name exists,
+// SelectUserLoginByApiKeyId fetches the id,username,is_admin of a user, by his API key id. Returns a data.ErrSqlNoRow
+// if no user has this key or data.ErrSql if it fails.
+func (ds *DataBase) SelectUserLoginByApiKeyId(ctx context.Context, keyId string) (data.User, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	u := data.User{}
+	err := ds.db.GetContext(ctx, &u, ds.db.Rebind("SELECT id,username,is_admin,roles,api_key_id,api_key_hash,disabled,disabled_until FROM users WHERE api_key_id=?"), keyId)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return data.User{}, data.ErrSqlNoRow
+		default:
+			return data.User{}, fmt.Errorf("%w : %s", data.ErrSql, err)
+		}
+	}
+
+	return u, nil
+}
+
+// SelectApiKeyLogin fetches a named API key in the legacy format and the id,username,is_admin of its owner by the key
+// hash. Returns a data.ErrSqlNoRow if the key doesn't exist or data.ErrSql if it fails.
+func (ds *DataBase) SelectApiKeyLogin(ctx context.Context, keyHash string) (data.User, data.ApiKey, error) {
+	return ds.selectApiKeyLogin(ctx, "api_keys.key_hash", keyHash)
+}
+
+// SelectApiKeyLoginById fetches a named API key and the id,username,is_admin of its owner by the key id. Returns a
+// data.ErrSqlNoRow if the key doesn't exist or data.ErrSql if it fails.
+func (ds *DataBase) SelectApiKeyLoginById(ctx context.Context, keyId string) (data.User, data.ApiKey, error) {
+	return ds.selectApiKeyLogin(ctx, "api_keys.key_id", keyId)
+}
+
+// selectApiKeyLogin fetches a named API key and the id,username,is_admin of its owner where the column matches the
+// value. The column must never come from an user input.
+func (ds *DataBase) selectApiKeyLogin(ctx context.Context, column string, value string) (data.User, data.ApiKey, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	type Row struct {
+		data.ApiKey
+		Username      string     `db:"username"`
+		IsAdmin       bool       `db:"is_admin"`
+		Roles         string     `db:"roles"`
+		Disabled      bool       `db:"disabled"`
+		DisabledUntil *time.Time `db:"disabled_until"`
+	}
+
+	r := Row{}
+	err := ds.db.GetContext(ctx, &r, ds.db.Rebind(
+		"SELECT api_keys.id,api_keys.user_id,api_keys.name,COALESCE(api_keys.key_id,'') AS key_id,api_keys.key_hash,"+
+			"api_keys.scopes,api_keys.expires_at,api_keys.last_used_at,api_keys.created_at,users.username,"+
+			"users.is_admin,users.roles,users.disabled,users.disabled_until FROM api_keys INNER JOIN users ON users.id=api_keys.user_id WHERE "+column+"=?"),
+		value)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return data.User{}, data.ApiKey{}, data.ErrSqlNoRow
+		default:
+			return data.User{}, data.ApiKey{}, fmt.Errorf("%w : %s", data.ErrSql, err)
+		}
+	}
+
+	u := data.User{
+		Id:            r.UserId,
+		Username:      r.Username,
+		IsAdmin:       r.IsAdmin,
+		Roles:         r.Roles,
+		Disabled:      r.Disabled,
+		DisabledUntil: r.DisabledUntil,
+	}
+
+	return u, r.ApiKey, nil
+}
+
+// SelectApiKeys fetches all the named API keys of a user. Returns a data.ErrSql if it fails.
+func (ds *DataBase) SelectApiKeys(ctx context.Context, userId int) ([]data.ApiKey, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	keys := make([]data.ApiKey, 0)
+	err := ds.db.SelectContext(ctx, &keys, ds.db.Rebind(
+		"SELECT id,user_id,name,COALESCE(key_id,'') AS key_id,key_hash,scopes,expires_at,last_used_at,created_at "+
+			"FROM api_keys WHERE user_id=? "+
+			"ORDER BY name"), userId)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return keys, nil
+}
+
+// InsertApiKey adds a named API key to the database. If the user already has a key with the same name,
 // data.ErrSqlDuplicateRow is returned.
-func (ds *DataBase) InsertUser(user data.User) error {
-	_, err := ds.db.NamedExec(
-		"INSERT INTO users (username,is_admin,password_hash,api_key_hash) "+
-			"VALUES (:username,:is_admin,:password_hash,:api_key_hash)", user)
+func (ds *DataBase) InsertApiKey(ctx context.Context, key data.ApiKey) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.NamedExecContext(ctx,
+		"INSERT INTO api_keys (user_id,name,key_id,key_hash,scopes,expires_at,created_at) "+
+			"VALUES (:user_id,:name,:key_id,:key_hash,:scopes,:expires_at,:created_at)", key)
 
 	if err != nil {
-		if e, ok := err.(*mysql.MySQLError); ok {
+		if e, ok := err.(*mysql.MySQLError); ok && e.Number == 1062 {
 			// mysql duplicate row
-			if e.Number == 1062 {
-				return data.ErrSqlDuplicateRow
-			}
-		} else {
-			return fmt.Errorf("%w : %s", data.ErrSql, err)
+			return data.ErrSqlDuplicateRow
+		}
+
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// UpdateApiKeyLastUsed sets the last use of a named API key to the current time. Returns a data.ErrSql if it fails.
+func (ds *DataBase) UpdateApiKeyLastUsed(ctx context.Context, id int) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.ExecContext(ctx, ds.db.Rebind("UPDATE api_keys SET last_used_at=? WHERE id=?"), time.Now().UTC(), id)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// DeleteApiKey deletes a named API key by its user_id and name. Returns a data.ErrSqlNoRow if the key doesn't exist or
+// data.ErrSql if it fails.
+func (ds *DataBase) DeleteApiKey(ctx context.Context, key data.ApiKey) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	res, err := ds.db.NamedExecContext(ctx, "DELETE FROM api_keys WHERE user_id=:user_id AND name=:name", key)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return data.ErrSqlNoRow
+	}
+
+	return nil
+}
+
+// InsertUser tries to add a new user to the database. If a user with the same name exists,
+// data.ErrSqlDuplicateRow is returned.
+func (ds *DataBase) InsertUser(ctx context.Context, user data.User) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.NamedExecContext(ctx,
+		"INSERT INTO users (username,is_admin,email,password_hash,api_key_id,api_key_hash) "+
+			"VALUES (:username,:is_admin,:email,:password_hash,:api_key_id,:api_key_hash)", user)
+
+	if err != nil {
+		// mysql duplicate row
+		if e, ok := err.(*mysql.MySQLError); ok && e.Number == 1062 {
+			return data.ErrSqlDuplicateRow
 		}
+
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
 	}
 
 	return nil
 }
 
 // UpdateUserPassword updates an user's password in the database. Returns a data.ErrSql if it fails.
-func (ds *DataBase) UpdateUserPassword(user data.User) error {
-	_, err := ds.db.NamedExec("UPDATE users SET password_hash=:password_hash WHERE username=:username", user)
+func (ds *DataBase) UpdateUserPassword(ctx context.Context, user data.User) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.NamedExecContext(ctx, "UPDATE users SET password_hash=:password_hash WHERE username=:username", user)
 
 	if err != nil {
 		return fmt.Errorf("%w : %s", data.ErrSql, err)
@@ -217,20 +700,306 @@ func (ds *DataBase) UpdateUserPassword(user data.User) error {
 	return nil
 }
 
+// SelectPasswordHistory fetches the n most recent previous password hashes of an user. Returns a data.ErrSql if it
+// fails.
+func (ds *DataBase) SelectPasswordHistory(ctx context.Context, userId int, n int) ([][]byte, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	hashes := make([][]byte, 0)
+	err := ds.db.SelectContext(ctx, &hashes,
+		ds.db.Rebind("SELECT password_hash FROM password_history WHERE user_id=? ORDER BY id DESC LIMIT ?"), userId, n)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return hashes, nil
+}
+
+// InsertPasswordHistory adds a previous password hash of an user to his history, then removes the oldest hashes so that
+// only the keep most recent ones remain. Returns a data.ErrSql if it fails.
+func (ds *DataBase) InsertPasswordHistory(ctx context.Context, userId int, passwordHash []byte, keep int) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	return ds.InTx(ctx, func(tx *DataBase) error {
+		_, err := tx.db.ExecContext(ctx,
+			tx.db.Rebind("INSERT INTO password_history (user_id,password_hash,created_at) VALUES (?,?,?)"),
+			userId, passwordHash, time.Now().UTC())
+
+		if err != nil {
+			return fmt.Errorf("%w : %s", data.ErrSql, err)
+		}
+
+		// The subquery is wrapped, as MySQL cannot use LIMIT in a IN subquery on the deleted table
+		_, err = tx.db.ExecContext(ctx, tx.db.Rebind(
+			"DELETE FROM password_history WHERE user_id=? AND id NOT IN (SELECT id FROM (SELECT id FROM password_history "+
+				"WHERE user_id=? ORDER BY id DESC LIMIT ?) AS recent)"),
+			userId, userId, keep)
+
+		if err != nil {
+			return fmt.Errorf("%w : %s", data.ErrSql, err)
+		}
+
+		return nil
+	})
+}
+
 // UpdateUserApiKey updates an user's API key in the database. Returns a data.ErrSql if it fails.
-func (ds *DataBase) UpdateUserApiKey(user data.User) error {
-	_, err := ds.db.NamedExec("UPDATE users SET api_key_hash=:api_key_hash WHERE username=:username", user)
+func (ds *DataBase) UpdateUserApiKey(ctx context.Context, user data.User) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.NamedExecContext(ctx, "UPDATE users SET api_key_id=:api_key_id,api_key_hash=:api_key_hash WHERE username=:username", user)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// UpdateUserCredentials updates an user's password if user.PasswordHash is set, and his API key if user.ApiKeyHash is
+// set, in a single transaction. When the password is updated, previousHash is added to his password history if
+// keepHistory is positive, keeping only the keepHistory most recent hashes. Returns a data.ErrSql if it fails, in which
+// case nothing is updated.
+func (ds *DataBase) UpdateUserCredentials(ctx context.Context, user data.User, previousHash []byte, keepHistory int) error {
+	return ds.InTx(ctx, func(tx *DataBase) error {
+		if len(user.PasswordHash) > 0 {
+			if err := tx.UpdateUserPassword(ctx, user); err != nil {
+				return err
+			}
+
+			if keepHistory > 0 && len(previousHash) > 0 {
+				if err := tx.InsertPasswordHistory(ctx, user.Id, previousHash, keepHistory); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(user.ApiKeyHash) > 0 {
+			return tx.UpdateUserApiKey(ctx, user)
+		}
+
+		return nil
+	})
+}
+
+// UpdateUserRoles updates an user's roles in the database. Returns a data.ErrSql if it fails.
+func (ds *DataBase) UpdateUserRoles(ctx context.Context, user data.User) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.NamedExecContext(ctx, "UPDATE users SET roles=:roles WHERE username=:username", user)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// UpdateUserAdmin updates whether an user is an admin in the database. Returns a data.ErrSql if it fails.
+func (ds *DataBase) UpdateUserAdmin(ctx context.Context, user data.User) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.NamedExecContext(ctx, "UPDATE users SET is_admin=:is_admin WHERE username=:username", user)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// UpdateUserEmail updates an user's email in the database. Returns a data.ErrSql if it fails.
+func (ds *DataBase) UpdateUserEmail(ctx context.Context, user data.User) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.NamedExecContext(ctx, "UPDATE users SET email=:email WHERE username=:username", user)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// UpdateUserDisabled updates an user's disabled state, reason, expiration date and whether his links are disabled in the
+// database. Returns a data.ErrSql if it fails.
+func (ds *DataBase) UpdateUserDisabled(ctx context.Context, user data.User) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.NamedExecContext(ctx,
+		"UPDATE users SET disabled=:disabled,disabled_reason=:disabled_reason,disabled_until=:disabled_until,"+
+			"disable_links=:disable_links WHERE username=:username",
+		user)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// UpdateUserTotp updates an user's TOTP secret and state in the database. Returns a data.ErrSql if it fails.
+func (ds *DataBase) UpdateUserTotp(ctx context.Context, user data.User) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.NamedExecContext(ctx,
+		"UPDATE users SET totp_secret=:totp_secret,totp_enabled=:totp_enabled,totp_last_step=0 WHERE username=:username",
+		user)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// UpdateUserTotpLastStep sets the last TOTP time step used by an user, if it is more recent than the stored one. Returns
+// a data.ErrSqlNoRow if the time step was already used or data.ErrSql if it fails.
+func (ds *DataBase) UpdateUserTotpLastStep(ctx context.Context, user data.User) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	res, err := ds.db.NamedExecContext(ctx,
+		"UPDATE users SET totp_last_step=:totp_last_step WHERE id=:id AND totp_last_step<:totp_last_step", user)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return data.ErrSqlNoRow
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces all the recovery codes of an user by the provided hashes. Returns a data.ErrSql if it
+// fails.
+func (ds *DataBase) ReplaceRecoveryCodes(ctx context.Context, userId int, codeHashes [][]byte) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	return ds.InTx(ctx, func(tx *DataBase) error {
+		_, err := tx.db.ExecContext(ctx, tx.db.Rebind("DELETE FROM recovery_codes WHERE user_id=?"), userId)
+
+		if err != nil {
+			return fmt.Errorf("%w : %s", data.ErrSql, err)
+		}
+
+		for _, h := range codeHashes {
+			_, err = tx.db.ExecContext(ctx, tx.db.Rebind("INSERT INTO recovery_codes (user_id,code_hash) VALUES (?,?)"), userId, h)
+
+			if err != nil {
+				return fmt.Errorf("%w : %s", data.ErrSql, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// ConsumeRecoveryCode deletes a recovery code of an user by its hash. Returns a data.ErrSqlNoRow if the code doesn't
+// exist or data.ErrSql if it fails.
+func (ds *DataBase) ConsumeRecoveryCode(ctx context.Context, userId int, codeHash []byte) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	res, err := ds.db.ExecContext(ctx, ds.db.Rebind("DELETE FROM recovery_codes WHERE user_id=? AND code_hash=?"), userId, codeHash)
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return data.ErrSqlNoRow
+	}
+
+	return nil
+}
+
+// ReplacePasswordResetToken replaces the password reset token of an user by the provided hash, valid until expiresAt.
+// Returns a data.ErrSql if it fails.
+func (ds *DataBase) ReplacePasswordResetToken(ctx context.Context, userId int, tokenHash []byte, expiresAt time.Time) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	return ds.InTx(ctx, func(tx *DataBase) error {
+		_, err := tx.db.ExecContext(ctx, tx.db.Rebind("DELETE FROM password_reset_tokens WHERE user_id=?"), userId)
+
+		if err != nil {
+			return fmt.Errorf("%w : %s", data.ErrSql, err)
+		}
+
+		_, err = tx.db.ExecContext(ctx,
+			tx.db.Rebind("INSERT INTO password_reset_tokens (user_id,token_hash,expires_at) VALUES (?,?,?)"),
+			userId, tokenHash, expiresAt.UTC())
+
+		if err != nil {
+			return fmt.Errorf("%w : %s", data.ErrSql, err)
+		}
+
+		return nil
+	})
+}
+
+// CheckPasswordResetToken checks that an user has a password reset token with this hash, without consuming it. Returns a
+// data.ErrSqlNoRow if the token doesn't exist or has expired, or data.ErrSql if it fails.
+func (ds *DataBase) CheckPasswordResetToken(ctx context.Context, userId int, tokenHash []byte) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	n := 0
+	err := ds.db.GetContext(ctx, &n,
+		ds.db.Rebind("SELECT COUNT(*) FROM password_reset_tokens WHERE user_id=? AND token_hash=? AND expires_at>?"),
+		userId, tokenHash, time.Now().UTC())
 
 	if err != nil {
 		return fmt.Errorf("%w : %s", data.ErrSql, err)
 	}
 
+	if n == 0 {
+		return data.ErrSqlNoRow
+	}
+
+	return nil
+}
+
+// ConsumePasswordResetToken deletes the password reset token of an user by its hash. Returns a data.ErrSqlNoRow if the
+// token doesn't exist or has expired, or data.ErrSql if it fails.
+func (ds *DataBase) ConsumePasswordResetToken(ctx context.Context, userId int, tokenHash []byte) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	res, err := ds.db.ExecContext(ctx,
+		ds.db.Rebind("DELETE FROM password_reset_tokens WHERE user_id=? AND token_hash=? AND expires_at>?"),
+		userId, tokenHash, time.Now().UTC())
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return data.ErrSqlNoRow
+	}
+
 	return nil
 }
 
 // DeleteUser deletes a user in the database by his username. Returns a data.ErrSql if it fails.
-func (ds *DataBase) DeleteUser(username string) error {
-	_, err := ds.db.Exec(ds.db.Rebind("DELETE FROM users WHERE username=?"), username)
+func (ds *DataBase) DeleteUser(ctx context.Context, username string) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.ExecContext(ctx, ds.db.Rebind("DELETE FROM users WHERE username=?"), username)
 
 	if err != nil {
 		return fmt.Errorf("%w : %s", data.ErrSql, err)
@@ -239,11 +1008,45 @@ func (ds *DataBase) DeleteUser(username string) error {
 	return nil
 }
 
-// GetTarget gets a target in the database from a path. Returns a data.ErrSqlNoRow if the target doesn't exist or
-// data.ErrSql if it fails.
-func (ds *DataBase) GetTarget(path string) (string, error) {
+// GetTarget gets a target in the database from a path, from a replica if any is healthy. A path missing on the replica
+// is looked up on the primary, as it may have just been created, and so is a path marked with MarkPathsWritten during
+// the maximum replication lag, as the replica may still have its previous target. The links of a disabled user are ignored if he is still
+// suspended and his links have been disabled. Returns a data.ErrSqlNoRow if the target doesn't exist or data.ErrSql if
+// it fails.
+func (ds *DataBase) GetTarget(ctx context.Context, path string) (string, error) {
+	if ds.replicas.recentlyWritten(path) {
+		ctx, cancel := ds.withTimeout(ctx)
+		defer cancel()
+
+		return getTarget(ctx, ds.db, path)
+	}
+
+	var t string
+
+	err := ds.read(ctx, true, func(ctx context.Context, db executor) error {
+		var err error
+		t, err = getTarget(ctx, db, path)
+
+		return err
+	})
+
+	return t, err
+}
+
+// MarkPathsWritten records that the targets of the paths were just changed, by this instance or another one, so that
+// GetTarget reads them from the primary until the replicas have caught up. It does nothing without replicas.
+func (ds *DataBase) MarkPathsWritten(paths []string) {
+	ds.replicas.markWritten(paths)
+}
+
+// getTarget gets a target from a path in db. Returns a data.ErrSqlNoRow if the target doesn't exist or data.ErrSql if
+// it fails.
+func getTarget(ctx context.Context, db executor, path string) (string, error) {
 	t := ""
-	err := ds.db.Get(&t, ds.db.Rebind("SELECT target FROM go WHERE path=?"), path)
+	err := db.GetContext(ctx, &t, db.Rebind(
+		"SELECT go.target FROM go INNER JOIN users ON users.id=go.user_id WHERE go.path=? AND NOT (users.disabled=1 "+
+			"AND users.disable_links=1 AND (users.disabled_until IS NULL OR users.disabled_until>?))"),
+		path, time.Now().UTC())
 
 	if err != nil {
 		switch {
@@ -257,28 +1060,165 @@ func (ds *DataBase) GetTarget(path string) (string, error) {
 	return t, nil
 }
 
+// SelectPath fetches a data.Path from the database, whatever the state of its owner. Returns a data.ErrSqlNoRow if the
+// path doesn't exist or data.ErrSql if it fails.
+func (ds *DataBase) SelectPath(ctx context.Context, path string) (data.Path, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	p := data.Path{}
+	err := ds.db.GetContext(ctx, &p, ds.db.Rebind("SELECT path,target,user_id FROM go WHERE path=?"), path)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return data.Path{}, data.ErrSqlNoRow
+		default:
+			return data.Path{}, fmt.Errorf("%w : %s", data.ErrSql, err)
+		}
+	}
+
+	return p, nil
+}
+
+// SelectAllPaths fetches all the paths with the username of their owner and their timestamps, ordered by path. Returns
+// a data.ErrSql if it fails.
+func (ds *DataBase) SelectAllPaths(ctx context.Context) ([]data.OwnedPath, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	paths := make([]data.OwnedPath, 0)
+	err := ds.db.SelectContext(ctx, &paths,
+		"SELECT go.path,go.target,users.username,go.created_at,go.updated_at FROM go INNER JOIN users ON users.id=go.user_id "+
+			"ORDER BY go.path")
+
+	if err != nil {
+		return nil, fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return paths, nil
+}
+
+// SelectPathsByHits fetches the targets which can be served, from the most to the least accessed path. At most limit
+// paths are returned, after skipping offset paths. Returns a data.ErrSql if it fails.
+func (ds *DataBase) SelectPathsByHits(ctx context.Context, limit int, offset int) ([]data.Path, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	paths := make([]data.Path, 0)
+	err := ds.db.SelectContext(ctx, &paths, ds.db.Rebind(
+		"SELECT go.path,go.target,go.user_id FROM go INNER JOIN users ON users.id=go.user_id WHERE NOT (users.disabled=1 "+
+			"AND users.disable_links=1 AND (users.disabled_until IS NULL OR users.disabled_until>?)) "+
+			"ORDER BY go.hits DESC,go.path LIMIT ? OFFSET ?"),
+		time.Now().UTC(), limit, offset)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return paths, nil
+}
+
+// SelectPathsAfter fetches the targets which can be served, ordered by path. At most limit paths are returned, starting
+// after the path provided, so that all the paths can be fetched in batches. Returns a data.ErrSql if it fails.
+func (ds *DataBase) SelectPathsAfter(ctx context.Context, path string, limit int) ([]data.Path, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	paths := make([]data.Path, 0)
+	err := ds.db.SelectContext(ctx, &paths, ds.db.Rebind(
+		"SELECT go.path,go.target,go.user_id FROM go INNER JOIN users ON users.id=go.user_id WHERE go.path>? AND NOT "+
+			"(users.disabled=1 AND users.disable_links=1 AND (users.disabled_until IS NULL OR users.disabled_until>?)) "+
+			"ORDER BY go.path LIMIT ?"),
+		path, time.Now().UTC(), limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return paths, nil
+}
+
+// AddPathHits adds the number of times each path was accessed to its hits. Either all the paths are updated or none is.
+// Returns a data.ErrSql if it fails.
+func (ds *DataBase) AddPathHits(ctx context.Context, hits map[string]int64) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	return ds.InTx(ctx, func(tx *DataBase) error {
+		for path, n := range hits {
+			_, err := tx.db.ExecContext(ctx, tx.db.Rebind("UPDATE go SET hits=hits+? WHERE path=?"), n, path)
+
+			if err != nil {
+				return fmt.Errorf("%w : %s", data.ErrSql, err)
+			}
+		}
+
+		return nil
+	})
+}
+
 // InsertPath adds a data.Path to the database. Returns a data.ErrSqlDuplicateRow if the path already exists or
 // data.ErrSql if it fails.
-func (ds *DataBase) InsertPath(path data.Path) error {
-	_, err := ds.db.NamedExec("INSERT INTO go (path,target,user_id) VALUES (:path,:target,:user_id)", path)
+func (ds *DataBase) InsertPath(ctx context.Context, path data.Path) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	_, err := ds.db.ExecContext(ctx,
+		ds.db.Rebind("INSERT INTO go (path,target,user_id,created_at,updated_at) VALUES (?,?,?,?,?)"),
+		path.Path, path.Target, path.UserId, now, now)
 
 	if err != nil {
-		if e, ok := err.(*mysql.MySQLError); ok {
-			// mysql duplicate row
-			if e.Number == 1062 {
-				return data.ErrSqlDuplicateRow
-			}
-		} else {
-			return fmt.Errorf("%w : %s", data.ErrSql, err)
+		// mysql duplicate row
+		if e, ok := err.(*mysql.MySQLError); ok && e.Number == 1062 {
+			return data.ErrSqlDuplicateRow
+		}
+
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// UpdatePath renames a path in the database, keeping its target and owner. Returns a data.ErrSqlNoRow if the path
+// doesn't exist, data.ErrSqlDuplicateRow if the new path already exists or data.ErrSql if it fails.
+func (ds *DataBase) UpdatePath(ctx context.Context, oldPath string, newPath string) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	result, err := ds.db.ExecContext(ctx, ds.db.Rebind("UPDATE go SET path=?,updated_at=? WHERE path=?"),
+		newPath, time.Now().UTC(), oldPath)
+
+	if err != nil {
+		// mysql duplicate row
+		if e, ok := err.(*mysql.MySQLError); ok && e.Number == 1062 {
+			return data.ErrSqlDuplicateRow
 		}
+
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	if n == 0 {
+		return data.ErrSqlNoRow
 	}
 
 	return nil
 }
 
 // DeletePath deletes a data.Path in the database. Returns a data.ErrSql if it fails.
-func (ds *DataBase) DeletePath(path data.Path) error {
-	_, err := ds.db.NamedExec("DELETE FROM go WHERE path=:path AND user_id=:user_id", path)
+func (ds *DataBase) DeletePath(ctx context.Context, path data.Path) error {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	_, err := ds.db.NamedExecContext(ctx, "DELETE FROM go WHERE path=:path AND user_id=:user_id", path)
 
 	if err != nil {
 		return fmt.Errorf("%w : %s", data.ErrSql, err)
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"go-there/data"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_mysqlDsn(t *testing.T) {
+	invalidCa := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(invalidCa, []byte("invalid"), 0600))
+
+	tests := []struct {
+		name    string
+		conf    config.Database
+		want    string
+		wantErr error
+	}{
+		{
+			name: "fields",
+			conf: config.Database{
+				Address:  "localhost",
+				Port:     3306,
+				Protocol: "tcp",
+				Name:     "go_there_db",
+				User:     "my_user",
+				Password: "superpassword",
+			},
+			want: "my_user:superpassword@tcp(localhost:3306)/go_there_db?parseTime=true",
+		},
+		{
+			name: "ssl_params",
+			conf: config.Database{
+				Address:  "localhost",
+				Port:     3306,
+				Protocol: "tcp",
+				Name:     "go_there_db",
+				User:     "my_user",
+				SslMode:  true,
+				Params:   map[string]string{"charset": "utf8mb4"},
+			},
+			want: "my_user@tcp(localhost:3306)/go_there_db?parseTime=true&tls=true&charset=utf8mb4",
+		},
+		{
+			name: "dsn",
+			conf: config.Database{
+				Address: "ignored",
+				Dsn:     "my_user:superpassword@unix(/var/run/mysqld/mysqld.sock)/go_there_db?charset=latin1",
+				Params:  map[string]string{"charset": "utf8mb4"},
+			},
+			want: "my_user:superpassword@unix(/var/run/mysqld/mysqld.sock)/go_there_db?parseTime=true&charset=utf8mb4",
+		},
+		{
+			name:    "invalid_dsn",
+			conf:    config.Database{Dsn: "invalid"},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name:    "missing_ca",
+			conf:    config.Database{CaPath: "/nonexistent/ca.pem"},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name:    "invalid_ca",
+			conf:    config.Database{CaPath: invalidCa},
+			wantErr: data.ErrSettings,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mysqlDsn(tt.conf)
+
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_connectWithRetry(t *testing.T) {
+	start := time.Now()
+
+	_, err := connectWithRetry("mysql", "my_user@tcp(127.0.0.1:1)/go_there_db", -1)
+
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(connectBackoffBase))
+
+	start = time.Now()
+
+	_, err = connectWithRetry("mysql", "my_user@tcp(127.0.0.1:1)/go_there_db", 2*connectBackoffBase)
+
+	assert.Error(t, err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(connectBackoffBase))
+}
+
+func TestInit_invalidSettings(t *testing.T) {
+	_, err := Init(&config.Configuration{Database: config.Database{Type: "mysql", MaxOpenConns: -1}})
+
+	assert.True(t, errors.Is(err, data.ErrSettings))
+}
+
+func TestDataBase_InTx_nested(t *testing.T) {
+	tx := &DataBase{timeout: time.Second}
+
+	var got *DataBase
+
+	err := tx.InTx(context.Background(), func(db *DataBase) error {
+		got = db
+		return data.ErrSql
+	})
+
+	assert.Same(t, tx, got)
+	assert.True(t, errors.Is(err, data.ErrSql))
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"go-there/data"
+	"strings"
+)
+
+// SelectDuplicatePaths fetches the paths stored in several rows, with their number of rows, ordered by path. Returns a
+// data.ErrSql if it fails.
+func (ds *DataBase) SelectDuplicatePaths(ctx context.Context) ([]data.DuplicatePath, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	paths := make([]data.DuplicatePath, 0)
+	err := ds.db.SelectContext(ctx, &paths,
+		"SELECT path,COUNT(*) AS count FROM go WHERE path IS NOT NULL GROUP BY path HAVING COUNT(*)>1 ORDER BY path")
+
+	if err != nil {
+		return nil, fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return paths, nil
+}
+
+// CountIncompletePaths counts the rows without a path, a target or an owner. Returns a data.ErrSql if it fails.
+func (ds *DataBase) CountIncompletePaths(ctx context.Context) (int, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	n := 0
+	err := ds.db.GetContext(ctx, &n,
+		"SELECT COUNT(*) FROM go WHERE path IS NULL OR target IS NULL OR user_id IS NULL")
+
+	if err != nil {
+		return 0, fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return n, nil
+}
+
+// MigratePaths updates the go table of a database created before the paths were unique or counted: the path, target
+// and owner become required, the path index becomes unique, the created_at and updated_at timestamps are added, set to
+// the time of the migration for the existing paths, and the hits column is added with its index. Only the missing
+// changes are made, so it does nothing if the table is already up to date. The duplicate and incomplete paths must be
+// removed first, which can be checked with SelectDuplicatePaths and CountIncompletePaths. The schema change is not
+// bounded by the query timeout, as it rebuilds the table, and only ends with ctx. Returns a data.ErrSqlDuplicateRow if
+// a path is duplicated, or a data.ErrSql if it fails, in which case the table is left unchanged.
+func (ds *DataBase) MigratePaths(ctx context.Context) error {
+	var changes []string
+
+	hasTimestamps, err := ds.hasPathsColumn(ctx, "created_at")
+
+	if err != nil {
+		return err
+	}
+
+	if !hasTimestamps {
+		changes = append(changes, "MODIFY path varchar(255) NOT NULL", "MODIFY target text NOT NULL",
+			"MODIFY user_id int NOT NULL", "ADD created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP",
+			"ADD updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP", "DROP INDEX path",
+			"ADD UNIQUE INDEX path (path)")
+	}
+
+	hasHits, err := ds.hasPathsColumn(ctx, "hits")
+
+	if err != nil {
+		return err
+	}
+
+	if !hasHits {
+		changes = append(changes, "ADD hits bigint NOT NULL DEFAULT 0", "ADD INDEX hits (hits)")
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	// A single statement, as MySQL cannot roll back a schema change
+	_, err = ds.db.ExecContext(ctx, "ALTER TABLE go "+strings.Join(changes, ", "))
+
+	if err != nil {
+		// mysql duplicate row
+		if e, ok := err.(*mysql.MySQLError); ok && e.Number == 1062 {
+			return data.ErrSqlDuplicateRow
+		}
+
+		return fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return nil
+}
+
+// hasPathsColumn returns true if the go table has the column. Returns a data.ErrSql if it fails.
+func (ds *DataBase) hasPathsColumn(ctx context.Context, column string) (bool, error) {
+	ctx, cancel := ds.withTimeout(ctx)
+	defer cancel()
+
+	n := 0
+	err := ds.db.GetContext(ctx, &n, "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema=DATABASE() "+
+		"AND table_name='go' AND column_name=?", column)
+
+	if err != nil {
+		return false, fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	return n > 0, nil
+}
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"go-there/config"
+	"go-there/data"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Interval at which the replicas are checked, if not configured
+const defaultReplicaCheckInterval = 10 * time.Second
+
+// Maximum replication lag of a healthy replica, if not configured. The paths written recently are only read from the
+// primary during the same duration.
+const defaultReplicaMaxLag = 30 * time.Second
+
+// replica is a read-only copy of the database. It only receives queries while it is healthy.
+type replica struct {
+	db      *sqlx.DB
+	healthy int32
+}
+
+// isHealthy returns true if the replica can receive queries.
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// setHealthy records whether the replica can receive queries.
+// Logs a warning when the state changes.
+func (r *replica) setHealthy(healthy bool) {
+	var v int32
+
+	if healthy {
+		v = 1
+	}
+
+	if atomic.SwapInt32(&r.healthy, v) != v {
+		if healthy {
+			log.Info().Msg("database replica healthy again")
+		} else {
+			log.Warn().Msg("database replica unhealthy, reading from the other replicas or the primary")
+		}
+	}
+}
+
+// replicaSet sends the reads to its healthy replicas in turn. The paths written during the last maxLag are only read
+// from the primary, as the replicas may not have the change yet.
+type replicaSet struct {
+	replicas []*replica
+	next     uint32
+	maxLag   time.Duration
+
+	mu      sync.Mutex
+	written map[string]time.Time
+}
+
+// openReplicas opens the replicas of the configuration, sharing the settings of the primary. They are checked once
+// before returning, then every interval, each check being canceled after timeout. A replica which cannot be reached
+// or lags more than maxLag is not an error, as it is checked again later. Returns nil if no replica is configured, or
+// a data.ErrSettings if a DSN is invalid.
+func openReplicas(conf config.Database, interval time.Duration, timeout time.Duration,
+	maxLag time.Duration) (*replicaSet, error) {
+	if len(conf.ReplicaDsns) == 0 {
+		return nil, nil
+	}
+
+	rs := &replicaSet{
+		maxLag:  maxLag,
+		written: map[string]time.Time{},
+	}
+
+	for _, dsn := range conf.ReplicaDsns {
+		rc := conf
+		rc.Dsn = dsn
+
+		dsn, err := mysqlDsn(rc)
+
+		if err != nil {
+			return nil, err
+		}
+
+		db, err := sqlx.Open(conf.Type, dsn)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w : invalid replica dsn : %s", data.ErrSettings, err)
+		}
+
+		applyPoolSettings(db, conf)
+		rs.replicas = append(rs.replicas, &replica{db: db})
+	}
+
+	rs.check(timeout)
+
+	go func() {
+		for range time.Tick(interval) {
+			rs.check(timeout)
+		}
+	}()
+
+	return rs, nil
+}
+
+// check measures the replication lag of every replica, and updates whether it can receive queries. It also forgets the
+// paths written more than maxLag ago.
+// Logs a warning when a replica becomes unhealthy.
+func (rs *replicaSet) check(timeout time.Duration) {
+	for _, r := range rs.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		lag, err := replicationLag(ctx, r.db)
+		cancel()
+
+		if err == nil && lag > rs.maxLag {
+			err = fmt.Errorf("replication lag of %s", lag)
+		}
+
+		if err != nil && r.isHealthy() {
+			log.Warn().Err(err).Msg("database replica check failed")
+		}
+
+		r.setHealthy(err == nil)
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for path, until := range rs.written {
+		if time.Now().After(until) {
+			delete(rs.written, path)
+		}
+	}
+}
+
+// replicationLag returns how far behind its primary the replica db is. Requires the REPLICATION CLIENT privilege.
+// Returns an error if the replica is unreachable or not replicating.
+func replicationLag(ctx context.Context, db *sqlx.DB) (time.Duration, error) {
+	// SHOW REPLICA STATUS and its columns replace the older names since MySQL 8.0.22
+	rows, err := db.QueryxContext(ctx, "SHOW REPLICA STATUS")
+
+	if err != nil {
+		rows, err = db.QueryxContext(ctx, "SHOW SLAVE STATUS")
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
+		return 0, errors.New("not a replica")
+	}
+
+	status := map[string]interface{}{}
+
+	if err := rows.MapScan(status); err != nil {
+		return 0, err
+	}
+
+	seconds, ok := status["Seconds_Behind_Source"]
+
+	if !ok {
+		seconds = status["Seconds_Behind_Master"]
+	}
+
+	// NULL while the replication is stopped
+	b, ok := seconds.([]byte)
+
+	if !ok {
+		return 0, errors.New("replication not running")
+	}
+
+	n, err := strconv.Atoi(string(b))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(n) * time.Second, nil
+}
+
+// markWritten records that the paths were just written, so that they are read from the primary during maxLag. It is
+// safe to call on a nil replicaSet.
+func (rs *replicaSet) markWritten(paths []string) {
+	if rs == nil {
+		return
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	until := time.Now().Add(rs.maxLag)
+
+	for _, p := range paths {
+		rs.written[p] = until
+	}
+}
+
+// recentlyWritten returns true if the path was written during the last maxLag. It is safe to call on a nil replicaSet.
+func (rs *replicaSet) recentlyWritten(path string) bool {
+	if rs == nil {
+		return false
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	until, ok := rs.written[path]
+
+	return ok && time.Now().Before(until)
+}
+
+// pick returns the next healthy replica, or nil if there is none. It is safe to call on a nil replicaSet.
+func (rs *replicaSet) pick() *replica {
+	if rs == nil {
+		return nil
+	}
+
+	n := uint32(len(rs.replicas))
+	start := atomic.AddUint32(&rs.next, 1)
+
+	for i := uint32(0); i < n; i++ {
+		if r := rs.replicas[(start+i)%n]; r.isHealthy() {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// read runs a read-only query on a healthy replica, or on the primary if there is none. If the query fails on the
+// replica, it is considered unhealthy until its next check and the query is run on the primary. If checkMissing is
+// true, the query is also run on the primary when the replica returns a data.ErrSqlNoRow, as a lagging replica may not
+// have the rows written just before. Each attempt is canceled after the query timeout, and none is made once ctx is
+// done.
+// Logs a warning if the query fails on a replica.
+func (ds *DataBase) read(ctx context.Context, checkMissing bool,
+	query func(ctx context.Context, db executor) error) error {
+	attempt := func(db executor) error {
+		ctx, cancel := ds.withTimeout(ctx)
+		defer cancel()
+
+		return query(ctx, db)
+	}
+
+	r := ds.replicas.pick()
+
+	if r == nil {
+		return attempt(ds.db)
+	}
+
+	err := attempt(r.db)
+
+	switch {
+	case err == nil, ctx.Err() != nil:
+		return err
+	case errors.Is(err, data.ErrSqlNoRow):
+		if !checkMissing {
+			return err
+		}
+	default:
+		log.Warn().Err(err).Msg("error reading from a database replica")
+		r.setHealthy(false)
+	}
+
+	return attempt(ds.db)
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"go-there/data"
+	"testing"
+	"time"
+)
+
+func Test_replicaSet_pick(t *testing.T) {
+	var rs *replicaSet
+
+	assert.Nil(t, rs.pick())
+
+	r1 := &replica{healthy: 1}
+	r2 := &replica{healthy: 1}
+	r3 := &replica{}
+	rs = &replicaSet{replicas: []*replica{r1, r2, r3}}
+
+	picked := map[*replica]int{}
+
+	for i := 0; i < 4; i++ {
+		picked[rs.pick()]++
+	}
+
+	assert.Equal(t, map[*replica]int{r1: 2, r2: 2}, picked)
+
+	r1.setHealthy(false)
+	r2.setHealthy(false)
+
+	assert.Nil(t, rs.pick())
+}
+
+func TestDataBase_read(t *testing.T) {
+	primary := &sqlx.DB{}
+	lagging := &sqlx.DB{}
+	broken := &sqlx.DB{}
+
+	tests := []struct {
+		name         string
+		replica      *sqlx.DB
+		checkMissing bool
+		wantErr      error
+		wantQueried  []executor
+		wantHealthy  bool
+	}{
+		{
+			name:        "no_replica",
+			wantQueried: []executor{primary},
+		},
+		{
+			name:        "missing",
+			replica:     lagging,
+			wantErr:     data.ErrSqlNoRow,
+			wantQueried: []executor{lagging},
+			wantHealthy: true,
+		},
+		{
+			name:         "missing_checked",
+			replica:      lagging,
+			checkMissing: true,
+			wantQueried:  []executor{lagging, primary},
+			wantHealthy:  true,
+		},
+		{
+			name:        "replica_error",
+			replica:     broken,
+			wantQueried: []executor{broken, primary},
+			wantHealthy: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &DataBase{db: primary, timeout: time.Second}
+			var r *replica
+
+			if tt.replica != nil {
+				r = &replica{db: tt.replica, healthy: 1}
+				ds.replicas = &replicaSet{replicas: []*replica{r}}
+			}
+
+			var queried []executor
+
+			err := ds.read(context.Background(), tt.checkMissing, func(ctx context.Context, db executor) error {
+				queried = append(queried, db)
+
+				switch db {
+				case lagging:
+					return data.ErrSqlNoRow
+				case broken:
+					return data.ErrSql
+				}
+
+				return nil
+			})
+
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantQueried, queried)
+
+			if r != nil {
+				assert.Equal(t, tt.wantHealthy, r.isHealthy())
+			}
+		})
+	}
+}
+
+func Test_replicaSet_recentlyWritten(t *testing.T) {
+	var rs *replicaSet
+
+	rs.markWritten([]string{"docs"})
+	assert.False(t, rs.recentlyWritten("docs"))
+
+	rs = &replicaSet{maxLag: time.Minute, written: map[string]time.Time{}}
+	rs.markWritten([]string{"docs", "wiki"})
+
+	assert.True(t, rs.recentlyWritten("docs"))
+	assert.True(t, rs.recentlyWritten("wiki"))
+	assert.False(t, rs.recentlyWritten("blog"))
+
+	// Forgotten by the next check once the replicas have caught up
+	rs.written["docs"] = time.Now().Add(-time.Second)
+	rs.check(time.Second)
+
+	assert.False(t, rs.recentlyWritten("docs"))
+	assert.NotContains(t, rs.written, "docs")
+	assert.True(t, rs.recentlyWritten("wiki"))
+}
@@ -1,116 +1,443 @@
 package datasource
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/rs/zerolog/log"
 	"go-there/cache"
 	"go-there/data"
 	"go-there/database"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"time"
 )
 
 // DataSource represents the source of the user data (database+cache). It abstracts the caching process. Currently,
 // only the path operations are cached
 type DataSource struct {
 	*database.DataBase
-	*cache.Cache
+	cache.Cache
+	lookups  singleflight.Group
+	hits     hitCounter
+	snapshot *snapshot
+
+	mu            sync.Mutex
+	degradedSince time.Time
+}
+
+// invalidationNotifier is implemented by the caches shared by several instances which receive the invalidations of the
+// other instances.
+type invalidationNotifier interface {
+	OnInvalidate(f func(paths []string))
 }
 
-// Init initializes a datasource from a *database.DataBase and *cache.Cache. The cache can be nil.
-func Init(db *database.DataBase, cache *cache.Cache) *DataSource {
+// Init initializes a datasource from a *database.DataBase and a cache.Cache. The cache can be nil, in which case
+// nothing is cached. The paths invalidated by the other instances sharing the cache are read from the primary database
+// until the replicas have caught up, as for the paths changed by this instance.
+func Init(db *database.DataBase, c cache.Cache) *DataSource {
+	if c == nil {
+		c = noCache{}
+	}
+
+	if n, ok := c.(invalidationNotifier); ok {
+		n.OnInvalidate(db.MarkPathsWritten)
+	}
+
 	return &DataSource{
 		DataBase: db,
-		Cache:    cache,
+		Cache:    c,
+	}
+}
+
+// DegradedSince returns the time at which the database became unreachable, or a zero time.Time if it is reachable. It
+// is updated by the target lookups, the snapshot refreshes and the health checks.
+func (ds *DataSource) DegradedSince() time.Time {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	return ds.degradedSince
+}
+
+// setDegraded records whether the database is unreachable. The time at which it became unreachable is kept until it is
+// reachable again.
+// Logs a warning when the state changes.
+func (ds *DataSource) setDegraded(degraded bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	switch {
+	case degraded && ds.degradedSince.IsZero():
+		ds.degradedSince = time.Now()
+		log.Warn().Msg("database unreachable, serving the cached or snapshot targets")
+	case !degraded && !ds.degradedSince.IsZero():
+		ds.degradedSince = time.Time{}
+		log.Warn().Msg("database reachable again")
 	}
 }
 
+// StartHealthCheck pings the database immediately, then every interval until the returned function is called, so that
+// DegradedSince follows its state even when no target is looked up from it.
+func (ds *DataSource) StartHealthCheck(interval time.Duration) func() {
+	return runPeriodically(interval, true, func() {
+		ds.setDegraded(ds.DataBase.Ping(context.Background()) != nil)
+	})
+}
+
+// noCache is used when no cache is configured. Every lookup is a miss.
+type noCache struct{}
+
+func (noCache) GetTarget(context.Context, string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (noCache) AddTarget(context.Context, data.Path) error {
+	return nil
+}
+
+func (noCache) AddMissingTarget(context.Context, string) error {
+	return nil
+}
+
+func (noCache) DeleteTargets(context.Context, []string) error {
+	return nil
+}
+
 // SelectUser fetches an complete user by his username in the database. Returns a data.ErrSql if it fails.
-func (ds *DataSource) SelectUser(username string) (data.UserInfo, error) {
-	return ds.DataBase.SelectUser(username)
+func (ds *DataSource) SelectUser(ctx context.Context, username string) (data.UserInfo, error) {
+	return ds.DataBase.SelectUser(ctx, username)
 }
 
 // SelectAllUsers fetches the complete list of all users. Returns a data.ErrSql if it fails.
-func (ds *DataSource) SelectAllUsers() ([]data.UserInfo, error) {
-	return ds.DataBase.SelectAllUsers()
+func (ds *DataSource) SelectAllUsers(ctx context.Context) ([]data.UserInfo, error) {
+	return ds.DataBase.SelectAllUsers(ctx)
 }
 
 // SelectUserLogin fetches the id,username,is_admin,password_hash of a user by his username in the database. Returns a
 // data.ErrSql if it fails.
-func (ds *DataSource) SelectUserLogin(username string) (data.User, error) {
-	return ds.DataBase.SelectUserLogin(username)
+func (ds *DataSource) SelectUserLogin(ctx context.Context, username string) (data.User, error) {
+	return ds.DataBase.SelectUserLogin(ctx, username)
 }
 
 // SelectApiKeyHashByUser fetches a full API key hash from the database by a username. Returns a data.ErrSql if it
 // fails.
-func (ds *DataSource) SelectApiKeyHashByUser(username string) ([]byte, error) {
-	return ds.DataBase.SelectApiKeyHashByUser(username)
+func (ds *DataSource) SelectApiKeyHashByUser(ctx context.Context, username string) ([]byte, error) {
+	return ds.DataBase.SelectApiKeyHashByUser(ctx, username)
 }
 
 // SelectUserLoginByApiKeyHash fetches the id,username,is_admin,api_key_hash of a user, by his API key hash.
-func (ds *DataSource) SelectUserLoginByApiKeyHash(apiKeyHash string) (data.User, error) {
-	return ds.DataBase.SelectUserLoginByApiKeyHash(apiKeyHash)
+func (ds *DataSource) SelectUserLoginByApiKeyHash(ctx context.Context, apiKeyHash string) (data.User, error) {
+	return ds.DataBase.SelectUserLoginByApiKeyHash(ctx, apiKeyHash)
+}
+
+// SelectUserLoginByApiKeyId fetches the id,username,is_admin,api_key_hash of a user, by his API key id. Returns a
+// data.ErrSqlNoRow if no user has this key or data.ErrSql if it fails.
+func (ds *DataSource) SelectUserLoginByApiKeyId(ctx context.Context, keyId string) (data.User, error) {
+	return ds.DataBase.SelectUserLoginByApiKeyId(ctx, keyId)
+}
+
+// SelectApiKeyLoginById fetches a named API key and the id,username,is_admin of its owner by the key id. Returns a
+// data.ErrSqlNoRow if the key doesn't exist or data.ErrSql if it fails.
+func (ds *DataSource) SelectApiKeyLoginById(ctx context.Context, keyId string) (data.User, data.ApiKey, error) {
+	return ds.DataBase.SelectApiKeyLoginById(ctx, keyId)
+}
+
+// SelectApiKeyLogin fetches a named API key and the id,username,is_admin of its owner by the key hash. Returns a
+// data.ErrSqlNoRow if the key doesn't exist or data.ErrSql if it fails.
+func (ds *DataSource) SelectApiKeyLogin(ctx context.Context, keyHash string) (data.User, data.ApiKey, error) {
+	return ds.DataBase.SelectApiKeyLogin(ctx, keyHash)
+}
+
+// SelectApiKeys fetches all the named API keys of a user. Returns a data.ErrSql if it fails.
+func (ds *DataSource) SelectApiKeys(ctx context.Context, userId int) ([]data.ApiKey, error) {
+	return ds.DataBase.SelectApiKeys(ctx, userId)
+}
+
+// InsertApiKey adds a named API key to the database. If the user already has a key with the same name,
+// data.ErrSqlDuplicateRow is returned.
+func (ds *DataSource) InsertApiKey(ctx context.Context, key data.ApiKey) error {
+	return ds.DataBase.InsertApiKey(ctx, key)
+}
+
+// UpdateApiKeyLastUsed sets the last use of a named API key to the current time. Returns a data.ErrSql if it fails.
+func (ds *DataSource) UpdateApiKeyLastUsed(ctx context.Context, id int) error {
+	return ds.DataBase.UpdateApiKeyLastUsed(ctx, id)
+}
+
+// DeleteApiKey deletes a named API key by its user_id and name. Returns a data.ErrSqlNoRow if the key doesn't exist or
+// data.ErrSql if it fails.
+func (ds *DataSource) DeleteApiKey(ctx context.Context, key data.ApiKey) error {
+	return ds.DataBase.DeleteApiKey(ctx, key)
 }
 
 // InsertUser tries to add a new user to the database. If a user with the same name or API key hash exists,
 // data.ErrSqlDuplicateRow is returned.
-func (ds *DataSource) InsertUser(user data.User) error {
-	return ds.DataBase.InsertUser(user)
+func (ds *DataSource) InsertUser(ctx context.Context, user data.User) error {
+	return ds.DataBase.InsertUser(ctx, user)
 }
 
 // UpdateUserPassword updates an user's password in the database. Returns a data.ErrSql if it fails.
-func (ds *DataSource) UpdateUserPassword(user data.User) error {
-	return ds.DataBase.UpdateUserPassword(user)
+func (ds *DataSource) UpdateUserPassword(ctx context.Context, user data.User) error {
+	return ds.DataBase.UpdateUserPassword(ctx, user)
+}
+
+// SelectPasswordHistory fetches the n most recent previous password hashes of an user. Returns a data.ErrSql if it
+// fails.
+func (ds *DataSource) SelectPasswordHistory(ctx context.Context, userId int, n int) ([][]byte, error) {
+	return ds.DataBase.SelectPasswordHistory(ctx, userId, n)
+}
+
+// UpdateUserCredentials updates an user's password if user.PasswordHash is set, and his API key if user.ApiKeyHash is
+// set, in a single transaction. When the password is updated, previousHash is added to his password history if
+// keepHistory is positive. Returns a data.ErrSql if it fails, in which case nothing is updated.
+func (ds *DataSource) UpdateUserCredentials(ctx context.Context, user data.User, previousHash []byte, keepHistory int) error {
+	return ds.DataBase.UpdateUserCredentials(ctx, user, previousHash, keepHistory)
 }
 
 // UpdateUserApiKey updates an user's API key in the database. Returns a data.ErrSql if it fails.
-func (ds *DataSource) UpdateUserApiKey(user data.User) error {
-	return ds.DataBase.UpdateUserApiKey(user)
+func (ds *DataSource) UpdateUserApiKey(ctx context.Context, user data.User) error {
+	return ds.DataBase.UpdateUserApiKey(ctx, user)
+}
+
+// UpdateUserRoles updates an user's roles in the database. Returns a data.ErrSql if it fails.
+func (ds *DataSource) UpdateUserRoles(ctx context.Context, user data.User) error {
+	return ds.DataBase.UpdateUserRoles(ctx, user)
+}
+
+// UpdateUserAdmin updates whether an user is an admin in the database. Returns a data.ErrSql if it fails.
+func (ds *DataSource) UpdateUserAdmin(ctx context.Context, user data.User) error {
+	return ds.DataBase.UpdateUserAdmin(ctx, user)
+}
+
+// UpdateUserEmail updates an user's email in the database. Returns a data.ErrSql if it fails.
+func (ds *DataSource) UpdateUserEmail(ctx context.Context, user data.User) error {
+	return ds.DataBase.UpdateUserEmail(ctx, user)
+}
+
+// UpdateUserTotp updates an user's TOTP secret and state in the database. Returns a data.ErrSql if it fails.
+func (ds *DataSource) UpdateUserTotp(ctx context.Context, user data.User) error {
+	return ds.DataBase.UpdateUserTotp(ctx, user)
+}
+
+// UpdateUserTotpLastStep sets the last TOTP time step used by an user, if it is more recent than the stored one. Returns
+// a data.ErrSqlNoRow if the time step was already used or data.ErrSql if it fails.
+func (ds *DataSource) UpdateUserTotpLastStep(ctx context.Context, user data.User) error {
+	return ds.DataBase.UpdateUserTotpLastStep(ctx, user)
+}
+
+// ReplaceRecoveryCodes replaces all the recovery codes of an user by the provided hashes. Returns a data.ErrSql if it
+// fails.
+func (ds *DataSource) ReplaceRecoveryCodes(ctx context.Context, userId int, codeHashes [][]byte) error {
+	return ds.DataBase.ReplaceRecoveryCodes(ctx, userId, codeHashes)
+}
+
+// ConsumeRecoveryCode deletes a recovery code of an user by its hash. Returns a data.ErrSqlNoRow if the code doesn't
+// exist or data.ErrSql if it fails.
+func (ds *DataSource) ConsumeRecoveryCode(ctx context.Context, userId int, codeHash []byte) error {
+	return ds.DataBase.ConsumeRecoveryCode(ctx, userId, codeHash)
+}
+
+// ReplacePasswordResetToken replaces the password reset token of an user by the provided hash, valid until expiresAt.
+// Returns a data.ErrSql if it fails.
+func (ds *DataSource) ReplacePasswordResetToken(ctx context.Context, userId int, tokenHash []byte, expiresAt time.Time) error {
+	return ds.DataBase.ReplacePasswordResetToken(ctx, userId, tokenHash, expiresAt)
+}
+
+// CheckPasswordResetToken checks that an user has a password reset token with this hash, without consuming it. Returns a
+// data.ErrSqlNoRow if the token doesn't exist or has expired, or data.ErrSql if it fails.
+func (ds *DataSource) CheckPasswordResetToken(ctx context.Context, userId int, tokenHash []byte) error {
+	return ds.DataBase.CheckPasswordResetToken(ctx, userId, tokenHash)
+}
+
+// ConsumePasswordResetToken deletes the password reset token of an user by its hash. Returns a data.ErrSqlNoRow if the
+// token doesn't exist or has expired, or data.ErrSql if it fails.
+func (ds *DataSource) ConsumePasswordResetToken(ctx context.Context, userId int, tokenHash []byte) error {
+	return ds.DataBase.ConsumePasswordResetToken(ctx, userId, tokenHash)
+}
+
+// DeleteUser deletes a user in the database by his username, then removes his targets from the cache. His paths are
+// fetched in the same transaction as the deletion, so that the cache is only updated once it is committed. Returns a
+// data.ErrSql if it fails.
+// Logs a warning if a cache related error happens.
+func (ds *DataSource) DeleteUser(ctx context.Context, username string) error {
+	var paths []string
+
+	err := ds.DataBase.InTx(ctx, func(tx *database.DataBase) error {
+		var err error
+		paths, err = selectUserPaths(ctx, tx, username)
+
+		if err != nil {
+			return err
+		}
+
+		return tx.DeleteUser(ctx, username)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// Not canceled with the request, as the database is already updated
+	ds.deleteTargets(context.Background(), paths)
+
+	return nil
 }
 
-// DeleteUser deletes a user in the database by his username. Returns a data.ErrSql if it fails.
+// UpdateUserDisabled updates an user's disabled state in the database, then removes his targets from the cache so that
+// disabled links stop redirecting immediately. The paths are fetched in the same transaction as the update, from the
+// primary, as a replica may not have the paths created just before. Returns a data.ErrSql if it fails.
 // Logs a warning if a cache related error happens.
-func (ds *DataSource) DeleteUser(username string) error {
-	ui, err := ds.DataBase.SelectUser(username)
+func (ds *DataSource) UpdateUserDisabled(ctx context.Context, user data.User) error {
+	var paths []string
+
+	err := ds.DataBase.InTx(ctx, func(tx *database.DataBase) error {
+		err := tx.UpdateUserDisabled(ctx, user)
+
+		if err != nil {
+			return err
+		}
+
+		paths, err = selectUserPaths(ctx, tx, user.Username)
+
+		return err
+	})
 
 	if err != nil {
 		return err
 	}
 
+	// Not canceled with the request, as the database is already updated
+	ds.deleteTargets(context.Background(), paths)
+
+	return nil
+}
+
+// selectUserPaths fetches all the paths created by an user from db. Returns a data.ErrSql if it fails.
+func selectUserPaths(ctx context.Context, db *database.DataBase, username string) ([]string, error) {
+	ui, err := db.SelectUser(ctx, username)
+
+	if err != nil {
+		return nil, err
+	}
+
 	paths := make([]string, len(ui.Paths))
 
 	for i := range ui.Paths {
 		paths[i] = ui.Paths[i].Path
 	}
 
-	err = ds.Cache.DeleteTargets(paths)
+	return paths, nil
+}
 
-	if err != nil {
-		log.Warn().Err(err).Msg("error removing user targets from cache")
-	}
+// deleteTargets removes the targets of the paths from the cache, once they are changed in the database. They are read
+// from the primary database until the replicas have caught up, so that the cache is not filled again with the previous
+// targets.
+// Logs a warning if it fails.
+func (ds *DataSource) deleteTargets(ctx context.Context, paths []string) {
+	ds.DataBase.MarkPathsWritten(paths)
 
-	return ds.DataBase.DeleteUser(username)
+	if err := ds.Cache.DeleteTargets(ctx, paths); err != nil {
+		log.Warn().Err(err).Msg("error deleting targets in cache")
+	}
 }
 
 // GetTarget tries to get a target from the cache, then from the database on a miss. Returns a data.ErrSqlNoRow if the
-// target doesn't exist or data.ErrSql if it fails. The target is immediately added to the cache on a miss.
+// target doesn't exist or data.ErrSql if it fails. The target, or the fact that it doesn't exist, is immediately added
+// to the cache on a miss. A stale target is served while it is revalidated in the background, and kept if the database
+// is unreachable. If the database fails on a miss, the target is served from the snapshot, if enabled. Concurrent
+// lookups for the same path share a single database query, which keeps running if ctx is canceled, in which case a
+// data.ErrSql is returned.
 // Logs a warning if a cache related error happens.
-func (ds *DataSource) GetTarget(path string) (string, error) {
-	t, err := ds.Cache.GetTarget(path)
+func (ds *DataSource) GetTarget(ctx context.Context, path string) (string, error) {
+	t, fresh, err := ds.Cache.GetTarget(ctx, path)
 
-	if err != nil {
+	switch {
+	case errors.Is(err, data.ErrSqlNoRow):
+		return "", err
+	case err != nil:
 		log.Warn().Err(err).Msg("error getting target in cache")
 	}
 
 	if t != "" {
+		if !fresh {
+			ds.revalidateTarget(path)
+		}
+
+		ds.hits.add(path)
+
 		return t, nil
 	}
 
-	t, err = ds.DataBase.GetTarget(path)
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("%w : %s", data.ErrSql, err)
+	}
+
+	result := ds.lookups.DoChan(path, func() (interface{}, error) {
+		return ds.lookupTarget(path)
+	})
+
+	select {
+	case r := <-result:
+		if r.Err != nil {
+			return "", r.Err
+		}
+
+		ds.hits.add(path)
+
+		return r.Val.(string), nil
+	case <-ctx.Done():
+		return "", fmt.Errorf("%w : %s", data.ErrSql, ctx.Err())
+	}
+}
+
+// revalidateTarget looks up a stale target in the background, unless a lookup is already running for the path.
+// Logs a warning if the lookup fails, the stale target is then kept in the cache.
+func (ds *DataSource) revalidateTarget(path string) {
+	result := ds.lookups.DoChan(path, func() (interface{}, error) {
+		return ds.lookupTarget(path)
+	})
+
+	go func() {
+		r := <-result
+
+		if r.Err != nil && !errors.Is(r.Err, data.ErrSqlNoRow) {
+			log.Warn().Err(r.Err).Str("path", path).Msg("error revalidating stale target")
+		}
+	}()
+}
+
+// lookupTarget gets a target from the database, then replaces the cached target with it, or with the fact that the path
+// doesn't exist. The cache is left untouched if the database fails, and the target is taken from the snapshot if it
+// has one. Returns a data.ErrSqlNoRow if the target doesn't exist or data.ErrSql if it fails.
+// The lookup may be shared by several requests, so it is not canceled with any of them, and only ends with the database
+// and cache timeouts.
+// Logs a warning if a cache related error happens.
+func (ds *DataSource) lookupTarget(path string) (interface{}, error) {
+	ctx := context.Background()
+	t, err := ds.DataBase.GetTarget(ctx, path)
+
+	switch {
+	case errors.Is(err, data.ErrSqlNoRow):
+		ds.setDegraded(false)
+
+		if cacheErr := ds.Cache.AddMissingTarget(ctx, path); cacheErr != nil {
+			log.Warn().Err(cacheErr).Msg("error inserting missing path in cache")
+		}
+
+		return "", err
+	case err != nil:
+		ds.setDegraded(true)
+
+		if t, ok := ds.snapshot.get(path); ok {
+			return t, nil
+		}
 
-	if err != nil {
 		return "", err
 	}
 
-	// On cache miss
-	err = ds.Cache.AddTarget(data.Path{
+	ds.setDegraded(false)
+
+	err = ds.Cache.AddTarget(ctx, data.Path{
 		Path:   path,
 		Target: t,
 	})
@@ -122,28 +449,51 @@ func (ds *DataSource) GetTarget(path string) (string, error) {
 	return t, nil
 }
 
-// InsertPath adds a data.Path to the cache, then to the database. Returns a data.ErrSqlDuplicateRow if the path already
-// exists or data.ErrSql if the operation fails.
+// InsertPath adds a data.Path to the database, then removes the path from the cache in case it was cached as missing.
+// Returns a data.ErrSqlDuplicateRow if the path already exists or data.ErrSql if the operation fails.
 // Logs a warning if a cache related error happens.
-func (ds *DataSource) InsertPath(path data.Path) error {
-	err := ds.Cache.AddTarget(path)
+func (ds *DataSource) InsertPath(ctx context.Context, path data.Path) error {
+	err := ds.DataBase.InsertPath(ctx, path)
 
 	if err != nil {
-		log.Warn().Err(err).Msg("error inserting path in cache")
+		return err
 	}
 
-	return ds.DataBase.InsertPath(path)
+	// Not canceled with the request, as the database is already updated
+	ds.deleteTargets(context.Background(), []string{path.Path})
+
+	return nil
 }
 
-// DeletePath removes a data.Path from the cache, then deletes it in the database. Logs a warning if the cache returns
-// an error, returns a data.ErrSql if the operation fails.
+// DeletePath deletes a data.Path in the database, then removes it from the cache. Returns a data.ErrSql if the
+// operation fails.
 // Logs a warning if a cache related error happens.
-func (ds *DataSource) DeletePath(path data.Path) error {
-	err := ds.Cache.DeleteTargets([]string{path.Path})
+func (ds *DataSource) DeletePath(ctx context.Context, path data.Path) error {
+	err := ds.DataBase.DeletePath(ctx, path)
 
 	if err != nil {
-		log.Warn().Err(err).Msg("error deleting path in cache")
+		return err
 	}
 
-	return ds.DataBase.DeletePath(path)
+	// Not canceled with the request, as the database is already updated
+	ds.deleteTargets(context.Background(), []string{path.Path})
+
+	return nil
+}
+
+// UpdatePath renames a path in the database, then removes the old path and the new one, which may be cached as
+// missing, from the cache. Returns a data.ErrSqlNoRow if the path doesn't exist, data.ErrSqlDuplicateRow if the new path
+// already exists or data.ErrSql if it fails.
+// Logs a warning if a cache related error happens.
+func (ds *DataSource) UpdatePath(ctx context.Context, oldPath string, newPath string) error {
+	err := ds.DataBase.UpdatePath(ctx, oldPath, newPath)
+
+	if err != nil {
+		return err
+	}
+
+	// Not canceled with the request, as the database is already updated
+	ds.deleteTargets(context.Background(), []string{oldPath, newPath})
+
+	return nil
 }
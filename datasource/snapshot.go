@@ -0,0 +1,171 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Number of paths fetched at a time when refreshing the snapshot
+const snapshotBatchSize = 1000
+
+// snapshot keeps a copy of all the targets which can be served, in memory and in a file, so that they can still be
+// served when the database is unreachable, even after a restart.
+type snapshot struct {
+	file    string
+	mu      sync.RWMutex
+	targets map[string]string
+}
+
+// loadSnapshot returns a snapshot saved in file, loading the targets it already contains. A missing file is an empty
+// snapshot. Returns an error if the file cannot be read or parsed, along with an empty snapshot.
+func loadSnapshot(file string) (*snapshot, error) {
+	s := &snapshot{
+		file:    file,
+		targets: make(map[string]string),
+	}
+
+	content, err := ioutil.ReadFile(file)
+
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return s, err
+	}
+
+	targets := make(map[string]string)
+
+	if err := json.Unmarshal(content, &targets); err != nil {
+		return s, err
+	}
+
+	s.targets = targets
+
+	return s, nil
+}
+
+// get returns the target of a path, and whether it is in the snapshot. It is safe to call on a nil snapshot.
+func (s *snapshot) get(path string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.targets[path]
+
+	return t, ok
+}
+
+// len returns the number of targets in the snapshot.
+func (s *snapshot) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.targets)
+}
+
+// replace saves the targets to the file, then replaces the targets in memory. The file is written to a temporary file
+// first, so that it is never left partially written. Returns an error if it cannot be written, in which case the
+// previous targets are kept.
+func (s *snapshot) replace(targets map[string]string) error {
+	content, err := json.Marshal(targets)
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	_, err = tmp.Write(content)
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), s.file)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.targets = targets
+	s.mu.Unlock()
+
+	return nil
+}
+
+// RefreshSnapshot replaces the snapshot with all the targets which can currently be served. Returns a data.ErrSql if
+// the targets cannot be fetched, or an error if the snapshot cannot be written.
+func (ds *DataSource) RefreshSnapshot(ctx context.Context) error {
+	targets := make(map[string]string)
+	after := ""
+
+	for {
+		paths, err := ds.DataBase.SelectPathsAfter(ctx, after, snapshotBatchSize)
+
+		if err != nil {
+			ds.setDegraded(true)
+			return err
+		}
+
+		for _, p := range paths {
+			targets[p.Path] = p.Target
+		}
+
+		if len(paths) < snapshotBatchSize {
+			break
+		}
+
+		after = paths[len(paths)-1].Path
+	}
+
+	ds.setDegraded(false)
+
+	return ds.snapshot.replace(targets)
+}
+
+// StartSnapshot loads the snapshot saved in file, which is then served when the database is unreachable. It is
+// refreshed immediately, then every interval until the returned function is called. StartSnapshot must be called before
+// any target is looked up.
+// Logs a warning if the saved snapshot cannot be loaded or a refresh fails.
+func (ds *DataSource) StartSnapshot(file string, interval time.Duration) func() {
+	s, err := loadSnapshot(file)
+
+	if err != nil {
+		log.Warn().Err(err).Str("file", file).Msg("error loading the paths snapshot, starting with an empty one")
+	}
+
+	ds.snapshot = s
+
+	return runPeriodically(interval, true, func() {
+		if err := ds.RefreshSnapshot(context.Background()); err != nil {
+			log.Warn().Err(err).Int("paths", ds.snapshot.len()).Msg("error refreshing the paths snapshot")
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package datasource
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_snapshot(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+
+	s, err := loadSnapshot(file)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, s.len())
+
+	err = s.replace(map[string]string{"docs": "https://example.com/docs"})
+
+	assert.NoError(t, err)
+
+	target, ok := s.get("docs")
+
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.com/docs", target)
+
+	// The saved snapshot is loaded back, and no temporary file is left
+	loaded, err := loadSnapshot(file)
+
+	assert.NoError(t, err)
+
+	target, ok = loaded.get("docs")
+
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.com/docs", target)
+
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(file), "*"))
+
+	assert.Equal(t, []string{file}, files)
+}
+
+func Test_loadSnapshot_invalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte("{invalid"), 0600))
+
+	s, err := loadSnapshot(file)
+
+	assert.Error(t, err)
+	assert.Equal(t, 0, s.len())
+}
+
+func Test_snapshot_nil(t *testing.T) {
+	var s *snapshot
+
+	_, ok := s.get("docs")
+
+	assert.False(t, ok)
+}
@@ -0,0 +1,139 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"go-there/data"
+	"sync"
+	"time"
+)
+
+// hitCounter counts the accesses to each path in memory, so that the database is only updated periodically.
+type hitCounter struct {
+	mu   sync.Mutex
+	hits map[string]int64
+}
+
+// add counts an access to the path.
+func (hc *hitCounter) add(path string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if hc.hits == nil {
+		hc.hits = make(map[string]int64)
+	}
+
+	hc.hits[path]++
+}
+
+// take returns the accesses counted since the last call, and resets the counter.
+func (hc *hitCounter) take() map[string]int64 {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	hits := hc.hits
+	hc.hits = nil
+
+	return hits
+}
+
+// FlushHits adds the accesses counted since the last flush to the hits of each path in the database. The accesses are
+// lost if it fails. Returns a data.ErrSql if it fails.
+func (ds *DataSource) FlushHits(ctx context.Context) error {
+	hits := ds.hits.take()
+
+	if len(hits) == 0 {
+		return nil
+	}
+
+	return ds.DataBase.AddPathHits(ctx, hits)
+}
+
+// StartHitsFlush flushes the accesses every interval until the returned function is called, which flushes them a last
+// time.
+// Logs a warning if a flush fails.
+func (ds *DataSource) StartHitsFlush(interval time.Duration) func() {
+	flush := func() {
+		if err := ds.FlushHits(context.Background()); err != nil {
+			log.Warn().Err(err).Msg("error adding the path hits to the database")
+		}
+	}
+
+	stop := runPeriodically(interval, false, flush)
+
+	return func() {
+		stop()
+		flush()
+	}
+}
+
+// runPeriodically calls f every interval, and immediately if now is true, until the returned function is called. The
+// returned function waits for a running call to end.
+func runPeriodically(interval time.Duration, now bool, f func()) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		if now {
+			f()
+		}
+
+		for {
+			select {
+			case <-ticker.C:
+				f()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	}
+}
+
+// WarmUp adds the targets of the topPaths most accessed paths to the cache, or of all the paths if topPaths is 0. The
+// paths are fetched from the database batchSize at a time. Returns the number of cached targets, and a data.ErrSettings
+// if the sizes are invalid, or a data.ErrSql or a data.ErrRedis if it fails.
+func (ds *DataSource) WarmUp(ctx context.Context, topPaths int, batchSize int) (int, error) {
+	if topPaths < 0 || batchSize <= 0 {
+		return 0, fmt.Errorf("%w : %s", data.ErrSettings, "invalid warm-up sizes")
+	}
+
+	n := 0
+
+	for topPaths == 0 || n < topPaths {
+		limit := batchSize
+
+		if topPaths > 0 && topPaths-n < limit {
+			limit = topPaths - n
+		}
+
+		paths, err := ds.DataBase.SelectPathsByHits(ctx, limit, n)
+
+		if err != nil {
+			return n, err
+		}
+
+		for _, p := range paths {
+			if err := ds.Cache.AddTarget(ctx, p); err != nil {
+				return n, err
+			}
+
+			n++
+		}
+
+		if len(paths) < limit {
+			break
+		}
+	}
+
+	return n, nil
+}
@@ -1,6 +1,7 @@
 package gopath
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"go-there/auth"
 	"go-there/config"
@@ -10,9 +11,14 @@ import (
 
 // DataSourcer represents the database.DataSource methods needed by the gopath package to access the data.
 type DataSourcer interface {
-	SelectUserLogin(username string) (data.User, error)
-	SelectUserLoginByApiKeyHash(apiKeyHash string) (data.User, error)
-	GetTarget(path string) (string, error)
+	SelectUserLogin(ctx context.Context, username string) (data.User, error)
+	SelectUserLoginByApiKeyHash(ctx context.Context, apiKeyHash string) (data.User, error)
+	SelectApiKeyLogin(ctx context.Context, keyHash string) (data.User, data.ApiKey, error)
+	SelectUserLoginByApiKeyId(ctx context.Context, keyId string) (data.User, error)
+	SelectApiKeyLoginById(ctx context.Context, keyId string) (data.User, data.ApiKey, error)
+	UpdateApiKeyLastUsed(ctx context.Context, id int) error
+	UpdateUserPassword(ctx context.Context, user data.User) error
+	GetTarget(ctx context.Context, path string) (string, error)
 }
 
 // Init initializes the redirect paths from the provided configuration and add them to the *gin.Engine.
@@ -26,7 +32,7 @@ func Init(conf *config.Configuration, e *gin.Engine, ds DataSourcer) {
 		}
 
 		if ep.Auth {
-			goPath.Use(auth.GetAuthMiddleware(ds))
+			goPath.Use(auth.GetAuthMiddleware(ds, data.ScopePathsRead, data.ScopePathsRead))
 		}
 
 		goPath.GET("/:path", getPathHandler(ds))
@@ -10,7 +10,7 @@ import (
 // getPathHandler returns the redirection handler. If no redirection exists, then http.StatusNotFound is returned.
 func getPathHandler(ds DataSourcer) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		t, err := ds.GetTarget(c.Param("path"))
+		t, err := ds.GetTarget(c.Request.Context(), c.Param("path"))
 
 		if err != nil {
 			switch {
@@ -1,6 +1,7 @@
 package gopath
 
 import (
+	"context"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -14,15 +15,35 @@ import (
 type mockDataSourcer struct {
 }
 
-func (mockDataSourcer) SelectUserLogin(username string) (data.User, error) {
+func (mockDataSourcer) SelectUserLogin(ctx context.Context, username string) (data.User, error) {
 	return data.User{}, nil
 }
 
-func (mockDataSourcer) SelectUserLoginByApiKeyHash(apiKeyHash string) (data.User, error) {
+func (mockDataSourcer) SelectUserLoginByApiKeyHash(ctx context.Context, apiKeyHash string) (data.User, error) {
 	return data.User{}, nil
 }
 
-func (mockDataSourcer) GetTarget(path string) (string, error) {
+func (mockDataSourcer) SelectUserLoginByApiKeyId(ctx context.Context, keyId string) (data.User, error) {
+	return data.User{}, data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) SelectApiKeyLoginById(ctx context.Context, keyId string) (data.User, data.ApiKey, error) {
+	return data.User{}, data.ApiKey{}, data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) SelectApiKeyLogin(ctx context.Context, keyHash string) (data.User, data.ApiKey, error) {
+	return data.User{}, data.ApiKey{}, data.ErrSqlNoRow
+}
+
+func (mockDataSourcer) UpdateApiKeyLastUsed(ctx context.Context, id int) error {
+	return nil
+}
+
+func (mockDataSourcer) UpdateUserPassword(ctx context.Context, user data.User) error {
+	return nil
+}
+
+func (mockDataSourcer) GetTarget(ctx context.Context, path string) (string, error) {
 	switch path {
 	case "valid_path":
 		return "http://www.example.com", nil
@@ -3,15 +3,38 @@ package health
 import (
 	"github.com/gin-gonic/gin"
 	"go-there/config"
+	"go-there/data"
 	"net/http"
+	"time"
 )
 
+// DataSourcer represents the methods needed to report the state of the data source.
+type DataSourcer interface {
+	DegradedSince() time.Time
+}
+
 // Init initializes the API paths from the provided configuration and add them to the *gin.Engine.
-func Init(conf *config.Configuration, e *gin.Engine) {
+func Init(conf *config.Configuration, e *gin.Engine, ds DataSourcer) {
 	ep := conf.Endpoints["health"]
 	if ep.Enabled {
-		e.GET("/health", func(c *gin.Context) {
-			c.Status(http.StatusOK)
+		e.GET("/health", getHealthHandler(ds))
+	}
+}
+
+// getHealthHandler reports whether the database is reachable. The status is 200 in both cases, as the redirections are
+// still served in degraded mode.
+func getHealthHandler(ds DataSourcer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		since := ds.DegradedSince()
+
+		if since.IsZero() {
+			c.JSON(http.StatusOK, data.HealthResponse{Status: "ok"})
+			return
+		}
+
+		c.JSON(http.StatusOK, data.HealthResponse{
+			Status:        "degraded",
+			DegradedSince: &since,
 		})
 	}
 }
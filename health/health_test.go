@@ -0,0 +1,56 @@
+package health
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockDataSourcer struct {
+	degradedSince time.Time
+}
+
+func (m mockDataSourcer) DegradedSince() time.Time {
+	return m.degradedSince
+}
+
+func Test_getHealthHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		ds       mockDataSourcer
+		wantBody string
+	}{
+		{
+			name:     "ok",
+			ds:       mockDataSourcer{},
+			wantBody: `{"status":"ok"}`,
+		},
+		{
+			name:     "degraded",
+			ds:       mockDataSourcer{degradedSince: time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)},
+			wantBody: `{"status":"degraded","degraded_since":"2021-06-01T12:00:00Z"}`,
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := gin.New()
+			Init(&config.Configuration{
+				Endpoints: map[string]config.Endpoint{"health": {Enabled: true}},
+			}, e, tt.ds)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/health", nil)
+			e.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.JSONEq(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
@@ -23,6 +23,11 @@ func GetLoggingMiddleware() func(c *gin.Context) {
 			logInfo.User = li.(string)
 		}
 
+		// Set if the user is impersonated
+		if la, ok := c.Keys["logActor"].(string); ok {
+			logInfo.Actor = la
+		}
+
 		logInfo.HttpCode = c.Writer.Status()
 
 		ginErr := c.Errors.Last()
@@ -34,6 +39,7 @@ func GetLoggingMiddleware() func(c *gin.Context) {
 				Str("endpoint", logInfo.Endpoint).
 				Str("ip", logInfo.Ip).
 				Str("user", logInfo.User).
+				Str("actor", logInfo.Actor).
 				Err(ginErr.Err).
 				Send()
 		} else {
@@ -43,6 +49,7 @@ func GetLoggingMiddleware() func(c *gin.Context) {
 				Str("endpoint", logInfo.Endpoint).
 				Str("ip", logInfo.Ip).
 				Str("user", logInfo.User).
+				Str("actor", logInfo.Actor).
 				Send()
 		}
 	}
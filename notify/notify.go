@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"go-there/config"
+	"go-there/data"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeLocal = "local"
+	TypeSmtp  = "smtp"
+)
+
+const defaultSmtpPort = 587
+
+// Notifier sends messages to the users, at their email address.
+type Notifier interface {
+	Notify(to string, subject string, body string) error
+}
+
+// Message represents a message sent by a notifier.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Init returns the notifier configured in the Notifier section. The local notifier is used if no type is configured.
+// Returns a data.ErrSettings if the configuration is invalid.
+func Init(conf *config.Configuration) (Notifier, error) {
+	switch strings.ToLower(conf.Notifier.Type) {
+	case "", TypeLocal:
+		return NewLocalNotifier(), nil
+	case TypeSmtp:
+		return NewSmtpNotifier(conf.Notifier)
+	default:
+		return nil, fmt.Errorf("%w : unknown notifier type %s", data.ErrSettings, conf.Notifier.Type)
+	}
+}
+
+// LocalNotifier keeps the messages in memory and logs them instead of sending them. It should only be used for tests
+// and development, as the messages contain secrets.
+type LocalNotifier struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+// NewLocalNotifier returns an empty *LocalNotifier.
+func NewLocalNotifier() *LocalNotifier {
+	return &LocalNotifier{}
+}
+
+// Notify stores and logs the message. It never fails.
+func (n *LocalNotifier) Notify(to string, subject string, body string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.messages = append(n.messages, Message{To: to, Subject: subject, Body: body})
+
+	log.Info().Str("to", to).Str("subject", subject).Msg(body)
+
+	return nil
+}
+
+// Messages returns a copy of all the messages sent by the notifier.
+func (n *LocalNotifier) Messages() []Message {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return append([]Message(nil), n.messages...)
+}
+
+// SmtpNotifier sends the messages by email through a SMTP server. The connection is upgraded with STARTTLS if the
+// server supports it.
+type SmtpNotifier struct {
+	address string
+	auth    smtp.Auth
+	from    mail.Address
+}
+
+// NewSmtpNotifier returns a *SmtpNotifier from the provided configuration. The credentials are optional. Returns a
+// data.ErrSettings if the server or the sender address is invalid.
+func NewSmtpNotifier(conf config.Notifier) (*SmtpNotifier, error) {
+	if conf.SmtpAddress == "" {
+		return nil, fmt.Errorf("%w : no SMTP server address", data.ErrSettings)
+	}
+
+	from, err := mail.ParseAddress(conf.From)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w : invalid sender address : %s", data.ErrSettings, err)
+	}
+
+	port := conf.SmtpPort
+
+	if port <= 0 {
+		port = defaultSmtpPort
+	}
+
+	n := &SmtpNotifier{
+		address: conf.SmtpAddress + ":" + strconv.Itoa(port),
+		from:    *from,
+	}
+
+	if conf.SmtpUser != "" {
+		n.auth = smtp.PlainAuth("", conf.SmtpUser, conf.SmtpPassword, conf.SmtpAddress)
+	}
+
+	return n, nil
+}
+
+// Notify sends the message to the provided email address. Returns an error if the address is invalid or the message
+// cannot be sent.
+func (n *SmtpNotifier) Notify(to string, subject string, body string) error {
+	rcpt, err := mail.ParseAddress(to)
+
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(n.address, n.auth, n.from.Address, []string{rcpt.Address}, n.message(*rcpt, subject, body))
+}
+
+// message builds the email sent to rcpt. The subject must not contain any line break.
+func (n *SmtpNotifier) message(rcpt mail.Address, subject string, body string) []byte {
+	var sb strings.Builder
+
+	sb.WriteString("From: " + n.from.String() + "\r\n")
+	sb.WriteString("To: " + rcpt.String() + "\r\n")
+	sb.WriteString("Subject: " + strings.NewReplacer("\r", "", "\n", "").Replace(subject) + "\r\n")
+	sb.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	sb.WriteString("\r\n")
+	sb.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return []byte(sb.String())
+}
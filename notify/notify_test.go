@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go-there/config"
+	"go-there/data"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     config.Notifier
+		wantType Notifier
+		wantErr  error
+	}{
+		{
+			name:     "ok_default",
+			conf:     config.Notifier{},
+			wantType: &LocalNotifier{},
+		},
+		{
+			name:     "ok_local",
+			conf:     config.Notifier{Type: "local"},
+			wantType: &LocalNotifier{},
+		},
+		{
+			name:     "ok_smtp",
+			conf:     config.Notifier{Type: "smtp", SmtpAddress: "localhost", From: "go-there@example.com"},
+			wantType: &SmtpNotifier{},
+		},
+		{
+			name:    "smtp_no_address",
+			conf:    config.Notifier{Type: "smtp", From: "go-there@example.com"},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name:    "smtp_invalid_from",
+			conf:    config.Notifier{Type: "smtp", SmtpAddress: "localhost", From: "go-there"},
+			wantErr: data.ErrSettings,
+		},
+		{
+			name:    "unknown_type",
+			conf:    config.Notifier{Type: "pigeon"},
+			wantErr: data.ErrSettings,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Init(&config.Configuration{Notifier: tt.conf})
+
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr))
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.IsType(t, tt.wantType, n)
+		})
+	}
+}
+
+func TestLocalNotifier(t *testing.T) {
+	n := NewLocalNotifier()
+
+	assert.Empty(t, n.Messages())
+	assert.Nil(t, n.Notify("alice@example.com", "subject", "body"))
+	assert.Equal(t, []Message{{To: "alice@example.com", Subject: "subject", Body: "body"}}, n.Messages())
+}
+
+func TestSmtpNotifier_message(t *testing.T) {
+	n, err := NewSmtpNotifier(config.Notifier{SmtpAddress: "localhost", From: "go-there@example.com"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:587", n.address)
+	assert.Nil(t, n.auth)
+
+	msg := string(n.message(mail.Address{Address: "alice@example.com"}, "subject\r\nBcc: bob@example.com", "line1\nline2"))
+
+	assert.True(t, strings.HasPrefix(msg, "From: <go-there@example.com>\r\nTo: <alice@example.com>\r\n"))
+	assert.Contains(t, msg, "Subject: subjectBcc: bob@example.com\r\n")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\nline1\r\nline2"))
+}
@@ -2,14 +2,120 @@ package server
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 	"go-there/config"
+	"go-there/data"
 	"golang.org/x/crypto/acme/autocert"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
+// InitEngine applies the settings of the gin engine. The client IP address, used by the logs and the brute-force
+// protection, is the address of the connection, or the one forwarded in the X-Forwarded-For header if the connection
+// comes from a configured trusted proxy. It must be called before the routes are added. Returns a data.ErrSettings if a
+// trusted proxy is invalid.
+func InitEngine(conf *config.Configuration, e *gin.Engine) error {
+	proxies, err := parseTrustedProxies(conf.Server.TrustedProxies)
+
+	if err != nil {
+		return err
+	}
+
+	// gin trusts the headers of every client by default, and only applies its own trusted proxies when it starts the
+	// server itself
+	e.ForwardedByClientIP = false
+
+	if len(proxies) > 0 {
+		e.Use(getForwardedIpMiddleware(proxies))
+	}
+
+	return nil
+}
+
+// parseTrustedProxies parses IP addresses and CIDR ranges. Returns a data.ErrSettings if one is invalid.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+
+			if ip == nil {
+				return nil, fmt.Errorf("%w : invalid trusted proxy %s", data.ErrSettings, p)
+			}
+
+			if ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(p)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w : invalid trusted proxy %s", data.ErrSettings, p)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// getForwardedIpMiddleware replaces the remote address of the requests sent by a trusted proxy with the client address
+// it forwarded: the last address of the X-Forwarded-For header which is not a trusted proxy. The request is left
+// unchanged if the header is missing or invalid.
+func getForwardedIpMiddleware(proxies []*net.IPNet) gin.HandlerFunc {
+	trusted := func(ip net.IP) bool {
+		for _, n := range proxies {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return func(c *gin.Context) {
+		host, port, err := net.SplitHostPort(c.Request.RemoteAddr)
+
+		if err != nil || !trusted(net.ParseIP(host)) {
+			return
+		}
+
+		header := c.GetHeader("X-Forwarded-For")
+
+		if header == "" {
+			return
+		}
+
+		items := strings.Split(header, ",")
+		var client net.IP
+
+		// Each proxy appends the address it received the request from, only the ones added by trusted proxies are kept
+		for i := len(items) - 1; i >= 0; i-- {
+			client = net.ParseIP(strings.TrimSpace(items[i]))
+
+			if client == nil {
+				return
+			}
+
+			if !trusted(client) {
+				break
+			}
+		}
+
+		c.Request.RemoteAddr = net.JoinHostPort(client.String(), port)
+	}
+}
+
 // Start initializes and starts configured http and https servers.
 // It returns (server *http.Server, tlsServer *http.Server). If a server is not configured, nil is returned.
 func Start(conf *config.Configuration, e *gin.Engine) (*http.Server, *http.Server) {
@@ -59,6 +165,26 @@ func Start(conf *config.Configuration, e *gin.Engine) (*http.Server, *http.Serve
 			conf.Server.KeyPath = ""
 		}
 
+		// Verify the client certificates against the configured CA
+		if conf.Server.ClientCaPath != "" {
+			pool, err := loadClientCa(conf.Server.ClientCaPath)
+
+			if err != nil {
+				log.Fatal().Err(err).Msg("error loading client CA")
+			}
+
+			if tlsServer.TLSConfig == nil {
+				tlsServer.TLSConfig = &tls.Config{}
+			}
+
+			tlsServer.TLSConfig.ClientCAs = pool
+			tlsServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+			if conf.Server.ClientCertRequired {
+				tlsServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		}
+
 		go func() {
 			if err := tlsServer.ListenAndServeTLS(conf.Server.CertPath, conf.Server.KeyPath); err != http.ErrServerClosed {
 				log.Fatal().Err(err).Send()
@@ -68,3 +194,21 @@ func Start(conf *config.Configuration, e *gin.Engine) (*http.Server, *http.Serve
 
 	return s, tlsServer
 }
+
+// loadClientCa reads a PEM bundle of CA certificates used to verify the client certificates. Returns a data.ErrInit if
+// the file cannot be read or contains no certificate.
+func loadClientCa(path string) (*x509.CertPool, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w : %s", data.ErrInit, err)
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("%w : %s", data.ErrInit, "no certificate found in client CA bundle")
+	}
+
+	return pool, nil
+}
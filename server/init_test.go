@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go-there/auth"
+	"go-there/config"
+	"go-there/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInitEngine(t *testing.T) {
+	type args struct {
+		proxies    []string
+		remoteAddr string
+		forwarded  string
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr error
+	}{
+		{
+			name: "no_proxy",
+			args: args{
+				remoteAddr: "192.0.2.1:1234",
+				forwarded:  "10.0.0.1",
+			},
+			want: "192.0.2.1",
+		},
+		{
+			name: "untrusted_proxy",
+			args: args{
+				proxies:    []string{"192.0.2.10"},
+				remoteAddr: "192.0.2.1:1234",
+				forwarded:  "10.0.0.1",
+			},
+			want: "192.0.2.1",
+		},
+		{
+			name: "trusted_proxy",
+			args: args{
+				proxies:    []string{"192.0.2.0/24"},
+				remoteAddr: "192.0.2.1:1234",
+				forwarded:  "10.0.0.1",
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name: "trusted_proxy_spoofed",
+			args: args{
+				proxies:    []string{"192.0.2.0/24"},
+				remoteAddr: "192.0.2.1:1234",
+				forwarded:  "10.0.0.9, 10.0.0.1, 192.0.2.2",
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name: "trusted_proxy_invalid_header",
+			args: args{
+				proxies:    []string{"192.0.2.1", "2001:db8::1"},
+				remoteAddr: "192.0.2.1:1234",
+				forwarded:  "invalid",
+			},
+			want: "192.0.2.1",
+		},
+		{
+			name: "invalid_proxy",
+			args: args{
+				proxies: []string{"192.0.2.300"},
+			},
+			wantErr: data.ErrSettings,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+			err := InitEngine(&config.Configuration{Server: config.Server{TrustedProxies: tt.args.proxies}}, e)
+
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr))
+				return
+			}
+
+			assert.Nil(t, err)
+
+			got := ""
+
+			e.GET("/ping", func(c *gin.Context) {
+				got = c.ClientIP()
+			})
+
+			req, _ := http.NewRequest("GET", "/ping", nil)
+			req.RemoteAddr = tt.args.remoteAddr
+			req.Header.Set("X-Forwarded-For", tt.args.forwarded)
+
+			e.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestInitEngineLimiterKey(t *testing.T) {
+	auth.ApplyLoginSettings(&config.Configuration{Login: config.Login{
+		ProtectionEnabled: true,
+		LockoutSec:        60,
+	}}, nil)
+
+	defer auth.ApplyLoginSettings(&config.Configuration{}, nil)
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+
+	assert.Nil(t, InitEngine(&config.Configuration{}, e))
+
+	e.GET("/reset/:user", func(c *gin.Context) {
+		if auth.AbortIfPasswordResetLimited(c, c.Param("user")) {
+			return
+		}
+
+		auth.RegisterPasswordResetRequest(c.Param("user"), c.ClientIP())
+	})
+
+	request := func(user string, remoteAddr string, forwarded string) int {
+		req, _ := http.NewRequest("GET", "/reset/"+user, nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwarded)
+
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	// The attacker sends the address of the victim
+	assert.Equal(t, http.StatusOK, request("alice", "192.0.2.1:1234", "192.0.2.9"))
+
+	// Rotating the header doesn't reset the limit of the attacker
+	assert.Equal(t, http.StatusTooManyRequests, request("bob", "192.0.2.1:1234", "10.0.0.1"))
+
+	// The victim is not limited
+	assert.Equal(t, http.StatusOK, request("carol", "192.0.2.9:1234", ""))
+}